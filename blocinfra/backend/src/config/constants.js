@@ -4,10 +4,16 @@
 module.exports = {
     // Transaction Types
     TX_TYPES: {
+        REGISTER_PRODUCT: 'RegisterProduct',
+        GET_PRODUCT: 'GetProduct',
+        GET_ALL_PRODUCTS: 'GetAllProducts',
+        CREATE_BATCH: 'CreateBatch',
+        RELEASE_BATCH: 'ReleaseBatch',
         CREATE_STRIP: 'CreateStrip',
         SEAL_BOX: 'SealBox',
         SEAL_CARTON: 'SealCarton',  // Changed from SealKarton
         SEAL_SHIPMENT: 'SealShipment',
+        SEAL_PALLET: 'SealPallet',
         DISTRIBUTE_SHIPMENT: 'DistributeShipment',
         SCAN_BARCODE: 'ScanBarcode',
         GET_AVAILABLE_STRIPS: 'GetAvailableStrips',
@@ -17,16 +23,43 @@ module.exports = {
         INIT_LEDGER: 'InitLedger',
         // Order related
         CREATE_ORDER: 'CreateOrder',
+        CANCEL_ORDER: 'CancelOrder',
+        ADD_SHIPMENTS_TO_ORDER: 'AddShipmentsToOrder',
+        REMOVE_SHIPMENTS_FROM_ORDER: 'RemoveShipmentsFromOrder',
         DISPATCH_ORDER: 'DispatchOrder',
         DELIVER_ORDER: 'DeliverOrder',
+        RECEIVE_ORDER: 'ReceiveOrder',
+        GET_ORDER_RECEIPT: 'GetOrderReceipt',
+        GET_DISCREPANCIES_BY_SENDER: 'GetDiscrepanciesBySender',
+        GET_ALLOCATION_CONFLICTS: 'GetAllocationConflicts',
         GET_ALL_ORDERS: 'GetAllOrders',
         GET_ORDERS_BY_RECIPIENT: 'GetOrdersByRecipient',
         GET_ORDER: 'GetOrder',
+        // Custody transfers
+        PROPOSE_TRANSFER: 'ProposeTransfer',
+        ACCEPT_TRANSFER: 'AcceptTransfer',
+        REJECT_TRANSFER: 'RejectTransfer',
+        GET_TRANSFER: 'GetTransfer',
+        GET_PENDING_TRANSFERS: 'GetPendingTransfers',
+        GET_CUSTODY_HISTORY: 'GetCustodyHistory',
+        // Paginated queries (CouchDB bookmarks)
+        GET_ALL_ITEMS_WITH_PAGINATION: 'GetAllItemsWithPagination',
+        GET_ALL_ORDERS_WITH_PAGINATION: 'GetAllOrdersWithPagination',
+        GET_ORDERS_BY_RECIPIENT_WITH_PAGINATION: 'GetOrdersByRecipientWithPagination',
+        GET_AVAILABLE_STRIPS_WITH_PAGINATION: 'GetAvailableStripsWithPagination',
+        GET_AVAILABLE_BOXES_WITH_PAGINATION: 'GetAvailableBoxesWithPagination',
+        GET_AVAILABLE_CARTONS_WITH_PAGINATION: 'GetAvailableCartonsWithPagination',
+        GET_AVAILABLE_SHIPMENTS_WITH_PAGINATION: 'GetAvailableShipmentsWithPagination',
+        QUERY_ITEMS: 'QueryItems',
+        // Statistics counters
+        GET_STATISTICS: 'GetStatistics',
+        GET_STATISTICS_BREAKDOWN: 'GetStatisticsBreakdown',
+        GET_ITEM_STATISTICS: 'GetItemStatistics',
         // Blockchain trace functions (matches chaincodeV2)
         GET_FULL_TRACE_FROM_BLOCKCHAIN: 'GetFullTraceFromBlockchain',
         GET_TRACE_BY_TX_HASH: 'GetTraceByTxHash',
         GET_ITEM_HISTORY_FROM_BLOCKCHAIN: 'GetItemHistoryFromBlockchain',
-        GET_ITEM_BY_CREATION_TX_HASH: 'GetItemByCreationTxHash'
+        GET_ITEMS_BY_CREATION_TX_HASH: 'GetItemsByCreationTxHash'
     },
 
     // Item Status
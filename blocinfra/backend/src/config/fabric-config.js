@@ -9,6 +9,9 @@ class FabricConfig {
         this.channelName = process.env.CHANNEL_NAME || 'mychannel';
         this.chaincodeName = process.env.CHAINCODE_NAME || 'pharma';
         this.mspId = process.env.MSP_ID || 'Org1MSP';
+        // Enrolled user to submit as, e.g. Manufacturer1 for the role-bearing
+        // users of registerEnroll.sh; the org admin may invoke everything
+        this.fabricUser = process.env.FABRIC_USER || 'Admin';
 
         // Must match TLS cert CN/SAN
         this.peerEndpoint = process.env.PEER_ENDPOINT || 'localhost:7051';
@@ -80,12 +83,12 @@ class FabricConfig {
     async loadCredentials() {
         const certPath = path.join(
             this.orgPath,
-            'users/Admin@org1.example.com/msp/signcerts/cert.pem'
+            `users/${this.fabricUser}@org1.example.com/msp/signcerts/cert.pem`
         );
 
         const keyDir = path.join(
             this.orgPath,
-            'users/Admin@org1.example.com/msp/keystore'
+            `users/${this.fabricUser}@org1.example.com/msp/keystore`
         );
 
         const tlsCertPath = path.join(
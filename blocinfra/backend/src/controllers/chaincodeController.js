@@ -367,16 +367,16 @@ const chaincodeController = {
 
     async distributeShipment(req, res) {
         try {
-            const { shipmentId, distributor } = req.body;
+            const { shipmentId } = req.body;
 
-            if (!shipmentId || !distributor) {
+            if (!shipmentId) {
                 return res.status(400).json({
                     success: false,
-                    message: 'Missing required fields'
+                    message: 'Missing required field: shipmentId'
                 });
             }
 
-            const result = await fabricService.distributeShipment(shipmentId, distributor);
+            const result = await fabricService.distributeShipment(shipmentId);
 
             // Add transaction to history
             addTransaction('Distribute Shipment', result.txId || 'N/A');
@@ -608,6 +608,40 @@ const chaincodeController = {
         }
     },
 
+    // Get one page of items through the chaincode, using its bookmarks instead of skip
+    async getItemsPage(req, res) {
+        try {
+            const { type } = req.params;
+            const pageSize = Math.min(parseInt(req.query.pageSize) || 50, 1000); // Chaincode caps pages at 1000
+            const bookmark = req.query.bookmark || '';
+
+            if (!Object.values(DOC_TYPES).includes(type)) {
+                return res.status(400).json({
+                    success: false,
+                    message: 'Invalid item type'
+                });
+            }
+
+            const page = await fabricService.getAllItemsPage(type, pageSize, bookmark);
+            const records = page.records || [];
+            res.json({
+                success: true,
+                count: page.fetchedRecordsCount,
+                bookmark: page.bookmark,
+                hasMore: Boolean(page.bookmark), // The chaincode returns an empty bookmark after the last page
+                data: records
+            });
+        } catch (error) {
+            console.error('Get items page error:', error);
+            errorTransactionCount++;
+            res.status(500).json({
+                success: false,
+                message: 'Failed to get items',
+                error: error.message
+            });
+        }
+    },
+
     // Search items
     async searchItems(req, res) {
         try {
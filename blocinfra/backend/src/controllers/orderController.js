@@ -6,11 +6,8 @@ const orderController = {
         try {
             const { orderId, shipmentIds, receiverId, receiverOrg } = req.body;
 
-            // Sender info comes from the authenticated user
-            const senderId = req.user?.username;
-            const senderOrg = req.user?.org;
-
-            if (!senderId || !senderOrg) {
+            // The chaincode records the submitting identity as sender; only authenticated users may order
+            if (!req.user?.username || !req.user?.org) {
                 return res.status(401).json({
                     success: false,
                     message: 'User authentication required'
@@ -34,8 +31,6 @@ const orderController = {
             const result = await fabricService.createOrder(
                 orderId,
                 shipmentIds,
-                senderId,
-                senderOrg,
                 receiverId,
                 receiverOrg
             );
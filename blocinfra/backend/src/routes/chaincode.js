@@ -40,6 +40,7 @@ router.get('/statistics', chaincodeController.getStatistics);
 // Generic item routes
 router.get('/items/:type', chaincodeController.getAllItems);
 router.get('/items/:type/paginated', chaincodeController.getItemsPaginated);
+router.get('/items/:type/page', chaincodeController.getItemsPage);
 router.get('/search', chaincodeController.searchItems);
 
 // QR Code generation (one-time only)
@@ -1,3 +1,4 @@
+const crypto = require('crypto');
 const fabricService = require('./fabricService');
 const { BATCH_PREFIXES } = require('../config/constants');
 
@@ -109,6 +110,30 @@ class DataGenerator {
         return new Promise(resolve => setTimeout(resolve, ms));
     }
 
+    // Derive a stable demo GTIN-14 for a product name (company prefix 0890000 + item reference + check digit)
+    productGtin(product) {
+        const hash = crypto.createHash('sha256').update(product.toUpperCase()).digest();
+        const itemReference = (hash.readUInt32BE(0) % 1000000).toString().padStart(6, '0');
+        const digits = `0890000${itemReference}`;
+        let sum = 0;
+        for (let i = 0; i < digits.length; i++) {
+            sum += Number(digits[digits.length - 1 - i]) * (i % 2 === 0 ? 3 : 1);
+        }
+        return digits + ((10 - (sum % 10)) % 10);
+    }
+
+    // Register the product in the master data the first time it is produced
+    async ensureProduct(product) {
+        const gtin = this.productGtin(product);
+        try {
+            await fabricService.getProduct(gtin);
+        } catch (error) {
+            await fabricService.registerProduct(gtin, '', product, 'N/A', 'tablet', TABLETS_PER_STRIP, 'Store below 25°C');
+            console.log(`Registered product ${product} as GTIN ${gtin}`);
+        }
+        return gtin;
+    }
+
     // Create a single strip
     async createStrip(product, batchNumber) {
         const stripId = this.generateId(product, 'STRIP');
@@ -254,6 +279,23 @@ class DataGenerator {
         this.batchCounter++;
         const batchNumber = `BATCH-${product}-${this.batchCounter.toString().padStart(4, '0')}`;
 
+        // Register and release the batch so strips can be created against it
+        try {
+            const gtin = await this.ensureProduct(product);
+            await fabricService.createBatch(batchNumber, gtin, 'Main Plant', plan.totalStrips);
+            const coaHash = crypto.createHash('sha256').update(`${batchNumber}:${Date.now()}`).digest('hex');
+            await fabricService.releaseBatch(batchNumber, plan.totalStrips, coaHash);
+        } catch (error) {
+            console.error(`Failed to register batch ${batchNumber}:`, error.message);
+            this.stats.errors.push({
+                type: 'batch',
+                id: batchNumber,
+                error: error.message,
+                timestamp: new Date()
+            });
+            return;
+        }
+
         // Pending items waiting to be packed
         const pendingStrips = [];
         const pendingBoxes = [];
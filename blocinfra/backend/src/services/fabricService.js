@@ -117,6 +117,92 @@ class FabricService {
         return this.resultToString(result) || 'Ledger initialized';
     }
 
+    // Product master data - batches reference a registered product by GTIN
+    async registerProduct(gtin, ndc, name, strength, dosageForm, packSize, storageConditions) {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.REGISTER_PRODUCT, {
+            arguments: [gtin, ndc, name, strength, dosageForm, String(packSize), storageConditions]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        this.addToHistory(txId, 'REGISTER_PRODUCT', gtin, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
+    async getProduct(gtin) {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_PRODUCT, gtin);
+        return this.parseResult(result);
+    }
+
+    async getAllProducts() {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_ALL_PRODUCTS);
+        return this.parseResult(result) || [];
+    }
+
+    // Batch Operations - strips can only be created against a released batch
+    async createBatch(batchNumber, gtin, manufacturingSite, quantityPlanned) {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.CREATE_BATCH, {
+            arguments: [batchNumber, gtin, manufacturingSite, String(quantityPlanned)]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        this.addToHistory(txId, 'CREATE_BATCH', batchNumber, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
+    async releaseBatch(batchNumber, quantityProduced, coaHash) {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.RELEASE_BATCH, {
+            arguments: [batchNumber, String(quantityProduced), coaHash]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        this.addToHistory(txId, 'RELEASE_BATCH', batchNumber, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
     // Strip Operations - with txId tracking
     async createStrip(id, batchNumber, medicineType, mfgDate, expDate) {
         await this.ensureConnected();
@@ -243,17 +329,44 @@ class FabricService {
         return { ...data, txId };
     }
 
+    // Pallet Operations - a pallet holds either cartons or whole shipments
+    async sealPallet(palletId, itemIds) {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.SEAL_PALLET, {
+            arguments: [palletId, JSON.stringify(itemIds)]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        // Record transaction: pallet contains cartons or shipments (children)
+        this.addToHistory(txId, 'SEAL_PALLET', data && data.id ? data.id : palletId, 'success', null, itemIds);
+
+        return { ...data, txId };
+    }
+
     async getAvailableShipments() {
         await this.ensureConnected();
         const result = await this.contract.evaluateTransaction(TX_TYPES.GET_AVAILABLE_SHIPMENTS);
         return this.parseResult(result) || [];
     }
 
-    async distributeShipment(shipmentId, distributor) {
+    async distributeShipment(shipmentId) {
         await this.ensureConnected();
 
+        // The chaincode records the gateway identity as the distributor
         const proposal = this.contract.newProposal(TX_TYPES.DISTRIBUTE_SHIPMENT, {
-            arguments: [shipmentId, distributor]
+            arguments: [shipmentId]
         });
         const txn = await proposal.endorse();
         const txId = txn.getTransactionId();
@@ -357,21 +470,22 @@ class FabricService {
         return this.parseResult(result);
     }
 
-    // Get item by its unique creation transaction hash
-    // This searches by the creationTxId field which is unique to each item
-    async getItemByCreationTxHash(txHash) {
+    // Get the items created by a transaction, by their creationTxId field
+    // Usually one item, but a bulk strip creation shares its transaction across every strip
+    async getItemsByCreationTxHash(txHash) {
         await this.ensureConnected();
-        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_ITEM_BY_CREATION_TX_HASH, txHash);
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_ITEMS_BY_CREATION_TX_HASH, txHash);
         return this.parseResult(result);
     }
 
     // Order Operations - with txId tracking
-    async createOrder(orderId, shipmentIds, senderId, senderOrg, receiverId, receiverOrg) {
+    async createOrder(orderId, shipmentIds, receiverId, receiverOrg) {
         await this.ensureConnected();
 
-        // Chaincode expects: orderID, itemIDsJSON (shipment IDs), senderId, senderOrg, receiverId, receiverOrg
+        // Chaincode expects: orderID, itemIDsJSON (shipment IDs), receiverId, receiverOrg
+        // The sender is taken from the gateway identity that submits the transaction
         const proposal = this.contract.newProposal(TX_TYPES.CREATE_ORDER, {
-            arguments: [orderId, JSON.stringify(shipmentIds), senderId, senderOrg, receiverId, receiverOrg]
+            arguments: [orderId, JSON.stringify(shipmentIds), receiverId, receiverOrg]
         });
         const txn = await proposal.endorse();
         const txId = txn.getTransactionId();
@@ -392,6 +506,81 @@ class FabricService {
         return { ...data, txId };
     }
 
+    async cancelOrder(orderId, reason = '') {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.CANCEL_ORDER, {
+            arguments: [orderId, reason]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        // Record cancellation: the order's shipments are released
+        this.addToHistory(txId, 'CANCEL_ORDER', orderId, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
+    async addShipmentsToOrder(orderId, shipmentIds) {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.ADD_SHIPMENTS_TO_ORDER, {
+            arguments: [orderId, JSON.stringify(shipmentIds)]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        // Record amendment: the added shipments become children of the order
+        this.addToHistory(txId, 'ADD_SHIPMENTS_TO_ORDER', orderId, 'success', null, shipmentIds);
+
+        return { ...data, txId };
+    }
+
+    async removeShipmentsFromOrder(orderId, shipmentIds) {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.REMOVE_SHIPMENTS_FROM_ORDER, {
+            arguments: [orderId, JSON.stringify(shipmentIds)]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        // Record amendment: the removed shipments are available again
+        this.addToHistory(txId, 'REMOVE_SHIPMENTS_FROM_ORDER', orderId, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
     async dispatchOrder(orderId) {
         await this.ensureConnected();
 
@@ -442,6 +631,51 @@ class FabricService {
         return { ...data, txId };
     }
 
+    async receiveOrder(orderId, receivedIds, damagedIds = []) {
+        await this.ensureConnected();
+
+        // Chaincode expects: orderID, receivedIDsJSON (scanned unit IDs), damagedIDsJSON
+        const proposal = this.contract.newProposal(TX_TYPES.RECEIVE_ORDER, {
+            arguments: [orderId, JSON.stringify(receivedIds), JSON.stringify(damagedIds)]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        // Record receipt transaction: the result is the receipt with its discrepancies
+        this.addToHistory(txId, 'RECEIVE_ORDER', orderId, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
+    async getOrderReceipt(orderId) {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_ORDER_RECEIPT, orderId);
+        return this.parseResult(result);
+    }
+
+    async getDiscrepanciesBySender(senderOrg) {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_DISCREPANCIES_BY_SENDER, senderOrg);
+        return this.parseResult(result) || [];
+    }
+
+    // Consistency report: shipments and pallets claimed by more than one order
+    async getAllocationConflicts() {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_ALLOCATION_CONFLICTS);
+        return this.parseResult(result) || [];
+    }
+
     async getOrder(orderId) {
         await this.ensureConnected();
         const result = await this.contract.evaluateTransaction(TX_TYPES.GET_ORDER, orderId);
@@ -460,6 +694,100 @@ class FabricService {
         return this.parseResult(result) || [];
     }
 
+    // Custody transfers - custody only moves once the receiving organization accepts
+    async proposeTransfer(itemId, toOrg, ownership = false) {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.PROPOSE_TRANSFER, {
+            arguments: [itemId, toOrg, String(Boolean(ownership))]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        // Record the proposal against the item being handed over
+        this.addToHistory(txId, 'PROPOSE_TRANSFER', itemId, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
+    async acceptTransfer(transferId) {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.ACCEPT_TRANSFER, {
+            arguments: [transferId]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        // Record acceptance against the transferred item
+        this.addToHistory(txId, 'ACCEPT_TRANSFER', data && data.itemId ? data.itemId : transferId, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
+    async rejectTransfer(transferId, reason = '') {
+        await this.ensureConnected();
+
+        const proposal = this.contract.newProposal(TX_TYPES.REJECT_TRANSFER, {
+            arguments: [transferId, reason]
+        });
+        const txn = await proposal.endorse();
+        const txId = txn.getTransactionId();
+        const commit = await txn.submit();
+
+        // Wait for transaction to be committed
+        const status = await commit.getStatus();
+        if (status.code !== 0) {
+            throw new Error(`Transaction ${txId} failed with status: ${status.code}`);
+        }
+
+        const resultBytes = commit.getResult();
+        const data = this.parseResult(resultBytes);
+
+        // Record rejection against the transferred item
+        this.addToHistory(txId, 'REJECT_TRANSFER', data && data.itemId ? data.itemId : transferId, 'success', null, null);
+
+        return { ...data, txId };
+    }
+
+    async getTransfer(transferId) {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_TRANSFER, transferId);
+        return this.parseResult(result);
+    }
+
+    async getPendingTransfers(mspId) {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_PENDING_TRANSFERS, mspId);
+        return this.parseResult(result) || [];
+    }
+
+    async getCustodyHistory(itemId) {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_CUSTODY_HISTORY, itemId);
+        return this.parseResult(result) || [];
+    }
+
     // Generic Operations
     async getItem(itemId) {
         // Use fast CouchDB direct query
@@ -494,48 +822,74 @@ class FabricService {
         return this.parseResult(result) || [];
     }
 
-    // NO MAPPING: Query for 'carton' directly (chaincode updated)
+    // Paginated queries - pass the returned bookmark back to get the next page
+    async queryPage(txType, args, pageSize = 100, bookmark = '') {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(txType, ...args, String(pageSize), bookmark || '');
+        return this.parseResult(result) || { records: [], fetchedRecordsCount: 0, bookmark: '' };
+    }
+
+    async getAllItemsPage(docType, pageSize, bookmark) {
+        return this.queryPage(TX_TYPES.GET_ALL_ITEMS_WITH_PAGINATION, [docType], pageSize, bookmark);
+    }
+
+    async getAllOrdersPage(pageSize, bookmark) {
+        return this.queryPage(TX_TYPES.GET_ALL_ORDERS_WITH_PAGINATION, [], pageSize, bookmark);
+    }
+
+    async getOrdersByRecipientPage(recipient, pageSize, bookmark) {
+        return this.queryPage(TX_TYPES.GET_ORDERS_BY_RECIPIENT_WITH_PAGINATION, [recipient], pageSize, bookmark);
+    }
+
+    async getAvailableStripsPage(pageSize, bookmark) {
+        return this.queryPage(TX_TYPES.GET_AVAILABLE_STRIPS_WITH_PAGINATION, [], pageSize, bookmark);
+    }
+
+    async getAvailableBoxesPage(pageSize, bookmark) {
+        return this.queryPage(TX_TYPES.GET_AVAILABLE_BOXES_WITH_PAGINATION, [], pageSize, bookmark);
+    }
+
+    async getAvailableCartonsPage(pageSize, bookmark) {
+        return this.queryPage(TX_TYPES.GET_AVAILABLE_CARTONS_WITH_PAGINATION, [], pageSize, bookmark);
+    }
+
+    async getAvailableShipmentsPage(pageSize, bookmark) {
+        return this.queryPage(TX_TYPES.GET_AVAILABLE_SHIPMENTS_WITH_PAGINATION, [], pageSize, bookmark);
+    }
+
+    // Structured query: { docType, status, equals, ranges, sort, limit, bookmark }
+    async queryItems(filter) {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.QUERY_ITEMS, JSON.stringify(filter));
+        return this.parseResult(result) || { records: [], fetchedRecordsCount: 0, bookmark: '' };
+    }
+
     async getStatistics() {
-        // Use fast CouchDB direct queries instead of slow chaincode
-        try {
-            const docTypes = ['strip', 'box', 'carton', 'shipment', 'order'];
-            const counts = await Promise.all(docTypes.map(async (type) => {
-                const result = await queryCouchDB({
-                    selector: { docType: type },
-                    fields: ['_id'],
-                    limit: 100000
-                });
-                return { type, count: result.docs ? result.docs.length : 0 };
-            }));
-
-            const stats = {
-                strips: 0,
-                boxes: 0,
-                cartons: 0,
-                shipments: 0,
-                orders: 0
-            };
-            counts.forEach(({ type, count }) => {
-                if (type === 'strip') stats.strips = count;
-                else if (type === 'box') stats.boxes = count;
-                else if (type === 'carton') stats.cartons = count;
-                else if (type === 'shipment') stats.shipments = count;
-                else if (type === 'order') stats.orders = count;
-            });
-            return stats;
-        } catch (error) {
-            console.error('Fast statistics query failed, falling back to chaincode:', error.message);
-            // Fallback to chaincode (slow)
-            await this.ensureConnected();
-            const result = await this.contract.evaluateTransaction('GetStatistics');
-            return this.parseResult(result) || {
-                strips: 0,
-                boxes: 0,
-                cartons: 0,
-                shipments: 0,
-                orders: 0
-            };
-        }
+        // Counters are maintained by the chaincode, so this no longer scans CouchDB.
+        // Ledgers with items from before the counters existed need RebuildStatistics run once.
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_STATISTICS);
+        return this.parseResult(result) || {
+            strips: 0,
+            boxes: 0,
+            cartons: 0,
+            pallets: 0,
+            shipments: 0,
+            orders: 0
+        };
+    }
+
+    // Counts of every doc type broken down by status, product (GTIN) and owning org
+    async getStatisticsBreakdown() {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_STATISTICS_BREAKDOWN);
+        return this.parseResult(result) || {};
+    }
+
+    async getItemStatistics(docType) {
+        await this.ensureConnected();
+        const result = await this.contract.evaluateTransaction(TX_TYPES.GET_ITEM_STATISTICS, docType);
+        return this.parseResult(result);
     }
 
     async searchItems(searchTerm) {
@@ -974,13 +1328,14 @@ class FabricService {
             }
 
             // Use chaincode's GetTraceByTxHash which now:
-            // 1. First searches by creationTxId (unique to each item, never changes)
+            // 1. First searches by creationTxId (shared by every strip of a bulk creation)
             // 2. Falls back to history search for backward compatibility
             try {
                 const result = await this.getTraceByTxHashFromBlockchain(searchTerm);
                 return {
                     searchType: 'txHash',
                     transaction: result.transactionInfo,
+                    createdItemIds: result.createdItemIds || [],
                     searchedItem: result.traceability.searchedItem,
                     children: result.traceability.children || [],
                     parents: result.traceability.parents || []
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Role attribute carried in enrollment certificates (register with --id.attrs 'role=manufacturer:ecert')
+const (
+	RoleAttribute    = "role"
+	RoleManufacturer = "manufacturer"
+	RoleDistributor  = "distributor"
+	RolePharmacy     = "pharmacy"
+	RoleRegulator    = "regulator"
+)
+
+// OUAdmin is the node OU Fabric CA gives the organisation admins it enrolls (NodeOUs are enabled in every
+// org's config.yaml)
+const OUAdmin = "admin"
+
+// The consortium's access policy is stored under a composite key, a namespace no item ID can reach
+const (
+	configObjectType = "config"
+	accessPolicyName = "accessPolicy"
+)
+
+// AccessRule describes who may invoke a transaction.
+// If MSPs is set the caller's MSP ID must be listed. If Roles or OUs is set the caller's role attribute or one
+// of its certificate's organizational units must be listed.
+type AccessRule struct {
+	MSPs  []string `json:"msps,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	OUs   []string `json:"ous,omitempty"`
+}
+
+// roleRule admits the given roles and, as they act for their organisation, org admins
+func roleRule(roles ...string) AccessRule {
+	return AccessRule{Roles: roles, OUs: []string{OUAdmin}}
+}
+
+// AccessPolicy maps transaction names to the rule guarding them.
+// Transactions without a rule are open to any enrolled identity.
+type AccessPolicy struct {
+	Rules     map[string]AccessRule `json:"rules"`
+	UpdatedBy string                `json:"updatedBy"`
+	UpdatedAt time.Time             `json:"updatedAt"`
+}
+
+// defaultAccessPolicy is used until the consortium stores its own policy with SetAccessPolicy. Org admins
+// may invoke everything, so an admin of the consortium can always set the policy.
+func defaultAccessPolicy() *AccessPolicy {
+	return &AccessPolicy{
+		Rules: map[string]AccessRule{
+			"RegisterProduct":          roleRule(RoleManufacturer),
+			"UpdateProduct":            roleRule(RoleManufacturer),
+			"CreateBatch":              roleRule(RoleManufacturer),
+			"RegisterCompanyPrefix":    roleRule(RoleManufacturer, RoleDistributor),
+			"ReleaseBatch":             roleRule(RoleManufacturer),
+			"RejectBatch":              roleRule(RoleManufacturer),
+			"CreateStrip":              roleRule(RoleManufacturer),
+			"CreateStripsBulk":         roleRule(RoleManufacturer),
+			"SetPackingRules":          roleRule(RoleManufacturer),
+			"SealBox":                  roleRule(RoleManufacturer),
+			"SealCarton":               roleRule(RoleManufacturer),
+			"SealShipment":             roleRule(RoleManufacturer),
+			"SealPallet":               roleRule(RoleManufacturer, RoleDistributor),
+			"UnpackBox":                roleRule(RoleManufacturer, RoleDistributor),
+			"UnpackCarton":             roleRule(RoleManufacturer, RoleDistributor),
+			"UnpackPallet":             roleRule(RoleManufacturer, RoleDistributor),
+			"UnpackShipment":           roleRule(RoleManufacturer, RoleDistributor),
+			"MoveChild":                roleRule(RoleManufacturer, RoleDistributor),
+			"DistributeShipment":       roleRule(RoleDistributor),
+			"CreateOrder":              roleRule(RoleManufacturer, RoleDistributor),
+			"CancelOrder":              roleRule(RoleManufacturer, RoleDistributor),
+			"AddShipmentsToOrder":      roleRule(RoleManufacturer, RoleDistributor),
+			"RemoveShipmentsFromOrder": roleRule(RoleManufacturer, RoleDistributor),
+			"DispatchOrder":            roleRule(RoleManufacturer, RoleDistributor),
+			"DeliverOrder":             roleRule(RoleDistributor, RolePharmacy),
+			"ReceiveOrder":             roleRule(RoleDistributor, RolePharmacy),
+			"ProposeTransfer":          roleRule(RoleManufacturer, RoleDistributor, RolePharmacy),
+			"AcceptTransfer":           roleRule(RoleManufacturer, RoleDistributor, RolePharmacy),
+			"RejectTransfer":           roleRule(RoleManufacturer, RoleDistributor, RolePharmacy),
+			"RecallBatch":              roleRule(RoleManufacturer, RoleRegulator),
+			"SetAccessPolicy":          roleRule(RoleRegulator),
+			"RebuildStatistics":        roleRule(RoleRegulator),
+			"PruneStatistics":          roleRule(RoleRegulator),
+			"RebuildIndexes":           roleRule(RoleRegulator),
+		},
+	}
+}
+
+// accessPolicyKey returns the world state key of the stored access policy
+func accessPolicyKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(configObjectType, []string{accessPolicyName})
+	if err != nil {
+		return "", fmt.Errorf("failed to create access policy key: %v", err)
+	}
+	return key, nil
+}
+
+// getAccessPolicy reads the stored access policy, falling back to the default one. A stored policy without
+// rules is refused rather than read as leaving every transaction open.
+func (c *PharmaContract) getAccessPolicy(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+	key, err := accessPolicyKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	policyJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access policy: %v", err)
+	}
+	if policyJSON == nil {
+		return defaultAccessPolicy(), nil
+	}
+
+	var policy AccessPolicy
+	err = json.Unmarshal(policyJSON, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse access policy: %v", err)
+	}
+	if len(policy.Rules) == 0 {
+		return nil, fmt.Errorf("stored access policy has no rules")
+	}
+	return &policy, nil
+}
+
+// getSubmitter returns the MSP ID and enrollment ID of the client submitting the transaction. Fabric CA
+// issues certificates with the enrollment ID as common name.
+func (c *PharmaContract) getSubmitter(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	if cert == nil || cert.Subject.CommonName == "" {
+		return "", "", fmt.Errorf("client certificate of %s has no common name", mspID)
+	}
+	return mspID, cert.Subject.CommonName, nil
+}
+
+// checkAccess verifies the submitting client is allowed to invoke the named transaction
+func (c *PharmaContract) checkAccess(ctx contractapi.TransactionContextInterface, function string) error {
+	policy, err := c.getAccessPolicy(ctx)
+	if err != nil {
+		return err
+	}
+
+	rule, ok := policy.Rules[function]
+	if !ok {
+		return nil
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if len(rule.MSPs) > 0 && !containsString(rule.MSPs, mspID) {
+		return fmt.Errorf("access denied: %s cannot be invoked by members of %s (allowed: %v)", function, mspID, rule.MSPs)
+	}
+
+	if len(rule.OUs) > 0 {
+		cert, err := ctx.GetClientIdentity().GetX509Certificate()
+		if err != nil {
+			return fmt.Errorf("failed to get client certificate: %v", err)
+		}
+		if cert != nil {
+			for _, ou := range cert.Subject.OrganizationalUnit {
+				if containsString(rule.OUs, ou) {
+					return nil
+				}
+			}
+		}
+		if len(rule.Roles) == 0 {
+			return fmt.Errorf("access denied: %s can only be invoked by %v identities", function, rule.OUs)
+		}
+	}
+
+	if len(rule.Roles) > 0 {
+		role, found, err := ctx.GetClientIdentity().GetAttributeValue(RoleAttribute)
+		if err != nil {
+			return fmt.Errorf("failed to read %s attribute: %v", RoleAttribute, err)
+		}
+		if !found {
+			return fmt.Errorf("access denied: %s requires a %s attribute of %v, caller from %s has none", function, RoleAttribute, rule.Roles, mspID)
+		}
+		if !containsString(rule.Roles, role) {
+			return fmt.Errorf("access denied: %s cannot be invoked by role %s (allowed: %v)", function, role, rule.Roles)
+		}
+	}
+
+	return nil
+}
+
+// SetAccessPolicy replaces the role/OU/MSP rules for every guarded transaction
+func (c *PharmaContract) SetAccessPolicy(ctx contractapi.TransactionContextInterface, policyJSON string) (*AccessPolicy, error) {
+	if err := c.checkAccess(ctx, "SetAccessPolicy"); err != nil {
+		return nil, err
+	}
+
+	var rules map[string]AccessRule
+	err := json.Unmarshal([]byte(policyJSON), &rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse access policy: %v", err)
+	}
+
+	// Refuse policies that would let anyone rewrite the policy itself
+	adminRule, ok := rules["SetAccessPolicy"]
+	if !ok || (len(adminRule.MSPs) == 0 && len(adminRule.Roles) == 0 && len(adminRule.OUs) == 0) {
+		return nil, fmt.Errorf("access policy must restrict SetAccessPolicy")
+	}
+
+	updatedBy, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	policy := AccessPolicy{
+		Rules:     rules,
+		UpdatedBy: updatedBy,
+		UpdatedAt: c.getTxTimestamp(ctx),
+	}
+
+	storedJSON, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := accessPolicyKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(key, storedJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventAccessPolicySet, ItemIDs: []string{accessPolicyName}, Details: policy})
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// GetAccessPolicy returns the access policy currently in force
+func (c *PharmaContract) GetAccessPolicy(ctx contractapi.TransactionContextInterface) (*AccessPolicy, error) {
+	return c.getAccessPolicy(ctx)
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
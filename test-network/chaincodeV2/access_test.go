@@ -0,0 +1,206 @@
+package main
+
+import "testing"
+
+// allowed runs checkAccess for function as one transaction submitted by id
+func allowed(t *testing.T, l *mockLedger, id *mockIdentity, function string) error {
+	t.Helper()
+	return submit(t, l, id, func(ctx *mockCtx) error {
+		return contract.checkAccess(ctx, function)
+	})
+}
+
+func TestCheckAccessDefaultPolicy(t *testing.T) {
+	l := newLedger()
+
+	tests := []struct {
+		id       *mockIdentity
+		function string
+		err      string
+	}{
+		{org1Mfr, "CreateStrip", ""},
+		{org2Pharm, "CreateStrip", "cannot be invoked by role pharmacy"},
+		{org1User, "CreateStrip", "requires a role attribute"},
+		{org1Admin, "CreateStrip", ""}, // Org admins act for their organisation
+		{org1Admin, "SetAccessPolicy", ""},
+		{org1Dist, "DispatchOrder", ""},
+		{org2Pharm, "DispatchOrder", "cannot be invoked by role pharmacy"},
+		{org2Pharm, "ReceiveOrder", ""},
+		{regulator, "RecallBatch", ""},
+		{org1Dist, "RecallBatch", "cannot be invoked by role distributor"},
+		{org1Mfr, "SetAccessPolicy", "cannot be invoked by role manufacturer"},
+		{org1User, "GetItem", ""}, // Transactions without a rule are open
+	}
+	for _, tt := range tests {
+		err := allowed(t, l, tt.id, tt.function)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s by %s: unexpected error: %v", tt.function, tt.id.cn, err)
+		}
+		if tt.err != "" {
+			expectError(t, err, tt.err)
+		}
+	}
+}
+
+func TestSetAccessPolicy(t *testing.T) {
+	l := newLedger()
+
+	set := func(id *mockIdentity, policyJSON string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.SetAccessPolicy(ctx, policyJSON)
+			return err
+		})
+	}
+
+	policy := `{"CreateStrip":{"msps":["Org1MSP"]},"RecallBatch":{"ous":["admin"]},"SetAccessPolicy":{"roles":["regulator"]}}`
+	expectError(t, set(org1Mfr, policy), "access denied")
+	expectError(t, set(regulator, `{"CreateStrip":{"msps":["Org1MSP"]}}`), "must restrict SetAccessPolicy")
+	expectError(t, set(regulator, `{"SetAccessPolicy":{}}`), "must restrict SetAccessPolicy")
+	expectError(t, set(regulator, `not json`), "failed to parse access policy")
+	expectError(t, set(org1User, policy), "requires a role attribute")
+	if err := set(regulator, policy); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, set(org1Admin, policy), "requires a role attribute")
+
+	// The stored policy replaces the default one: MSP rules apply, and unruled transactions are open
+	tests := []struct {
+		id       *mockIdentity
+		function string
+		err      string
+	}{
+		{org1User, "CreateStrip", ""},
+		{org1Mfr, "CreateStrip", ""},
+		{org2Pharm, "CreateStrip", "cannot be invoked by members of Org2MSP"},
+		{org2Pharm, "DispatchOrder", ""},
+		{org1Admin, "RecallBatch", ""},
+		{regulator, "RecallBatch", "can only be invoked by [admin] identities"},
+		{org1Mfr, "SetAccessPolicy", "cannot be invoked by role manufacturer"},
+		{org1Admin, "SetAccessPolicy", "requires a role attribute"},
+		{regulator, "SetAccessPolicy", ""},
+	}
+	for _, tt := range tests {
+		err := allowed(t, l, tt.id, tt.function)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s by %s: unexpected error: %v", tt.function, tt.id.cn, err)
+		}
+		if tt.err != "" {
+			expectError(t, err, tt.err)
+		}
+	}
+
+	name, event := l.lastEvent(t)
+	if name != EventAccessPolicySet || event.ActorMSP != "Org2MSP" {
+		t.Errorf("event %s %+v", name, event)
+	}
+}
+
+func TestStoredPolicyWithoutRules(t *testing.T) {
+	l := newLedger()
+	putDoc(t, l, "\x00config\x00accessPolicy\x00", map[string]interface{}{"rules": map[string]interface{}{}})
+
+	// An emptied policy locks everything rather than opening everything
+	expectError(t, allowed(t, l, org1Mfr, "CreateStrip"), "stored access policy has no rules")
+	expectError(t, allowed(t, l, org1Admin, "GetItem"), "stored access policy has no rules")
+}
+
+func TestReservedItemIDs(t *testing.T) {
+	l := newLedger()
+	setupBatch(t, l, "B1")
+	buildCarton(t, l, "C1", "B1")
+
+	tests := []struct {
+		name string
+		id   *mockIdentity
+		fn   func(ctx *mockCtx) error
+		err  string
+	}{
+		{"strip", org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.CreateStrip(ctx, "RECALL_B1", "B1", "", "2025-01-01", "2027-01-01")
+			return err
+		}, "strip ID RECALL_B1 uses the reserved prefix RECALL_"},
+		{"empty strip", org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.CreateStrip(ctx, "", "B1", "", "2025-01-01", "2027-01-01")
+			return err
+		}, "strip ID is required"},
+		{"composite strip", org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.CreateStrip(ctx, "\x00config\x00", "B1", "", "2025-01-01", "2027-01-01")
+			return err
+		}, "must not contain a null character"},
+		{"bulk strip", org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.CreateStripsBulk(ctx, `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","strips":[{"id":"S1"},{"id":"PRODUCT_`+testGTIN+`"}]}`)
+			return err
+		}, "reserved prefix PRODUCT_"},
+		{"box", org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.SealBox(ctx, "BATCH_B2", idList("C1-S"))
+			return err
+		}, "box ID BATCH_B2 uses the reserved prefix BATCH_"},
+		{"order", org1Dist, func(ctx *mockCtx) error {
+			_, err := contract.CreateOrder(ctx, "RECEIPT_O1", idList("C1"), "pharm1", "Org2MSP")
+			return err
+		}, "order ID RECEIPT_O1 uses the reserved prefix RECEIPT_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectError(t, submit(t, l, tt.id, tt.fn), tt.err)
+		})
+	}
+
+	// With no strip squatting its record key, the batch can still be recalled
+	mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+		_, err := contract.RecallBatch(ctx, "B1", "contamination")
+		return err
+	})
+}
+
+func TestSubmitterDerivedFields(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildShipment(t, l, "P2", "B1")
+	buildShipment(t, l, "P3", "B1")
+	noCN := &mockIdentity{msp: "Org1MSP", ou: "client", attrs: map[string]string{"role": RoleDistributor}}
+
+	// Who distributes and who sends is taken from the certificate, never from arguments
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DistributeShipment(ctx, "P1-SH")
+		return err
+	})
+	if shipment := getDoc(t, l, "P1-SH"); shipment["distributor"] != "dist1" || shipment["distributorOrg"] != "Org1MSP" {
+		t.Errorf("P1-SH distributed by %v of %v", shipment["distributor"], shipment["distributorOrg"])
+	}
+	name, event := l.lastEvent(t)
+	details, _ := event.Details.(map[string]interface{})
+	if name != EventShipmentDistributed || details["distributor"] != "dist1" || details["distributorOrg"] != "Org1MSP" {
+		t.Errorf("event %s %+v", name, event)
+	}
+
+	senders := []struct {
+		orderID string
+		id      *mockIdentity
+		itemID  string
+		sender  string
+	}{
+		{"O1", org1Dist, "P2-SH", "dist1"},
+		{"O2", org1Mfr, "P3-SH", "mfr1"},
+	}
+	for _, tt := range senders {
+		mustSubmit(t, l, tt.id, func(ctx *mockCtx) error {
+			_, err := contract.CreateOrder(ctx, tt.orderID, idList(tt.itemID), "pharm1", "Org2MSP")
+			return err
+		})
+		order := getDoc(t, l, tt.orderID)
+		if order["senderId"] != tt.sender || order["senderOrg"] != "Org1MSP" || order["currentOwner"] != "Org1MSP" {
+			t.Errorf("%s sent by %v of %v, owned by %v", tt.orderID, order["senderId"], order["senderOrg"], order["currentOwner"])
+		}
+	}
+
+	// A certificate without a common name cannot stand in for a sender
+	expectError(t, submit(t, l, noCN, func(ctx *mockCtx) error {
+		_, err := contract.CreateOrder(ctx, "O3", idList("P1-SH"), "pharm1", "Org2MSP")
+		return err
+	}), "client certificate of Org1MSP has no common name")
+	expectError(t, submit(t, l, noCN, func(ctx *mockCtx) error {
+		_, err := contract.DistributeShipment(ctx, "P1-SH")
+		return err
+	}), "client certificate of Org1MSP has no common name")
+}
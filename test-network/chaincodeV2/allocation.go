@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AllocationConflict is a shipment or pallet that more than one order has claimed at the same time,
+// either because it was moved to another order without being released first or because several orders
+// list it
+type AllocationConflict struct {
+	ItemID         string   `json:"itemId"`
+	ItemType       string   `json:"itemType"`
+	CurrentOrderID string   `json:"currentOrderId"`         // The order the item points at now, empty if none
+	ClaimedBy      []string `json:"claimedBy"`              // Every order that has held the item or lists it now
+	ListedBy       []string `json:"listedBy"`               // Orders listing the item now, leaving out cancelled ones
+	RepointTxIDs   []string `json:"repointTxIds,omitempty"` // Transactions that moved the item to another order without releasing it
+}
+
+// orderListings maps each shipment or pallet to the orders listing it, leaving out cancelled orders,
+// which keep their item list as a record
+func (c *PharmaContract) orderListings(ctx contractapi.TransactionContextInterface) (map[string][]string, error) {
+	orderDocs, err := c.getIndexedDocs(ctx, IndexDocTypeStatus, DocTypeOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	listings := map[string][]string{}
+	for _, orderDoc := range orderDocs {
+		var order Order
+		err = json.Unmarshal(orderDoc, &order)
+		if err != nil {
+			return nil, err
+		}
+		if order.Status == StatusCancelled {
+			continue
+		}
+		for _, itemID := range order.ItemIDs {
+			listings[itemID] = appendUnique(listings[itemID], order.ID)
+		}
+	}
+	return listings, nil
+}
+
+// checkAllocationHistory walks the history of an item's key, oldest first, and records every order it
+// pointed at and every transaction that switched it from one order to another
+func (c *PharmaContract) checkAllocationHistory(ctx contractapi.TransactionContextInterface, conflict *AllocationConflict) error {
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(conflict.ItemID)
+	if err != nil {
+		return fmt.Errorf("failed to get history: %v", err)
+	}
+	defer historyIterator.Close()
+
+	type version struct {
+		orderID   string
+		txID      string
+		timestamp time.Time
+	}
+	var versions []version
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return err
+		}
+		if modification.IsDelete || modification.Value == nil {
+			continue
+		}
+		var doc map[string]interface{}
+		err = json.Unmarshal(modification.Value, &doc)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, version{
+			orderID:   docString(doc, "orderId"),
+			txID:      modification.TxId,
+			timestamp: modification.Timestamp.AsTime(),
+		})
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].timestamp.Before(versions[j].timestamp)
+	})
+
+	previous := ""
+	for _, v := range versions {
+		if v.orderID != "" {
+			conflict.ClaimedBy = appendUnique(conflict.ClaimedBy, v.orderID)
+			if previous != "" && previous != v.orderID {
+				conflict.RepointTxIDs = append(conflict.RepointTxIDs, v.txID)
+			}
+		}
+		previous = v.orderID
+	}
+	return nil
+}
+
+// GetAllocationConflicts is a consistency report of the shipments and pallets claimed by more than one
+// order in the ledger history. CreateOrder refuses items already allocated, but ledgers written by earlier
+// chaincode versions may hold items silently moved between orders. Releasing an item through CancelOrder
+// or RemoveShipmentsFromOrder before ordering it again is not a conflict.
+func (c *PharmaContract) GetAllocationConflicts(ctx contractapi.TransactionContextInterface) ([]*AllocationConflict, error) {
+	listings, err := c.orderListings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []*AllocationConflict{}
+	for _, docType := range []string{DocTypeShipment, DocTypePallet} {
+		itemDocs, err := c.getIndexedDocs(ctx, IndexDocTypeStatus, docType)
+		if err != nil {
+			return nil, err
+		}
+		for _, itemDoc := range itemDocs {
+			var item map[string]interface{}
+			err = json.Unmarshal(itemDoc, &item)
+			if err != nil {
+				return nil, err
+			}
+
+			conflict := &AllocationConflict{
+				ItemID:         docString(item, "id"),
+				ItemType:       docType,
+				CurrentOrderID: docString(item, "orderId"),
+				ClaimedBy:      []string{},
+				ListedBy:       listings[docString(item, "id")],
+			}
+			if conflict.ListedBy == nil {
+				conflict.ListedBy = []string{}
+			}
+			err = c.checkAllocationHistory(ctx, conflict)
+			if err != nil {
+				return nil, err
+			}
+			for _, orderID := range conflict.ListedBy {
+				conflict.ClaimedBy = appendUnique(conflict.ClaimedBy, orderID)
+			}
+
+			listedElsewhere := len(conflict.ListedBy) == 1 && conflict.ListedBy[0] != conflict.CurrentOrderID
+			if len(conflict.RepointTxIDs) > 0 || len(conflict.ListedBy) > 1 || listedElsewhere {
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].ItemID < conflicts[j].ItemID
+	})
+	return conflicts, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExclusiveAllocation(t *testing.T) {
+	l := newLedger()
+	for _, prefix := range []string{"P1", "P2"} {
+		buildShipment(t, l, prefix, "B1")
+	}
+	createOrder(t, l, "O1", "P1-SH")
+
+	order := func(orderID string, itemID string) error {
+		return submit(t, l, org1Dist, func(ctx *mockCtx) error {
+			_, err := contract.CreateOrder(ctx, orderID, idList(itemID), "pharm1", "Org2MSP")
+			return err
+		})
+	}
+	distribute := func(shipmentID string) error {
+		return submit(t, l, org1Dist, func(ctx *mockCtx) error {
+			_, err := contract.DistributeShipment(ctx, shipmentID)
+			return err
+		})
+	}
+	conflicts := func() []*AllocationConflict {
+		t.Helper()
+		var conflicts []*AllocationConflict
+		mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+			var err error
+			conflicts, err = contract.GetAllocationConflicts(ctx)
+			return err
+		})
+		return conflicts
+	}
+
+	// A shipment is either in one order or distributed on its own, never both
+	expectError(t, order("O2", "P1-SH"), "shipment P1-SH is already allocated to order O1")
+	if err := distribute("P2-SH"); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, order("O2", "P2-SH"), "shipment P2-SH was already distributed by dist1")
+	expectError(t, distribute("P1-SH"), "shipment P1-SH is allocated to order O1 and is shipped by dispatching the order")
+	if shipment := getDoc(t, l, "P1-SH"); shipment["status"] != StatusInOrder || shipment["distributor"] != "" {
+		t.Fatalf("P1-SH is %v, distributed by %v", shipment["status"], shipment["distributor"])
+	}
+
+	// Cancelling the order releases the shipment, which can then be ordered again without a conflict
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.CancelOrder(ctx, "O1", "customer request")
+		return err
+	})
+	if shipment := getDoc(t, l, "P1-SH"); shipment["status"] != StatusCreated || shipment["orderId"] != "" {
+		t.Fatalf("P1-SH is %v in order %v after cancelling O1", shipment["status"], shipment["orderId"])
+	}
+	if err := order("O3", "P1-SH"); err != nil {
+		t.Fatal(err)
+	}
+	if found := conflicts(); len(found) != 0 {
+		t.Fatalf("conflicts %+v", found)
+	}
+
+	// An earlier chaincode version moved P1-SH to another order while O3 still lists it
+	shipment := getDoc(t, l, "P1-SH")
+	shipment["orderId"] = "LEGACY"
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		shipmentJSON, err := json.Marshal(shipment)
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutState("P1-SH", shipmentJSON)
+	})
+	found := conflicts()
+	if len(found) != 1 {
+		t.Fatalf("conflicts %+v", found)
+	}
+	conflict := found[0]
+	if conflict.ItemID != "P1-SH" || conflict.CurrentOrderID != "LEGACY" || len(conflict.RepointTxIDs) != 1 ||
+		!reflect.DeepEqual(conflict.ListedBy, []string{"O3"}) || len(conflict.ClaimedBy) != 3 {
+		t.Errorf("conflict %+v", conflict)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// getAmendableOrder reads an order its sender wants to change. Orders can only be changed by the sending
+// organization, before they are dispatched and while they are not being transferred.
+func (c *PharmaContract) getAmendableOrder(ctx contractapi.TransactionContextInterface, orderID string) (*Order, string, error) {
+	var order Order
+	err := c.readAsset(ctx, orderID, &order)
+	if err != nil {
+		return nil, "", err
+	}
+	if order.DocType != DocTypeOrder {
+		return nil, "", fmt.Errorf("%s is not an order", orderID)
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != order.SenderOrg {
+		return nil, "", fmt.Errorf("order %s can only be changed by its sender %s, not %s", orderID, order.SenderOrg, mspID)
+	}
+	if order.Status != StatusCreated {
+		return nil, "", fmt.Errorf("order %s is %s, only orders awaiting dispatch can be changed", orderID, order.Status)
+	}
+	if order.PendingTransfer != "" {
+		return nil, "", fmt.Errorf("order %s is awaiting transfer %s", orderID, order.PendingTransfer)
+	}
+	return &order, mspID, nil
+}
+
+// releaseFromOrder takes a shipment or pallet out of an order, making it available again. A shipment
+// distributed while in the order, which earlier chaincode versions allowed, leaves the order but stays
+// SHIPPED.
+func (c *PharmaContract) releaseFromOrder(ctx contractapi.TransactionContextInterface, orderID string, itemID string, now time.Time) error {
+	item, err := c.readDoc(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	docType := docString(item, "docType")
+	if docString(item, "orderId") != orderID {
+		return fmt.Errorf("%s %s is not in order %s", docType, itemID, orderID)
+	}
+
+	item["orderId"] = ""
+	item["updatedAt"] = now
+	if docString(item, "status") != StatusShipped {
+		err = checkTransition(docType, itemID, docString(item, "status"), StatusCreated)
+		if err != nil {
+			return err
+		}
+		item["status"] = StatusCreated
+	}
+
+	return c.writeAsset(ctx, itemID, item)
+}
+
+// refreshOrderContents recomputes the item type and recalled batches of an order from the items it holds
+func (c *PharmaContract) refreshOrderContents(ctx contractapi.TransactionContextInterface, order *Order) error {
+	itemType := ""
+	var recalled []string
+	for _, itemID := range order.ItemIDs {
+		item, err := c.readDoc(ctx, itemID)
+		if err != nil {
+			return err
+		}
+		itemType = mergeItemType(itemType, docString(item, "docType"))
+		for _, batchNumber := range docStrings(item, "recalledBatches") {
+			recalled = appendUnique(recalled, batchNumber)
+		}
+	}
+	order.ItemType = itemType
+	order.RecalledBatches = recalled
+	return nil
+}
+
+// parseOrderItemIDs reads a non-empty JSON list of shipment or pallet IDs without duplicates
+func parseOrderItemIDs(itemIDsJSON string) ([]string, error) {
+	var itemIDs []string
+	err := json.Unmarshal([]byte(itemIDsJSON), &itemIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse shipment IDs: %v", err)
+	}
+	if len(itemIDs) == 0 {
+		return nil, fmt.Errorf("at least one shipment or pallet must be selected")
+	}
+	seen := map[string]bool{}
+	for _, itemID := range itemIDs {
+		if seen[itemID] {
+			return nil, fmt.Errorf("%s is listed more than once", itemID)
+		}
+		seen[itemID] = true
+	}
+	return itemIDs, nil
+}
+
+// CancelOrder cancels an order before it is dispatched, releasing its shipments and pallets so they can
+// be ordered again. Only the sending organization can cancel.
+func (c *PharmaContract) CancelOrder(ctx contractapi.TransactionContextInterface, orderID string, reason string) (*Order, error) {
+	if err := c.checkAccess(ctx, "CancelOrder"); err != nil {
+		return nil, err
+	}
+
+	order, _, err := c.getAmendableOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTransition(DocTypeOrder, orderID, order.Status, StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.getTxTimestamp(ctx)
+	for _, itemID := range order.ItemIDs {
+		err = c.releaseFromOrder(ctx, orderID, itemID, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The order keeps its item list as a record of what was cancelled
+	oldStatus := order.Status
+	order.Status = StatusCancelled
+	order.CancelReason = reason
+	order.UpdatedAt = now
+	err = c.writeAsset(ctx, orderID, order)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventOrderCancelled,
+		DocType:   DocTypeOrder,
+		ItemIDs:   []string{orderID},
+		OldStatus: oldStatus,
+		NewStatus: StatusCancelled,
+		ChildIDs:  order.ItemIDs,
+		Details:   map[string]string{"reason": reason},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// AddShipmentsToOrder adds shipments or pallets to an order before it is dispatched. The items are checked
+// as in CreateOrder. Only the sending organization can amend an order.
+func (c *PharmaContract) AddShipmentsToOrder(ctx contractapi.TransactionContextInterface, orderID string, itemIDsJSON string) (*Order, error) {
+	if err := c.checkAccess(ctx, "AddShipmentsToOrder"); err != nil {
+		return nil, err
+	}
+
+	order, mspID, err := c.getAmendableOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	itemIDs, err := parseOrderItemIDs(itemIDsJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.getTxTimestamp(ctx)
+	for _, itemID := range itemIDs {
+		if containsString(order.ItemIDs, itemID) {
+			return nil, fmt.Errorf("%s is already in order %s", itemID, orderID)
+		}
+		docType, err := c.allocateToOrder(ctx, orderID, itemID, mspID, now)
+		if err != nil {
+			return nil, err
+		}
+		order.ItemType = mergeItemType(order.ItemType, docType)
+	}
+
+	order.ItemIDs = append(order.ItemIDs, itemIDs...)
+	order.UpdatedAt = now
+	err = c.writeAsset(ctx, orderID, order)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventOrderAmended,
+		DocType:   DocTypeOrder,
+		ItemIDs:   []string{orderID},
+		OldStatus: order.Status,
+		NewStatus: order.Status,
+		ChildIDs:  itemIDs,
+		Details:   map[string][]string{"added": itemIDs},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// RemoveShipmentsFromOrder takes shipments or pallets out of an order before it is dispatched, making them
+// available again. An order must keep at least one item; cancel it instead. Only the sending organization
+// can amend an order.
+func (c *PharmaContract) RemoveShipmentsFromOrder(ctx contractapi.TransactionContextInterface, orderID string, itemIDsJSON string) (*Order, error) {
+	if err := c.checkAccess(ctx, "RemoveShipmentsFromOrder"); err != nil {
+		return nil, err
+	}
+
+	order, _, err := c.getAmendableOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	itemIDs, err := parseOrderItemIDs(itemIDsJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.getTxTimestamp(ctx)
+	for _, itemID := range itemIDs {
+		if !containsString(order.ItemIDs, itemID) {
+			return nil, fmt.Errorf("%s is not in order %s", itemID, orderID)
+		}
+		err = c.releaseFromOrder(ctx, orderID, itemID, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var remaining []string
+	for _, itemID := range order.ItemIDs {
+		if !containsString(itemIDs, itemID) {
+			remaining = append(remaining, itemID)
+		}
+	}
+	if len(remaining) == 0 {
+		return nil, fmt.Errorf("order %s would be left empty, cancel it instead", orderID)
+	}
+	order.ItemIDs = remaining
+	err = c.refreshOrderContents(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	order.UpdatedAt = now
+	err = c.writeAsset(ctx, orderID, order)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventOrderAmended,
+		DocType:   DocTypeOrder,
+		ItemIDs:   []string{orderID},
+		OldStatus: order.Status,
+		NewStatus: order.Status,
+		ChildIDs:  itemIDs,
+		Details:   map[string][]string{"removed": itemIDs},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
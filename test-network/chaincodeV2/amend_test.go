@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestCancelOrder(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildShipment(t, l, "P2", "B1")
+	createOrder(t, l, "O1", "P1-SH", "P2-SH")
+
+	// An earlier chaincode version distributed P1-SH while it was in the order
+	shipment := getDoc(t, l, "P1-SH")
+	shipment["status"] = StatusShipped
+	putDoc(t, l, "P1-SH", shipment)
+
+	cancel := func(id *mockIdentity) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.CancelOrder(ctx, "O1", "customer request")
+			return err
+		})
+	}
+	expectError(t, cancel(org2Dist), "can only be changed by its sender Org1MSP")
+	if err := cancel(org1Dist); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id     string
+		status string
+	}{
+		{"O1", StatusCancelled},
+		{"P1-SH", StatusShipped},
+		{"P2-SH", StatusCreated},
+	}
+	for _, tt := range tests {
+		doc := getDoc(t, l, tt.id)
+		if doc["status"] != tt.status || (tt.id != "O1" && doc["orderId"] != "") {
+			t.Errorf("%s is %v in order %v", tt.id, doc["status"], doc["orderId"])
+		}
+	}
+
+	// Released shipments can be ordered again; the cancelled order is left alone
+	createOrder(t, l, "O2", "P2-SH")
+	expectError(t, cancel(org1Dist), "only orders awaiting dispatch can be changed")
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// QC status constants for batches
+const (
+	QCStatusPending  = "PENDING"
+	QCStatusReleased = "RELEASED"
+	QCStatusRejected = "REJECTED"
+)
+
+// Batch is the authoritative record for a manufacturing lot; strips link to it by batch number
+type Batch struct {
+	DocType           string    `json:"docType"`
+	ID                string    `json:"id"`
+	BatchNumber       string    `json:"batchNumber"`
+	GTIN              string    `json:"gtin"`            // Product registered with RegisterProduct
+	Product           string    `json:"product"`         // Product name, copied from the product master data
+	ManufacturerOrg   string    `json:"manufacturerOrg"` // MSP ID of the organization that created the batch
+	ManufacturingSite string    `json:"manufacturingSite"`
+	QuantityPlanned   int       `json:"quantityPlanned"`
+	QuantityProduced  int       `json:"quantityProduced"` // Reported by the manufacturer when QC completes
+	QCStatus          string    `json:"qcStatus"`
+	CoAHash           string    `json:"coaHash"`      // Hash of the certificate of analysis document
+	CreationTxId      string    `json:"creationTxId"` // The transaction ID when this batch was created (never changes)
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// batchID returns the ledger key of the batch record for a batch number
+func batchID(batchNumber string) string {
+	return "BATCH_" + batchNumber
+}
+
+// getBatch reads the batch record for a batch number
+func (c *PharmaContract) getBatch(ctx contractapi.TransactionContextInterface, batchNumber string) (*Batch, error) {
+	var batch Batch
+	err := c.readAsset(ctx, batchID(batchNumber), &batch)
+	if err != nil {
+		return nil, fmt.Errorf("batch %s: %v", batchNumber, err)
+	}
+	return &batch, nil
+}
+
+// getReleasedBatchProduct returns the product of a batch, failing unless the batch has been released and
+// both the batch and its product belong to mspID. Only the manufacturer of a lot can produce strips in it.
+func (c *PharmaContract) getReleasedBatchProduct(ctx contractapi.TransactionContextInterface, batchNumber string, mspID string) (*Product, error) {
+	batch, err := c.getBatch(ctx, batchNumber)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != batch.ManufacturerOrg {
+		return nil, fmt.Errorf("batch %s belongs to %s, not %s", batchNumber, batch.ManufacturerOrg, mspID)
+	}
+	if batch.QCStatus != QCStatusReleased {
+		return nil, fmt.Errorf("batch %s is %s, only released batches can produce strips", batchNumber, batch.QCStatus)
+	}
+
+	product, err := c.getProduct(ctx, batch.GTIN)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != product.ManufacturerOrg {
+		return nil, fmt.Errorf("product %s belongs to %s, not %s", product.GTIN, product.ManufacturerOrg, mspID)
+	}
+	return product, nil
+}
+
+// CreateBatch registers a new manufacturing lot awaiting QC release. Only the organization that registered
+// the product can manufacture it.
+func (c *PharmaContract) CreateBatch(ctx contractapi.TransactionContextInterface, batchNumber string, gtin string, manufacturingSite string, quantityPlanned int) (*Batch, error) {
+	if err := c.checkAccess(ctx, "CreateBatch"); err != nil {
+		return nil, err
+	}
+
+	if batchNumber == "" {
+		return nil, fmt.Errorf("batch number is required")
+	}
+	if quantityPlanned <= 0 {
+		return nil, fmt.Errorf("planned quantity must be positive")
+	}
+
+	id := batchID(batchNumber)
+	exists, err := c.assetExists(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("batch %s already exists", batchNumber)
+	}
+
+	product, err := c.getProduct(ctx, gtin)
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != product.ManufacturerOrg {
+		return nil, fmt.Errorf("product %s belongs to %s, not %s", product.GTIN, product.ManufacturerOrg, mspID)
+	}
+
+	now := c.getTxTimestamp(ctx)
+	batch := Batch{
+		DocType:           DocTypeBatch,
+		ID:                id,
+		BatchNumber:       batchNumber,
+		GTIN:              product.GTIN,
+		Product:           product.Name,
+		ManufacturerOrg:   mspID,
+		ManufacturingSite: manufacturingSite,
+		QuantityPlanned:   quantityPlanned,
+		QCStatus:          QCStatusPending,
+		CreationTxId:      ctx.GetStub().GetTxID(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	err = c.writeAsset(ctx, id, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventBatchCreated, DocType: DocTypeBatch, ItemIDs: []string{id}, NewStatus: QCStatusPending, Details: batch})
+	if err != nil {
+		return nil, err
+	}
+
+	return &batch, nil
+}
+
+// setBatchQCStatus records the QC outcome of a batch; only its manufacturer may do so
+func (c *PharmaContract) setBatchQCStatus(ctx contractapi.TransactionContextInterface, batchNumber string, qcStatus string, quantityProduced int, coaHash string, eventName string) (*Batch, error) {
+	batch, err := c.getBatch(ctx, batchNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != batch.ManufacturerOrg {
+		return nil, fmt.Errorf("batch %s belongs to %s, not %s", batchNumber, batch.ManufacturerOrg, mspID)
+	}
+
+	err = checkTransition(DocTypeBatch, batchNumber, batch.QCStatus, qcStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	oldStatus := batch.QCStatus
+	batch.QCStatus = qcStatus
+	batch.QuantityProduced = quantityProduced
+	batch.CoAHash = coaHash
+	batch.UpdatedAt = c.getTxTimestamp(ctx)
+
+	err = c.writeAsset(ctx, batch.ID, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      eventName,
+		DocType:   DocTypeBatch,
+		ItemIDs:   []string{batch.ID},
+		OldStatus: oldStatus,
+		NewStatus: qcStatus,
+		Details:   batch,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+// ReleaseBatch marks a batch as having passed QC so strips can be created against it
+func (c *PharmaContract) ReleaseBatch(ctx contractapi.TransactionContextInterface, batchNumber string, quantityProduced int, coaHash string) (*Batch, error) {
+	if err := c.checkAccess(ctx, "ReleaseBatch"); err != nil {
+		return nil, err
+	}
+
+	if quantityProduced <= 0 {
+		return nil, fmt.Errorf("produced quantity must be positive")
+	}
+	if coaHash == "" {
+		return nil, fmt.Errorf("certificate of analysis hash is required to release a batch")
+	}
+
+	return c.setBatchQCStatus(ctx, batchNumber, QCStatusReleased, quantityProduced, coaHash, EventBatchReleased)
+}
+
+// RejectBatch marks a batch as having failed QC
+func (c *PharmaContract) RejectBatch(ctx contractapi.TransactionContextInterface, batchNumber string, quantityProduced int, coaHash string) (*Batch, error) {
+	if err := c.checkAccess(ctx, "RejectBatch"); err != nil {
+		return nil, err
+	}
+
+	if quantityProduced < 0 {
+		return nil, fmt.Errorf("produced quantity cannot be negative")
+	}
+	if coaHash == "" {
+		return nil, fmt.Errorf("certificate of analysis hash is required to reject a batch")
+	}
+
+	return c.setBatchQCStatus(ctx, batchNumber, QCStatusRejected, quantityProduced, coaHash, EventBatchRejected)
+}
+
+// GetBatch retrieves the batch record for a batch number
+func (c *PharmaContract) GetBatch(ctx contractapi.TransactionContextInterface, batchNumber string) (*Batch, error) {
+	return c.getBatch(ctx, batchNumber)
+}
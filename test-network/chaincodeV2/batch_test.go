@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestBatchQCGatesStrips(t *testing.T) {
+	l := newLedger()
+	setupBatch(t, l, "B0")
+	for _, batchNumber := range []string{"B1", "B2"} {
+		mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.CreateBatch(ctx, batchNumber, testGTIN, "Site A", 100)
+			return err
+		})
+	}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.RejectBatch(ctx, "B2", 0, "sha256:failed")
+		return err
+	})
+
+	createStrip := func(id *mockIdentity, stripID string, batchNumber string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.CreateStrip(ctx, stripID, batchNumber, "", "2025-01-01", "2027-01-01")
+			return err
+		})
+	}
+
+	// Strips need a released batch of the submitter's own
+	expectError(t, createStrip(org1Mfr, "S1", "B9"), "batch B9")
+	expectError(t, createStrip(org1Mfr, "S1", "B1"), "batch B1 is PENDING, only released batches can produce strips")
+	expectError(t, createStrip(org1Mfr, "S1", "B2"), "batch B2 is REJECTED")
+	org2Mfr := &mockIdentity{msp: "Org2MSP", cn: "mfr2", ou: "client", attrs: map[string]string{"role": RoleManufacturer}}
+	expectError(t, createStrip(org2Mfr, "S1", "B0"), "batch B0 belongs to Org1MSP, not Org2MSP")
+
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.ReleaseBatch(ctx, "B1", 90, "sha256:passed")
+		return err
+	})
+	if err := createStrip(org1Mfr, "S1", "B1"); err != nil {
+		t.Fatal(err)
+	}
+	strip := getDoc(t, l, "S1")
+	if strip["medicineType"] != "Paracetamol" || strip["currentOwner"] != "Org1MSP" {
+		t.Errorf("strip %v", strip)
+	}
+
+	// A QC outcome is final
+	expectError(t, submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.ReleaseBatch(ctx, "B2", 90, "sha256:passed")
+		return err
+	}), "B2")
+}
+
+func TestBatchQCValidation(t *testing.T) {
+	l := newLedger()
+	setupBatch(t, l, "B0")
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateBatch(ctx, "B1", testGTIN, "Site A", 100)
+		return err
+	})
+
+	tests := []struct {
+		name     string
+		release  bool
+		quantity int
+		coaHash  string
+		err      string
+	}{
+		{"release nothing", true, 0, "sha256:passed", "produced quantity must be positive"},
+		{"release without CoA", true, 90, "", "certificate of analysis hash is required to release a batch"},
+		{"reject negative", false, -1, "sha256:failed", "produced quantity cannot be negative"},
+		{"reject without CoA", false, 0, "", "certificate of analysis hash is required to reject a batch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+				var err error
+				if tt.release {
+					_, err = contract.ReleaseBatch(ctx, "B1", tt.quantity, tt.coaHash)
+				} else {
+					_, err = contract.RejectBatch(ctx, "B1", tt.quantity, tt.coaHash)
+				}
+				return err
+			})
+			expectError(t, err, tt.err)
+		})
+	}
+
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.RejectBatch(ctx, "B1", 0, "sha256:failed")
+		return err
+	})
+	if name, event := l.lastEvent(t); name != EventBatchRejected || event.OldStatus != QCStatusPending || event.NewStatus != QCStatusRejected {
+		t.Errorf("event %s %+v", name, event)
+	}
+}
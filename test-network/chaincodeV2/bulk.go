@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxBulkStrips caps how many strips CreateStripsBulk writes in one transaction, keeping the
+// read/write set within what peers will endorse and orderers will accept
+const maxBulkStrips = 1000
+
+// BulkStripSpec describes one strip in a CreateStripsBulk request; empty dates default to the request's
+type BulkStripSpec struct {
+	ID      string `json:"id"`
+	MfgDate string `json:"mfgDate,omitempty"`
+	ExpDate string `json:"expDate,omitempty"`
+}
+
+// SerialRange generates strip IDs prefix+start .. prefix+(start+count-1), zero-padded to width digits
+type SerialRange struct {
+	Prefix string `json:"prefix"`
+	Start  int    `json:"start"`
+	Count  int    `json:"count"`
+	Width  int    `json:"width,omitempty"`
+}
+
+// BulkStripRequest is the input of CreateStripsBulk: either an explicit list of strips or a serial range,
+// all belonging to one batch
+type BulkStripRequest struct {
+	BatchNumber string          `json:"batchNumber"`
+	MfgDate     string          `json:"mfgDate"`
+	ExpDate     string          `json:"expDate"`
+	Strips      []BulkStripSpec `json:"strips,omitempty"`
+	Range       *SerialRange    `json:"range,omitempty"`
+}
+
+// BulkStripSummary is returned by CreateStripsBulk
+type BulkStripSummary struct {
+	BatchNumber  string   `json:"batchNumber"`
+	GTIN         string   `json:"gtin"`
+	Created      int      `json:"created"`
+	StripIDs     []string `json:"stripIds"`
+	CreationTxId string   `json:"creationTxId"` // Shared by every strip created in this transaction
+}
+
+// expandStripSpecs turns a bulk request into the list of strips to create, applying default dates
+func expandStripSpecs(request *BulkStripRequest) ([]BulkStripSpec, error) {
+	if len(request.Strips) > 0 && request.Range != nil {
+		return nil, fmt.Errorf("give either strips or range, not both")
+	}
+
+	var specs []BulkStripSpec
+	if request.Range != nil {
+		r := request.Range
+		if r.Count <= 0 || r.Start < 0 {
+			return nil, fmt.Errorf("range needs a non-negative start and a positive count")
+		}
+		if r.Count > maxBulkStrips {
+			return nil, fmt.Errorf("range of %d strips exceeds the limit of %d per transaction", r.Count, maxBulkStrips)
+		}
+		for i := 0; i < r.Count; i++ {
+			serial := strconv.Itoa(r.Start + i)
+			if len(serial) < r.Width {
+				serial = strings.Repeat("0", r.Width-len(serial)) + serial
+			}
+			specs = append(specs, BulkStripSpec{ID: r.Prefix + serial})
+		}
+	} else {
+		specs = request.Strips
+	}
+
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no strips given")
+	}
+	if len(specs) > maxBulkStrips {
+		return nil, fmt.Errorf("%d strips exceeds the limit of %d per transaction", len(specs), maxBulkStrips)
+	}
+
+	for i := range specs {
+		if specs[i].MfgDate == "" {
+			specs[i].MfgDate = request.MfgDate
+		}
+		if specs[i].ExpDate == "" {
+			specs[i].ExpDate = request.ExpDate
+		}
+	}
+	return specs, nil
+}
+
+// CreateStripsBulk creates up to maxBulkStrips strips of one batch in a single transaction.
+// requestJSON is a BulkStripRequest; every strip is validated before any is written, so either all
+// strips are created or none are.
+func (c *PharmaContract) CreateStripsBulk(ctx contractapi.TransactionContextInterface, requestJSON string) (*BulkStripSummary, error) {
+	if err := c.checkAccess(ctx, "CreateStripsBulk"); err != nil {
+		return nil, err
+	}
+
+	var request BulkStripRequest
+	err := json.Unmarshal([]byte(requestJSON), &request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bulk strip request: %v", err)
+	}
+
+	specs, err := expandStripSpecs(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	product, err := c.getReleasedBatchProduct(ctx, request.BatchNumber, mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate everything first; the world state is only written once all strips pass
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if err := checkItemID(DocTypeStrip, spec.ID); err != nil {
+			return nil, err
+		}
+		if seen[spec.ID] {
+			return nil, fmt.Errorf("strip %s appears more than once in the request", spec.ID)
+		}
+		seen[spec.ID] = true
+
+		exists, err := c.assetExists(ctx, spec.ID)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("strip %s already exists", spec.ID)
+		}
+
+		err = validateStripDates(spec.MfgDate, spec.ExpDate)
+		if err != nil {
+			return nil, fmt.Errorf("strip %s: %v", spec.ID, err)
+		}
+	}
+
+	txId := ctx.GetStub().GetTxID()
+	now := c.getTxTimestamp(ctx)
+
+	summary := &BulkStripSummary{
+		BatchNumber:  request.BatchNumber,
+		GTIN:         product.GTIN,
+		StripIDs:     make([]string, 0, len(specs)),
+		CreationTxId: txId,
+	}
+
+	for _, spec := range specs {
+		strip := Strip{
+			DocType:          DocTypeStrip,
+			ID:               spec.ID,
+			BatchNumber:      request.BatchNumber,
+			GTIN:             product.GTIN,
+			MedicineType:     product.Name,
+			MfgDate:          spec.MfgDate,
+			ExpDate:          spec.ExpDate,
+			Status:           StatusCreated,
+			CurrentOwner:     mspID,
+			CurrentCustodian: mspID,
+			BoxID:            "",
+			CreationTxId:     txId,
+			CreatedAt:        now,
+			UpdatedAt:        now,
+		}
+
+		err = c.writeAsset(ctx, spec.ID, strip)
+		if err != nil {
+			return nil, err
+		}
+		summary.StripIDs = append(summary.StripIDs, spec.ID)
+	}
+	summary.Created = len(summary.StripIDs)
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventStripsCreated, DocType: DocTypeStrip, ItemIDs: summary.StripIDs, NewStatus: StatusCreated})
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
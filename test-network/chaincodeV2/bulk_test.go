@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestCreateStripsBulk(t *testing.T) {
+	l := newLedger()
+	setupBatch(t, l, "B1")
+
+	var summary *BulkStripSummary
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		summary, err = contract.CreateStripsBulk(ctx, `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","range":{"prefix":"S-","start":1,"count":1000,"width":5}}`)
+		return err
+	})
+	if summary.Created != maxBulkStrips || summary.StripIDs[0] != "S-00001" || summary.StripIDs[999] != "S-01000" || summary.GTIN != testGTIN {
+		t.Fatalf("summary %+v", summary)
+	}
+	strip := getDoc(t, l, "S-01000")
+	if strip["status"] != StatusCreated || strip["creationTxId"] != summary.CreationTxId {
+		t.Errorf("strip %v", strip)
+	}
+
+	// Every strip of the transaction is found by its hash
+	var trace *TxHashTraceResult
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		trace, err = contract.GetTraceByTxHash(ctx, summary.CreationTxId)
+		return err
+	})
+	if len(trace.CreatedItemIDs) != maxBulkStrips || trace.TransactionInfo["itemId"] != "S-00001" {
+		t.Errorf("trace of %d items, first %v", len(trace.CreatedItemIDs), trace.TransactionInfo["itemId"])
+	}
+}
+
+func TestCreateStripsBulkAllOrNothing(t *testing.T) {
+	l := newLedger()
+	setupBatch(t, l, "B1")
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateStrip(ctx, "S5", "B1", "", "2025-01-01", "2027-01-01")
+		return err
+	})
+
+	// strips lists n strips A1..An followed by last
+	strips := func(n int, last string) string {
+		specs := []BulkStripSpec{}
+		for i := 1; i <= n; i++ {
+			specs = append(specs, BulkStripSpec{ID: fmt.Sprintf("A%d", i)})
+		}
+		if last != "" {
+			specs = append(specs, BulkStripSpec{ID: last})
+		}
+		specsJSON, err := json.Marshal(specs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(specsJSON)
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		err     string
+	}{
+		{"range over the limit", `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","range":{"prefix":"A","start":1,"count":1001}}`, "range of 1001 strips exceeds the limit of 1000"},
+		{"list over the limit", `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","strips":` + strips(maxBulkStrips, "A0") + `}`, "1001 strips exceeds the limit of 1000"},
+		{"existing strip last", `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","strips":` + strips(maxBulkStrips-1, "S5") + `}`, "strip S5 already exists"},
+		{"repeated strip", `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","strips":` + strips(3, "A2") + `}`, "strip A2 appears more than once"},
+		{"bad dates last", `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","strips":[{"id":"A1"},{"id":"A2","expDate":"2024-01-01"}]}`, "strip A2"},
+		{"strips and range", `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","strips":[{"id":"A1"}],"range":{"prefix":"A","start":2,"count":1}}`, "either strips or range"},
+		{"nothing", `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01"}`, "no strips given"},
+		{"unreleased batch", `{"batchNumber":"B9","mfgDate":"2025-01-01","expDate":"2027-01-01","strips":[{"id":"A1"}]}`, "batch B9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+				_, err := contract.CreateStripsBulk(ctx, tt.request)
+				return err
+			})
+			expectError(t, err, tt.err)
+			if l.state["A1"] != nil {
+				t.Fatal("a refused request created strip A1")
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transfer statuses
+const (
+	TransferStatusProposed = "PROPOSED"
+	TransferStatusAccepted = "ACCEPTED"
+	TransferStatusRejected = "REJECTED"
+)
+
+// Transfer is a proposed handover of an item, and everything packed in it, to another organization.
+// Custody only changes when the receiving organization accepts.
+type Transfer struct {
+	DocType        string    `json:"docType"`
+	ID             string    `json:"id"`
+	ItemID         string    `json:"itemId"`
+	ItemType       string    `json:"itemType"`
+	FromOrg        string    `json:"fromOrg"`   // Custodian that proposed the transfer
+	ToOrg          string    `json:"toOrg"`     // The only organization that can accept
+	Ownership      bool      `json:"ownership"` // Whether ownership passes along with custody
+	Status         string    `json:"status"`
+	RejectedBy     string    `json:"rejectedBy,omitempty"` // The receiving organization, or the proposer withdrawing it
+	Reason         string    `json:"reason,omitempty"`
+	CreationTxId   string    `json:"creationTxId"`
+	CompletionTxId string    `json:"completionTxId,omitempty"` // The transaction that accepted or rejected it
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// CustodyRecord is one change of an item's owner or custodian
+type CustodyRecord struct {
+	Owner     string    `json:"owner"`
+	Custodian string    `json:"custodian"`
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func transferID(itemID string, txID string) string {
+	return "TRANSFER_" + itemID + "_" + txID
+}
+
+// custodianOf returns the organization holding an item. Items stored before custody was tracked are
+// held by their owner.
+func custodianOf(doc map[string]interface{}) string {
+	if custodian := docString(doc, "currentCustodian"); custodian != "" {
+		return custodian
+	}
+	return docString(doc, "currentOwner")
+}
+
+// handOver passes an item to the custody and ownership of an organization, as when an order is received
+func handOver(doc map[string]interface{}, org string) {
+	doc["currentOwner"] = org
+	doc["currentCustodian"] = org
+}
+
+// checkCustody refuses changes to an item by an organization not holding it, and to an item being transferred.
+// Items without any recorded custodian are left unchecked.
+func checkCustody(doc map[string]interface{}, mspID string) error {
+	docType, id := docString(doc, "docType"), docString(doc, "id")
+	if pending := docString(doc, "pendingTransfer"); pending != "" {
+		return fmt.Errorf("%s %s is awaiting transfer %s", docType, id, pending)
+	}
+	if custodian := custodianOf(doc); custodian != "" && custodian != mspID {
+		return fmt.Errorf("%s %s is in the custody of %s, not %s", docType, id, custodian, mspID)
+	}
+	return nil
+}
+
+// getTransfer reads a transfer record
+func (c *PharmaContract) getTransfer(ctx contractapi.TransactionContextInterface, id string) (*Transfer, error) {
+	var transfer Transfer
+	err := c.readAsset(ctx, id, &transfer)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.DocType != DocTypeTransfer {
+		return nil, fmt.Errorf("%s is not a transfer", id)
+	}
+	return &transfer, nil
+}
+
+// updateDown applies change to an item and to every item packed in it, and returns the IDs of the packed items
+func (c *PharmaContract) updateDown(ctx contractapi.TransactionContextInterface, id string, now time.Time, change func(doc map[string]interface{})) ([]string, error) {
+	doc, err := c.readDoc(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	change(doc)
+	doc["updatedAt"] = now
+
+	err = c.writeAsset(ctx, id, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var contents []string
+	for _, childID := range docChildIDs(doc) {
+		below, err := c.updateDown(ctx, childID, now, change)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(append(contents, childID), below...)
+	}
+	return contents, nil
+}
+
+// ProposeTransfer offers an item, with everything packed in it, to another organization. Only the current
+// custodian can propose, and only an item that is not packed in anything; to hand over ownership as well
+// the caller must also own it. The item and its contents cannot be repacked or ordered until the transfer
+// is accepted or rejected.
+func (c *PharmaContract) ProposeTransfer(ctx contractapi.TransactionContextInterface, itemID string, toOrg string, ownership bool) (*Transfer, error) {
+	if err := c.checkAccess(ctx, "ProposeTransfer"); err != nil {
+		return nil, err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if toOrg == "" || toOrg == mspID {
+		return nil, fmt.Errorf("transfers must go to another organization")
+	}
+
+	item, err := c.readDoc(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	docType := docString(item, "docType")
+	if !containsString(itemDocTypes(), docType) {
+		return nil, fmt.Errorf("%s is a %s, only items can be transferred", itemID, docType)
+	}
+	if parentType, parentID := docParent(item); parentID != "" {
+		return nil, fmt.Errorf("%s %s is packed in %s %s, transfer that instead", docType, itemID, parentType, parentID)
+	}
+	if custodianOf(item) == "" {
+		return nil, fmt.Errorf("%s %s has no recorded custodian", docType, itemID)
+	}
+	err = checkCustody(item, mspID)
+	if err != nil {
+		return nil, err
+	}
+	if ownership && docString(item, "currentOwner") != mspID {
+		return nil, fmt.Errorf("%s %s is owned by %s, not %s", docType, itemID, docString(item, "currentOwner"), mspID)
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	now := c.getTxTimestamp(ctx)
+	transfer := Transfer{
+		DocType:      DocTypeTransfer,
+		ID:           transferID(itemID, txID),
+		ItemID:       itemID,
+		ItemType:     docType,
+		FromOrg:      mspID,
+		ToOrg:        toOrg,
+		Ownership:    ownership,
+		Status:       TransferStatusProposed,
+		CreationTxId: txID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	// Every unit in the item is locked until the receiving organization answers
+	_, err = c.updateDown(ctx, itemID, now, func(doc map[string]interface{}) {
+		doc["pendingTransfer"] = transfer.ID
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = c.writeAsset(ctx, transfer.ID, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventTransferProposed,
+		DocType:   DocTypeTransfer,
+		ItemIDs:   []string{transfer.ID},
+		NewStatus: TransferStatusProposed,
+		ChildIDs:  []string{itemID},
+		Details:   transfer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// AcceptTransfer completes a proposed transfer. Only the receiving organization can accept; custody, and
+// ownership when included, passes to it for the item and every unit packed in it.
+func (c *PharmaContract) AcceptTransfer(ctx contractapi.TransactionContextInterface, transferID string) (*Transfer, error) {
+	if err := c.checkAccess(ctx, "AcceptTransfer"); err != nil {
+		return nil, err
+	}
+
+	transfer, err := c.getTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != transfer.ToOrg {
+		return nil, fmt.Errorf("transfer %s can only be accepted by %s, not %s", transferID, transfer.ToOrg, mspID)
+	}
+	err = checkTransition(DocTypeTransfer, transferID, transfer.Status, TransferStatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.getTxTimestamp(ctx)
+	contents, err := c.updateDown(ctx, transfer.ItemID, now, func(doc map[string]interface{}) {
+		doc["currentCustodian"] = transfer.ToOrg
+		if transfer.Ownership {
+			doc["currentOwner"] = transfer.ToOrg
+		}
+		delete(doc, "pendingTransfer")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transfer.Status = TransferStatusAccepted
+	transfer.CompletionTxId = ctx.GetStub().GetTxID()
+	transfer.UpdatedAt = now
+	err = c.writeAsset(ctx, transferID, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventTransferAccepted,
+		DocType:   DocTypeTransfer,
+		ItemIDs:   []string{transferID},
+		OldStatus: TransferStatusProposed,
+		NewStatus: TransferStatusAccepted,
+		ChildIDs:  append([]string{transfer.ItemID}, contents...),
+		Details:   transfer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// RejectTransfer declines a proposed transfer, leaving custody where it was. The receiving organization
+// rejects it; the proposing organization can use it to withdraw the proposal.
+func (c *PharmaContract) RejectTransfer(ctx contractapi.TransactionContextInterface, transferID string, reason string) (*Transfer, error) {
+	if err := c.checkAccess(ctx, "RejectTransfer"); err != nil {
+		return nil, err
+	}
+
+	transfer, err := c.getTransfer(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != transfer.ToOrg && mspID != transfer.FromOrg {
+		return nil, fmt.Errorf("transfer %s can only be rejected by %s or withdrawn by %s", transferID, transfer.ToOrg, transfer.FromOrg)
+	}
+	err = checkTransition(DocTypeTransfer, transferID, transfer.Status, TransferStatusRejected)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.getTxTimestamp(ctx)
+	_, err = c.updateDown(ctx, transfer.ItemID, now, func(doc map[string]interface{}) {
+		delete(doc, "pendingTransfer")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transfer.Status = TransferStatusRejected
+	transfer.RejectedBy = mspID
+	transfer.Reason = reason
+	transfer.CompletionTxId = ctx.GetStub().GetTxID()
+	transfer.UpdatedAt = now
+	err = c.writeAsset(ctx, transferID, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventTransferRejected,
+		DocType:   DocTypeTransfer,
+		ItemIDs:   []string{transferID},
+		OldStatus: TransferStatusProposed,
+		NewStatus: TransferStatusRejected,
+		ChildIDs:  []string{transfer.ItemID},
+		Details:   transfer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// GetTransfer retrieves a transfer record
+func (c *PharmaContract) GetTransfer(ctx contractapi.TransactionContextInterface, transferID string) (*Transfer, error) {
+	return c.getTransfer(ctx, transferID)
+}
+
+// GetPendingTransfers returns the proposed transfers an organization is sending or receiving
+func (c *PharmaContract) GetPendingTransfers(ctx contractapi.TransactionContextInterface, mspID string) ([]*Transfer, error) {
+	transferDocs, err := c.getIndexedDocs(ctx, IndexDocTypeStatus, DocTypeTransfer, TransferStatusProposed)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := []*Transfer{}
+	for _, transferDoc := range transferDocs {
+		var transfer Transfer
+		err = json.Unmarshal(transferDoc, &transfer)
+		if err != nil {
+			return nil, err
+		}
+		if transfer.FromOrg == mspID || transfer.ToOrg == mspID {
+			transfers = append(transfers, &transfer)
+		}
+	}
+	return transfers, nil
+}
+
+// GetCustodyHistory returns every change of an item's owner or custodian, oldest first, read from the
+// history of the item's own key
+func (c *PharmaContract) GetCustodyHistory(ctx contractapi.TransactionContextInterface, itemID string) ([]*CustodyRecord, error) {
+	historyIterator, err := ctx.GetStub().GetHistoryForKey(itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %v", err)
+	}
+	defer historyIterator.Close()
+
+	var versions []*CustodyRecord
+	for historyIterator.HasNext() {
+		modification, err := historyIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if modification.IsDelete || modification.Value == nil {
+			continue
+		}
+		var doc map[string]interface{}
+		err = json.Unmarshal(modification.Value, &doc)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, &CustodyRecord{
+			Owner:     docString(doc, "currentOwner"),
+			Custodian: custodianOf(doc),
+			TxID:      modification.TxId,
+			Timestamp: modification.Timestamp.AsTime(),
+		})
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Timestamp.Before(versions[j].Timestamp)
+	})
+
+	records := []*CustodyRecord{}
+	for _, version := range versions {
+		last := len(records) - 1
+		if last >= 0 && records[last].Owner == version.Owner && records[last].Custodian == version.Custodian {
+			continue
+		}
+		records = append(records, version)
+	}
+	return records, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import "testing"
+
+func TestTransferHandover(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildShipment(t, l, "P2", "B1")
+
+	propose := func(id *mockIdentity, itemID string, ownership bool) (*Transfer, error) {
+		var transfer *Transfer
+		err := submit(t, l, id, func(ctx *mockCtx) error {
+			var err error
+			transfer, err = contract.ProposeTransfer(ctx, itemID, "Org2MSP", ownership)
+			return err
+		})
+		return transfer, err
+	}
+	accept := func(id *mockIdentity, transferID string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.AcceptTransfer(ctx, transferID)
+			return err
+		})
+	}
+	reject := func(id *mockIdentity, transferID string, reason string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.RejectTransfer(ctx, transferID, reason)
+			return err
+		})
+	}
+	held := func(id string, owner string, custodian string) {
+		t.Helper()
+		doc := getDoc(t, l, id)
+		if doc["currentOwner"] != owner || doc["currentCustodian"] != custodian || doc["pendingTransfer"] != nil {
+			t.Errorf("%s is owned by %v, held by %v, pending %v", id, doc["currentOwner"], doc["currentCustodian"], doc["pendingTransfer"])
+		}
+	}
+
+	// Only the custodian proposes, and only for items not packed in anything
+	expectError(t, submit(t, l, org2Pharm, func(ctx *mockCtx) error {
+		_, err := contract.ProposeTransfer(ctx, "P1-SH", "Org1MSP", false)
+		return err
+	}), "in the custody of Org1MSP, not Org2MSP")
+	_, err := propose(org1Mfr, "P1-S000", false)
+	expectError(t, err, "is packed in box P1-B00")
+
+	transfer, err := propose(org1Dist, "P1-SH", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = propose(org1Dist, "P1-SH", false)
+	expectError(t, err, "awaiting transfer "+transfer.ID)
+
+	// Everything in the proposed item is locked until the receiver answers
+	expectError(t, submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.MoveChild(ctx, "P1-S000", "")
+		return err
+	}), "awaiting transfer")
+	if doc := getDoc(t, l, "P1-S111"); doc["pendingTransfer"] != transfer.ID || doc["currentCustodian"] != "Org1MSP" {
+		t.Fatalf("P1-S111 pending %v, held by %v", doc["pendingTransfer"], doc["currentCustodian"])
+	}
+	var pending []*Transfer
+	mustSubmit(t, l, org2Pharm, func(ctx *mockCtx) error {
+		var err error
+		pending, err = contract.GetPendingTransfers(ctx, "Org2MSP")
+		return err
+	})
+	if len(pending) != 1 || pending[0].ID != transfer.ID {
+		t.Fatalf("pending transfers %+v", pending)
+	}
+
+	// Only the receiver accepts; custody moves down the hierarchy and ownership stays
+	expectError(t, accept(org1Dist, transfer.ID), "can only be accepted by Org2MSP")
+	if err = accept(org2Pharm, transfer.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"P1-SH", "P1-C0", "P1-B11", "P1-S111"} {
+		held(id, "Org1MSP", "Org2MSP")
+	}
+	held("P2-S000", "Org1MSP", "Org1MSP")
+	expectError(t, reject(org2Pharm, transfer.ID, "late"), "ACCEPTED")
+	name, event := l.lastEvent(t)
+	if name != EventTransferAccepted || event.NewStatus != TransferStatusAccepted || len(event.ChildIDs) != 15 {
+		t.Errorf("event %s %+v", name, event)
+	}
+
+	// The former custodian can no longer order or unpack what it handed over
+	expectError(t, submit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.CreateOrder(ctx, "O1", idList("P1-SH"), "pharm1", "Org2MSP")
+		return err
+	}), "in the custody of Org2MSP")
+	expectError(t, submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.UnpackShipment(ctx, "P1-SH")
+		return err
+	}), "in the custody of Org2MSP")
+
+	var history []*CustodyRecord
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		history, err = contract.GetCustodyHistory(ctx, "P1-S111")
+		return err
+	})
+	if len(history) != 2 || history[0].Custodian != "Org1MSP" || history[1].Custodian != "Org2MSP" || history[1].Owner != "Org1MSP" {
+		for _, record := range history {
+			t.Logf("%+v", *record)
+		}
+		t.Fatal("unexpected custody history of P1-S111")
+	}
+
+	// Rejecting or withdrawing a proposal leaves custody where it was
+	transfer, err = propose(org1Dist, "P2-SH", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, reject(org2Dist, "TRANSFER_X", "unknown"), "TRANSFER_X")
+	if err = reject(org2Pharm, transfer.ID, "not ordered"); err != nil {
+		t.Fatal(err)
+	}
+	held("P2-SH", "Org1MSP", "Org1MSP")
+	held("P2-S000", "Org1MSP", "Org1MSP")
+
+	transfer, err = propose(org1Dist, "P2-SH", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reject(org1Dist, transfer.ID, "withdrawn"); err != nil {
+		t.Fatal(err)
+	}
+	if doc := getDoc(t, l, transfer.ID); doc["status"] != TransferStatusRejected || doc["rejectedBy"] != "Org1MSP" {
+		t.Errorf("withdrawn transfer %v", doc)
+	}
+	held("P2-SH", "Org1MSP", "Org1MSP")
+
+	// With ownership, both pass to the receiver
+	transfer, err = propose(org1Dist, "P2-SH", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = accept(org2Dist, transfer.ID); err != nil {
+		t.Fatal(err)
+	}
+	held("P2-SH", "Org2MSP", "Org2MSP")
+	held("P2-S111", "Org2MSP", "Org2MSP")
+	checkStatistics(t, l, "transfers")
+}
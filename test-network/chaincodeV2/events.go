@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Chaincode event names. Fabric keeps only the last event a transaction sets, so every mutating
+// transaction emits exactly one event, with an ItemEvent payload covering everything it changed.
+// The payload schema is documented in EVENTS.md.
+const (
+	EventStripsCreated           = "StripsCreated"
+	EventContainerSealed         = "ContainerSealed"
+	EventContainerUnpacked       = "ContainerUnpacked"
+	EventItemMoved               = "ItemMoved"
+	EventShipmentDistributed     = "ShipmentDistributed"
+	EventOrderCreated            = "OrderCreated"
+	EventOrderCancelled          = "OrderCancelled"
+	EventOrderAmended            = "OrderAmended"
+	EventOrderDispatched         = "OrderDispatched"
+	EventOrderDelivered          = "OrderDelivered"
+	EventOrderReceived           = "OrderReceived"
+	EventBatchCreated            = "BatchCreated"
+	EventBatchReleased           = "BatchReleased"
+	EventBatchRejected           = "BatchRejected"
+	EventBatchRecalled           = "BatchRecalled"
+	EventProductRegistered       = "ProductRegistered"
+	EventProductUpdated          = "ProductUpdated"
+	EventPackingRulesSet         = "PackingRulesSet"
+	EventCompanyPrefixRegistered = "CompanyPrefixRegistered"
+	EventAccessPolicySet         = "AccessPolicySet"
+	EventIndexesRebuilt          = "IndexesRebuilt"
+	EventStatisticsRebuilt       = "StatisticsRebuilt"
+	EventStatisticsPruned        = "StatisticsPruned"
+	EventTransferProposed        = "TransferProposed"
+	EventTransferAccepted        = "TransferAccepted"
+	EventTransferRejected        = "TransferRejected"
+)
+
+// ItemEvent is the payload of every chaincode event
+type ItemEvent struct {
+	Name      string      `json:"name"`
+	DocType   string      `json:"docType"`            // Doc type of the items in ItemIDs
+	ItemIDs   []string    `json:"itemIds"`            // Keys of the documents the transaction created or changed
+	OldStatus string      `json:"oldStatus"`          // Status of the items before the transaction, empty when created
+	NewStatus string      `json:"newStatus"`          // Status of the items after the transaction
+	ChildIDs  []string    `json:"childIds,omitempty"` // Items packed into, taken out of or carried along with ItemIDs
+	ActorMSP  string      `json:"actorMsp"`           // MSP ID of the organization that submitted the transaction
+	TxID      string      `json:"txId"`
+	Timestamp time.Time   `json:"timestamp"`
+	Details   interface{} `json:"details,omitempty"` // Event-specific data, see EVENTS.md
+}
+
+// emitEvent stamps an event with the caller, transaction and time and sets it as the transaction's event
+func (c *PharmaContract) emitEvent(ctx contractapi.TransactionContextInterface, event ItemEvent) error {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	event.ActorMSP = mspID
+	event.TxID = ctx.GetStub().GetTxID()
+	event.Timestamp = c.getTxTimestamp(ctx)
+	if event.ItemIDs == nil {
+		event.ItemIDs = []string{}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().SetEvent(event.Name, payload)
+	if err != nil {
+		return fmt.Errorf("failed to emit %s event: %v", event.Name, err)
+	}
+	return nil
+}
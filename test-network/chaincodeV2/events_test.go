@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestOneEventPerTransaction(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildCarton(t, l, "C9", "B1")
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.MoveChild(ctx, "P1-S000", "")
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.UnpackCarton(ctx, "C9")
+		return err
+	})
+	createOrder(t, l, "O1", "P1-SH")
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	})
+	expectError(t, submit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	}), "O1")
+
+	// The mock refuses a second event in a transaction, so each committed write set carries exactly one
+	if len(l.silent) != 0 {
+		t.Fatalf("transactions %v wrote state without an event", l.silent)
+	}
+	txIDs := map[string]bool{}
+	names := map[string]int{}
+	for _, committed := range l.events {
+		var event ItemEvent
+		err := json.Unmarshal(committed.Payload, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Name != committed.Name || event.TxID == "" || txIDs[event.TxID] || event.ActorMSP == "" || event.ItemIDs == nil {
+			t.Errorf("event %s: %s", committed.Name, committed.Payload)
+		}
+		txIDs[event.TxID] = true
+		names[event.Name]++
+	}
+	want := map[string]int{
+		EventProductRegistered: 1,
+		EventBatchCreated:      1,
+		EventBatchReleased:     1,
+		EventStripsCreated:     9,
+		EventContainerSealed:   4 + 2 + 1 + 2,
+		EventItemMoved:         1,
+		EventContainerUnpacked: 1,
+		EventOrderCreated:      1,
+		EventOrderDispatched:   1,
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("events %v, want %v", names, want)
+	}
+
+	// The event of a cascading transaction covers the whole change
+	name, event := l.lastEvent(t)
+	if name != EventOrderDispatched || event.DocType != DocTypeOrder || !reflect.DeepEqual(event.ItemIDs, []string{"O1"}) ||
+		event.OldStatus != StatusCreated || event.NewStatus != StatusDispatched || len(event.ChildIDs) == 0 || event.ActorMSP != "Org1MSP" {
+		t.Errorf("event %s %+v", name, event)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// dateLayout is the ISO 8601 calendar date format used for Strip.MfgDate and Strip.ExpDate
+const dateLayout = "2006-01-02"
+
+// minShelfLifeDays is the shelf life stock must have left to be sealed or dispatched
+const minShelfLifeDays = 30
+
+// ExpiringItem is a strip returned by GetExpiringItems
+type ExpiringItem struct {
+	Strip         *Strip `json:"strip"`
+	DaysRemaining int    `json:"daysRemaining"` // Negative once the strip has expired
+	Expired       bool   `json:"expired"`
+}
+
+// validateStripDates checks that mfgDate and expDate are ISO dates with expDate after mfgDate
+func validateStripDates(mfgDate string, expDate string) error {
+	mfg, err := time.Parse(dateLayout, mfgDate)
+	if err != nil {
+		return fmt.Errorf("invalid manufacturing date %q, expected YYYY-MM-DD", mfgDate)
+	}
+	exp, err := time.Parse(dateLayout, expDate)
+	if err != nil {
+		return fmt.Errorf("invalid expiry date %q, expected YYYY-MM-DD", expDate)
+	}
+	if !exp.After(mfg) {
+		return fmt.Errorf("expiry date %s must be after manufacturing date %s", expDate, mfgDate)
+	}
+	return nil
+}
+
+// daysUntil returns the number of whole days from now until the start of date, rounded down so a strip
+// that expired a few hours ago is at -1 rather than 0
+func daysUntil(date time.Time, now time.Time) int {
+	return int(math.Floor(date.Sub(now).Hours() / 24))
+}
+
+// checkShelfLife refuses a strip that has expired or expires within minShelfLifeDays of now. Strips created
+// before dates were validated may have an expiry date that cannot be parsed; their shelf life is unknown
+// and they are let through, as GetExpiringItems skips them.
+func checkShelfLife(strip *Strip, now time.Time) error {
+	exp, err := time.Parse(dateLayout, strip.ExpDate)
+	if err != nil {
+		return nil
+	}
+	if !now.Before(exp) {
+		return fmt.Errorf("strip %s expired on %s", strip.ID, strip.ExpDate)
+	}
+	if now.AddDate(0, 0, minShelfLifeDays).After(exp) {
+		return fmt.Errorf("strip %s expires on %s, less than %d days away", strip.ID, strip.ExpDate, minShelfLifeDays)
+	}
+	return nil
+}
+
+// collectStripIDs returns the IDs of every strip packed under an item (order, container or strip)
+func (c *PharmaContract) collectStripIDs(ctx contractapi.TransactionContextInterface, id string) ([]string, error) {
+	doc, err := c.readDoc(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	docType := docString(doc, "docType")
+	if docType == DocTypeStrip {
+		return []string{id}, nil
+	}
+	if !isContainer(docType) {
+		return nil, fmt.Errorf("item %s of type %s cannot contain strips", id, docType)
+	}
+
+	var stripIDs []string
+	for _, childID := range docChildIDs(doc) {
+		childStrips, err := c.collectStripIDs(ctx, childID)
+		if err != nil {
+			return nil, err
+		}
+		stripIDs = append(stripIDs, childStrips...)
+	}
+	return stripIDs, nil
+}
+
+// checkContentsShelfLife refuses an item if any strip packed under it is expired or near expiry
+func (c *PharmaContract) checkContentsShelfLife(ctx contractapi.TransactionContextInterface, id string, now time.Time) error {
+	stripIDs, err := c.collectStripIDs(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, stripID := range stripIDs {
+		var strip Strip
+		err = c.readAsset(ctx, stripID, &strip)
+		if err != nil {
+			return err
+		}
+		err = checkShelfLife(&strip, now)
+		if err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// GetExpiringItems returns undelivered, unrecalled strips expiring within windowDays (including expired ones),
+// soonest first
+func (c *PharmaContract) GetExpiringItems(ctx contractapi.TransactionContextInterface, windowDays int) ([]*ExpiringItem, error) {
+	if windowDays < 0 {
+		return nil, fmt.Errorf("windowDays must not be negative")
+	}
+
+	now := c.getTxTimestamp(ctx)
+	cutoff := now.AddDate(0, 0, windowDays).Format(dateLayout)
+
+	stripDocs, err := c.getIndexedDocs(ctx, IndexDocTypeStatus, DocTypeStrip)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*ExpiringItem{}
+	for _, stripDoc := range stripDocs {
+		var strip Strip
+		err = json.Unmarshal(stripDoc, &strip)
+		if err != nil {
+			return nil, err
+		}
+		if strip.Status == StatusDelivered || strip.Status == StatusRecalled || strip.ExpDate > cutoff {
+			continue
+		}
+
+		exp, err := time.Parse(dateLayout, strip.ExpDate)
+		if err != nil {
+			// Strips created before dates were validated may not be parseable
+			continue
+		}
+
+		items = append(items, &ExpiringItem{
+			Strip:         &strip,
+			DaysRemaining: daysUntil(exp, now),
+			Expired:       !now.Before(exp),
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Strip.ExpDate < items[j].Strip.ExpDate
+	})
+
+	return items, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDaysUntil(t *testing.T) {
+	now := time.Date(2025, 1, 10, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		date string
+		want int
+	}{
+		{"2025-01-09", -2},
+		{"2025-01-10", -1},
+		{"2025-01-11", 0},
+		{"2025-01-12", 1},
+		{"2025-02-10", 30},
+	}
+	for _, tt := range tests {
+		date, err := time.Parse(dateLayout, tt.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := daysUntil(date, now); got != tt.want {
+			t.Errorf("daysUntil(%s) = %d, want %d", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestCheckShelfLife(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expDate string
+		err     string
+	}{
+		{"2025-01-10", "expired on 2025-01-10"},
+		{"2025-01-01", "expired on 2025-01-01"},
+		{"2025-02-08", "less than 30 days away"},
+		{"2025-02-10", ""},
+		{"2027-01-01", ""},
+		{"12/2026", ""}, // Written before dates were validated; its shelf life is unknown
+	}
+	for _, tt := range tests {
+		err := checkShelfLife(&Strip{ID: "S1", ExpDate: tt.expDate}, now)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.expDate, err)
+		}
+		if tt.err != "" {
+			expectError(t, err, tt.err)
+		}
+	}
+}
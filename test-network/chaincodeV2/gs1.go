@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// gs1CheckDigit computes the GS1 mod-10 check digit for a string of digits (without its check digit)
+func gs1CheckDigit(digits string) (int, error) {
+	sum := 0
+	weight := 3
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if d < '0' || d > '9' {
+			return 0, fmt.Errorf("%q contains non-digit characters", digits)
+		}
+		sum += int(d-'0') * weight
+		weight = 4 - weight
+	}
+	return (10 - sum%10) % 10, nil
+}
+
+// validateGS1Number checks that code is all digits, of one of the allowed lengths, with a valid check digit
+func validateGS1Number(code string, lengths ...int) error {
+	validLength := false
+	for _, l := range lengths {
+		if len(code) == l {
+			validLength = true
+		}
+	}
+	if !validLength {
+		return fmt.Errorf("%q must be %v digits long", code, lengths)
+	}
+
+	check, err := gs1CheckDigit(code[:len(code)-1])
+	if err != nil {
+		return err
+	}
+	if int(code[len(code)-1]-'0') != check {
+		return fmt.Errorf("%q has an invalid check digit, expected %d", code, check)
+	}
+	return nil
+}
+
+// normalizeGTIN validates a GTIN-8/12/13/14 and returns it zero-padded to 14 digits
+func normalizeGTIN(gtin string) (string, error) {
+	err := validateGS1Number(gtin, 8, 12, 13, 14)
+	if err != nil {
+		return "", fmt.Errorf("invalid GTIN: %v", err)
+	}
+	for len(gtin) < 14 {
+		gtin = "0" + gtin
+	}
+	return gtin, nil
+}
+
+// GS1 application identifiers understood by ScanGS1
+const (
+	AISSCC   = "00"
+	AIGTIN   = "01"
+	AILot    = "10"
+	AIExpiry = "17"
+	AISerial = "21"
+)
+
+// gs1GroupSeparator is the FNC1 separator that ends variable-length fields in raw element strings
+const gs1GroupSeparator = '\x1d'
+
+// gs1AI describes how an application identifier's data field is encoded
+type gs1AI struct {
+	length int  // Fixed length, or maximum length when variable
+	fixed  bool // Fixed-length fields need no FNC1 separator after them
+}
+
+// gs1AIs lists the application identifiers found on pharmaceutical packs and logistic units
+var gs1AIs = map[string]gs1AI{
+	"00":  {length: 18, fixed: true}, // SSCC
+	"01":  {length: 14, fixed: true}, // GTIN
+	"02":  {length: 14, fixed: true}, // GTIN of contained trade items
+	"10":  {length: 20},              // Batch/lot
+	"11":  {length: 6, fixed: true},  // Production date
+	"15":  {length: 6, fixed: true},  // Best before date
+	"17":  {length: 6, fixed: true},  // Expiry date
+	"21":  {length: 20},              // Serial number
+	"30":  {length: 8},               // Variable count
+	"37":  {length: 8},               // Count of contained trade items
+	"240": {length: 30},              // Additional product identification
+	"710": {length: 20},              // National healthcare reimbursement number (NHRN) - Germany
+	"711": {length: 20},              // NHRN - France
+	"712": {length: 20},              // NHRN - Spain
+	"713": {length: 20},              // NHRN - Brazil
+	"714": {length: 20},              // NHRN - Portugal
+}
+
+// symbologyIdentifiers are prefixes scanners may put in front of a GS1 element string
+var symbologyIdentifiers = []string{"]d2", "]C1", "]Q3", "]e0"}
+
+// parseGS1ElementString parses either the human readable "(01)...(21)..." form or the raw
+// FNC1-separated form of a GS1 element string into a map of AI to value
+func parseGS1ElementString(input string) (map[string]string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, fmt.Errorf("empty GS1 element string")
+	}
+	if strings.HasPrefix(input, "(") {
+		return parseBracketedGS1(input)
+	}
+	return parseRawGS1(input)
+}
+
+// parseBracketedGS1 parses the human readable form, where every AI is wrapped in parentheses
+func parseBracketedGS1(input string) (map[string]string, error) {
+	elements := map[string]string{}
+	rest := input
+	for rest != "" {
+		if rest[0] != '(' {
+			return nil, fmt.Errorf("expected '(' at %q", rest)
+		}
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated application identifier in %q", rest)
+		}
+		ai := rest[1:end]
+		rest = rest[end+1:]
+
+		next := strings.IndexByte(rest, '(')
+		if next < 0 {
+			next = len(rest)
+		}
+		value := rest[:next]
+		rest = rest[next:]
+
+		err := addGS1Element(elements, ai, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+// parseRawGS1 parses the form produced by scanners, where variable-length fields end with FNC1
+func parseRawGS1(input string) (map[string]string, error) {
+	for _, prefix := range symbologyIdentifiers {
+		input = strings.TrimPrefix(input, prefix)
+	}
+	input = strings.TrimLeft(input, string(gs1GroupSeparator))
+
+	elements := map[string]string{}
+	rest := input
+	for rest != "" {
+		ai, def, ok := "", gs1AI{}, false
+		for l := 2; l <= 4 && l <= len(rest); l++ {
+			if def, ok = gs1AIs[rest[:l]]; ok {
+				ai = rest[:l]
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown application identifier at %q", rest)
+		}
+		rest = rest[len(ai):]
+
+		var value string
+		if def.fixed {
+			if len(rest) < def.length {
+				return nil, fmt.Errorf("AI (%s) needs %d characters, found %d", ai, def.length, len(rest))
+			}
+			value = rest[:def.length]
+			rest = rest[def.length:]
+		} else {
+			end := strings.IndexByte(rest, gs1GroupSeparator)
+			if end < 0 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		rest = strings.TrimPrefix(rest, string(gs1GroupSeparator))
+
+		err := addGS1Element(elements, ai, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+// addGS1Element validates one AI value and stores it, rejecting repeated AIs
+func addGS1Element(elements map[string]string, ai string, value string) error {
+	if _, dup := elements[ai]; dup {
+		return fmt.Errorf("AI (%s) appears more than once", ai)
+	}
+	if value == "" {
+		return fmt.Errorf("AI (%s) has no value", ai)
+	}
+	if def, ok := gs1AIs[ai]; ok {
+		if def.fixed && len(value) != def.length {
+			return fmt.Errorf("AI (%s) must be %d characters, got %q", ai, def.length, value)
+		}
+		if !def.fixed && len(value) > def.length {
+			return fmt.Errorf("AI (%s) must be at most %d characters, got %q", ai, def.length, value)
+		}
+	}
+	switch ai {
+	case AISSCC:
+		if err := validateGS1Number(value, 18); err != nil {
+			return fmt.Errorf("invalid SSCC: %v", err)
+		}
+	case AIGTIN:
+		if _, err := normalizeGTIN(value); err != nil {
+			return err
+		}
+	case AIExpiry:
+		if _, err := gs1DateToISO(value); err != nil {
+			return err
+		}
+	}
+	elements[ai] = value
+	return nil
+}
+
+// gs1DateToISO converts a GS1 YYMMDD date to YYYY-MM-DD. A day of "00" means the end of the month,
+// in which case the returned date is the last day of that month.
+func gs1DateToISO(yymmdd string) (string, error) {
+	if len(yymmdd) != 6 {
+		return "", fmt.Errorf("invalid GS1 date %q, expected YYMMDD", yymmdd)
+	}
+	if strings.HasSuffix(yymmdd, "00") {
+		month, err := time.Parse("060102", yymmdd[:4]+"01")
+		if err != nil {
+			return "", fmt.Errorf("invalid GS1 date %q: %v", yymmdd, err)
+		}
+		return month.AddDate(0, 1, -1).Format(dateLayout), nil
+	}
+	date, err := time.Parse("060102", yymmdd)
+	if err != nil {
+		return "", fmt.Errorf("invalid GS1 date %q: %v", yymmdd, err)
+	}
+	return date.Format(dateLayout), nil
+}
+
+// GS1ScanResult is the trace of the unit a GS1 element string resolved to, plus any label/ledger mismatches
+type GS1ScanResult struct {
+	Elements   map[string]string `json:"elements"`   // Parsed data, keyed by application identifier
+	ResolvedID string            `json:"resolvedId"` // Ledger key the element string resolved to
+	Trace      *TraceResult      `json:"trace"`
+	Mismatches []string          `json:"mismatches"` // Label data that disagrees with the ledger
+}
+
+// ScanGS1 resolves a GS1 element string, e.g. (01)GTIN(21)serial(10)lot(17)expiry or its raw FNC1-separated
+// form, to a strip, box, carton, pallet or shipment and cross-checks the label's GTIN, lot and expiry against the ledger.
+// Logistic units are resolved by SSCC (00), trade items by serial number (21).
+func (c *PharmaContract) ScanGS1(ctx contractapi.TransactionContextInterface, elementString string) (*GS1ScanResult, error) {
+	elements, err := parseGS1ElementString(elementString)
+	if err != nil {
+		return nil, err
+	}
+
+	var key string
+	switch {
+	case elements[AISSCC] != "":
+		key = elements[AISSCC]
+	case elements[AISerial] != "":
+		key = elements[AISerial]
+	default:
+		return nil, fmt.Errorf("element string has neither an SSCC (00) nor a serial number (21)")
+	}
+
+	trace, err := c.ScanBarcode(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if trace.ItemType != DocTypeStrip && !isPackagingLevel(trace.ItemType) {
+		return nil, fmt.Errorf("%s resolves to a %s, not a labelled unit", key, trace.ItemType)
+	}
+
+	stripIDs, err := c.collectStripIDs(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Gather what the ledger says is inside the unit
+	var gtins, lots []string
+	earliestExpiry := ""
+	for _, stripID := range stripIDs {
+		var strip Strip
+		err = c.readAsset(ctx, stripID, &strip)
+		if err != nil {
+			return nil, err
+		}
+		gtins = appendUnique(gtins, strip.GTIN)
+		lots = appendUnique(lots, strip.BatchNumber)
+		if earliestExpiry == "" || strip.ExpDate < earliestExpiry {
+			earliestExpiry = strip.ExpDate
+		}
+	}
+
+	mismatches := []string{}
+	if gtin, ok := elements[AIGTIN]; ok {
+		normalized, _ := normalizeGTIN(gtin)
+		if !containsString(gtins, normalized) {
+			mismatches = append(mismatches, fmt.Sprintf("GTIN %s on label, ledger has %v", gtin, gtins))
+		}
+	}
+	if lot, ok := elements[AILot]; ok && !containsString(lots, lot) {
+		mismatches = append(mismatches, fmt.Sprintf("lot %s on label, ledger has %v", lot, lots))
+	}
+	if expiry, ok := elements[AIExpiry]; ok {
+		labelExpiry, _ := gs1DateToISO(expiry)
+		// A day of 00 only states the month, so compare YYYY-MM
+		matches := labelExpiry == earliestExpiry
+		if strings.HasSuffix(expiry, "00") && len(earliestExpiry) >= 7 {
+			matches = labelExpiry[:7] == earliestExpiry[:7]
+		}
+		if !matches {
+			mismatches = append(mismatches, fmt.Sprintf("expiry %s on label, ledger has %s", labelExpiry, earliestExpiry))
+		}
+	}
+
+	return &GS1ScanResult{
+		Elements:   elements,
+		ResolvedID: key,
+		Trace:      trace,
+		Mismatches: mismatches,
+	}, nil
+}
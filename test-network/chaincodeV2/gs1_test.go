@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeGTIN(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		valid bool
+	}{
+		{"00890000000014", "00890000000014", true},
+		{"0890000000014", "00890000000014", true},
+		{"890000000014", "00890000000014", true},
+		{"96385074", "00000096385074", true},
+		{"00890000000015", "", false},
+		{"0089000000001", "", false},
+		{"0089000000001A", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeGTIN(tt.input)
+		if tt.valid != (err == nil) || got != tt.want {
+			t.Errorf("normalizeGTIN(%q) = %q, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestParseGS1ElementString(t *testing.T) {
+	item := map[string]string{AIGTIN: testGTIN, AISerial: "S1", AILot: "B1", AIExpiry: "270101"}
+
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+		err   string
+	}{
+		{"bracketed", "(01)00890000000014(21)S1(10)B1(17)270101", item, ""},
+		{"bracketed with spaces", "  (01)00890000000014(21)S1(10)B1(17)270101\n", item, ""},
+		{"raw with symbology identifier", "]d20100890000000014" + "21S1\x1d10B1\x1d17270101", item, ""},
+		{"raw with fixed fields first", "0100890000000014" + "17270101" + "10B1\x1d21S1", item, ""},
+		{"raw with leading FNC1", "\x1d0100890000000014" + "21S1\x1d10B1\x1d17270101", item, ""},
+		{"SSCC", "(00)106141411234567897", map[string]string{AISSCC: "106141411234567897"}, ""},
+		{"four digit AI", "(21)S1(710)NHRN1", map[string]string{AISerial: "S1", "710": "NHRN1"}, ""},
+		{"empty", " ", nil, "empty GS1 element string"},
+		{"bad GTIN check digit", "(01)00890000000015(21)S1", nil, "invalid check digit"},
+		{"bad SSCC check digit", "(00)106141411234567890", nil, "invalid SSCC"},
+		{"unknown AI", "99abc", nil, "unknown application identifier"},
+		{"repeated AI", "(10)B1(10)B2", nil, "appears more than once"},
+		{"missing value", "(21)(10)B1", nil, "has no value"},
+		{"unterminated AI", "(01", nil, "unterminated application identifier"},
+		{"value too long", "(21)" + strings.Repeat("9", 21), nil, "at most 20 characters"},
+		{"fixed field too short", "01008900000000", nil, "needs 14 characters"},
+		{"invalid expiry", "(17)271301", nil, "invalid GS1 date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGS1ElementString(tt.input)
+			if tt.err != "" {
+				expectError(t, err, tt.err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGS1DateToISO(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		valid bool
+	}{
+		{"270115", "2027-01-15", true},
+		{"270100", "2027-01-31", true},
+		{"240200", "2024-02-29", true},
+		{"250200", "2025-02-28", true},
+		{"271301", "", false},
+		{"270132", "", false},
+		{"2701", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := gs1DateToISO(tt.input)
+		if tt.valid != (err == nil) || got != tt.want {
+			t.Errorf("gs1DateToISO(%q) = %q, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestScanGS1(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+
+	tests := []struct {
+		name       string
+		input      string
+		resolvedID string
+		mismatches int
+	}{
+		{"strip label", "(01)00890000000014(21)P1-S000(10)B1(17)270101", "P1-S000", 0},
+		{"strip scan", "]d20100890000000014" + "21P1-S000\x1d10B1\x1d17270100", "P1-S000", 0},
+		{"box with wrong lot and expiry", "(21)P1-B00(10)B2(17)270102", "P1-B00", 2},
+		{"strip with another GTIN", "(01)00890000000021(21)P1-S000", "P1-S000", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result *GS1ScanResult
+			mustSubmit(t, l, org2Pharm, func(ctx *mockCtx) error {
+				var err error
+				result, err = contract.ScanGS1(ctx, tt.input)
+				return err
+			})
+			if result.ResolvedID != tt.resolvedID || len(result.Mismatches) != tt.mismatches {
+				t.Fatalf("resolved %s with mismatches %v", result.ResolvedID, result.Mismatches)
+			}
+		})
+	}
+
+	err := submit(t, l, org2Pharm, func(ctx *mockCtx) error {
+		_, err := contract.ScanGS1(ctx, "(01)00890000000014(21)UNKNOWN")
+		return err
+	})
+	if err == nil {
+		t.Fatal("resolved a serial number that is not on the ledger")
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// containment says that items of a child doc type can be packed in containers of a parent doc type.
+// The container lists them in field and each child points back at the container in pointer.
+type containment struct {
+	parent  string
+	child   string
+	field   string
+	pointer string
+}
+
+// containments is the packaging hierarchy. Pallets sit between cartons and shipments, or hold whole
+// shipments for air freight; orders hold shipments or pallets. The field names match the JSON of the
+// typed documents in pharma.go.
+var containments = []containment{
+	{DocTypeBox, DocTypeStrip, "strips", "boxId"},
+	{DocTypeCarton, DocTypeBox, "boxes", "cartonId"},
+	{DocTypePallet, DocTypeCarton, "cartons", "palletId"},
+	{DocTypePallet, DocTypeShipment, "shipments", "palletId"},
+	{DocTypeShipment, DocTypeCarton, "cartons", "shipmentId"},
+	{DocTypeShipment, DocTypePallet, "pallets", "shipmentId"},
+	{DocTypeOrder, DocTypeShipment, "itemIds", "orderId"},
+	{DocTypeOrder, DocTypePallet, "itemIds", "orderId"},
+}
+
+// containerLevel configures how containers of one packaging level are sealed
+type containerLevel struct {
+	docType      string
+	logisticUnit bool // Identified by an SSCC, generated when no ID is given
+	singleKind   bool // Holds children of a single doc type, e.g. cartons or shipments but not both
+}
+
+// containerLevels lists the packaging levels, innermost first. What each level holds is configured in
+// containments and its status transitions in statusTransitions.
+var containerLevels = []containerLevel{
+	{docType: DocTypeBox},
+	{docType: DocTypeCarton, logisticUnit: true},
+	{docType: DocTypePallet, logisticUnit: true, singleKind: true},
+	{docType: DocTypeShipment, logisticUnit: true},
+}
+
+// findLevel returns the configuration of a packaging level
+func findLevel(docType string) (containerLevel, bool) {
+	for _, level := range containerLevels {
+		if level.docType == docType {
+			return level, true
+		}
+	}
+	return containerLevel{}, false
+}
+
+// itemDocTypes returns every doc type that takes part in the supply chain: strips, each packaging level
+// and orders
+func itemDocTypes() []string {
+	docTypes := []string{DocTypeStrip}
+	for _, level := range containerLevels {
+		docTypes = append(docTypes, level.docType)
+	}
+	return append(docTypes, DocTypeOrder)
+}
+
+// findContainment returns how a child doc type is packed in a parent doc type
+func findContainment(parentDocType string, childDocType string) (containment, bool) {
+	for _, ct := range containments {
+		if ct.parent == parentDocType && ct.child == childDocType {
+			return ct, true
+		}
+	}
+	return containment{}, false
+}
+
+// childListFields returns the distinct fields in which a doc type lists its children
+func childListFields(docType string) []string {
+	var fields []string
+	for _, ct := range containments {
+		if ct.parent == docType {
+			fields = appendUnique(fields, ct.field)
+		}
+	}
+	return fields
+}
+
+// isContainer reports whether items of a doc type hold other items
+func isContainer(docType string) bool {
+	return len(childListFields(docType)) > 0
+}
+
+// isPackagingLevel reports whether a doc type is a physical container (box, carton, pallet, shipment),
+// as opposed to an order
+func isPackagingLevel(docType string) bool {
+	_, ok := findLevel(docType)
+	return ok
+}
+
+// docString reads a string field of a generic document
+func docString(doc map[string]interface{}, field string) string {
+	value, _ := doc[field].(string)
+	return value
+}
+
+// docStrings reads a list of strings from a generic document
+func docStrings(doc map[string]interface{}, field string) []string {
+	switch list := doc[field].(type) {
+	case []string:
+		return list
+	case []interface{}:
+		var values []string
+		for _, v := range list {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// docParent returns the doc type and ID of the container a generic document is packed in, if any
+func docParent(doc map[string]interface{}) (string, string) {
+	docType := docString(doc, "docType")
+	for _, ct := range containments {
+		if ct.child != docType {
+			continue
+		}
+		if id := docString(doc, ct.pointer); id != "" {
+			return ct.parent, id
+		}
+	}
+	return "", ""
+}
+
+// docChildIDs returns the IDs of every item directly packed in a generic document
+func docChildIDs(doc map[string]interface{}) []string {
+	var ids []string
+	for _, field := range childListFields(docString(doc, "docType")) {
+		ids = append(ids, docStrings(doc, field)...)
+	}
+	return ids
+}
+
+// docLists returns the child lists of a generic document, keyed by field
+func docLists(doc map[string]interface{}) map[string][]string {
+	lists := map[string][]string{}
+	for _, field := range childListFields(docString(doc, "docType")) {
+		lists[field] = docStrings(doc, field)
+	}
+	return lists
+}
+
+// readDoc reads an item as a generic document
+func (c *PharmaContract) readDoc(ctx contractapi.TransactionContextInterface, id string) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	err := c.readAsset(ctx, id, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// getAncestors returns the containers an item is packed in, innermost first, ending with its order if any
+func (c *PharmaContract) getAncestors(ctx contractapi.TransactionContextInterface, doc map[string]interface{}) ([]map[string]interface{}, error) {
+	ancestors := []map[string]interface{}{}
+	seen := map[string]bool{docString(doc, "id"): true}
+	for {
+		_, parentID := docParent(doc)
+		if parentID == "" {
+			return ancestors, nil
+		}
+		if seen[parentID] {
+			return nil, fmt.Errorf("packaging loop at %s", parentID)
+		}
+		seen[parentID] = true
+
+		parent, err := c.readDoc(ctx, parentID)
+		if err != nil {
+			return nil, err
+		}
+		ancestors = append(ancestors, parent)
+		doc = parent
+	}
+}
+
+// packChildren packs existing items into a new container of parentDocType: each child must be a doc type
+// the container can hold, must not be packed elsewhere, must be in the custody of mspID, must not hold
+// recalled stock and must pass its shelf-life check. Children are marked SEALED and pointed at the
+// container. It returns the container's child lists, keyed by field, and the summary of its contents
+// after enforcing the packing rules.
+func (c *PharmaContract) packChildren(ctx contractapi.TransactionContextInterface, parentDocType string, parentID string, childIDs []string, mspID string) (map[string][]string, *ContentSummary, error) {
+	err := checkChildIDs(parentDocType, childIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := c.getTxTimestamp(ctx)
+	lists := map[string][]string{}
+	for _, field := range childListFields(parentDocType) {
+		lists[field] = []string{}
+	}
+	for _, childID := range childIDs {
+		child, err := c.readDoc(ctx, childID)
+		if err != nil {
+			return nil, nil, err
+		}
+		childDocType := docString(child, "docType")
+
+		ct, ok := findContainment(parentDocType, childDocType)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s %s cannot be packed in a %s", childDocType, childID, parentDocType)
+		}
+		// An item may not go into the kind of container it holds itself, e.g. a pallet of shipments into a shipment
+		if reverse, ok := findContainment(childDocType, parentDocType); ok && len(docStrings(child, reverse.field)) > 0 {
+			return nil, nil, fmt.Errorf("%s %s holds %ss and cannot be packed in a %s", childDocType, childID, parentDocType, parentDocType)
+		}
+		if otherType, otherID := docParent(child); otherID != "" {
+			return nil, nil, fmt.Errorf("%s %s is already in %s %s", childDocType, childID, otherType, otherID)
+		}
+		err = checkCustody(child, mspID)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = checkNotRecalled(child)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = checkTransition(childDocType, childID, docString(child, "status"), StatusSealed)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = c.checkContentsShelfLife(ctx, childID, now)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		child[ct.pointer] = parentID
+		child["status"] = StatusSealed
+		child["updatedAt"] = now
+		err = c.writeAsset(ctx, childID, child)
+		if err != nil {
+			return nil, nil, err
+		}
+		lists[ct.field] = append(lists[ct.field], childID)
+	}
+
+	summary, err := c.summarizeChildren(ctx, childIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = c.checkPackingRules(ctx, parentDocType, parentID, len(childIDs), summary)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return lists, summary, nil
+}
+
+// checkContentKinds refuses a single-kind container, such as a pallet, holding children of several doc types
+func checkContentKinds(docType string, containerID string, lists map[string][]string) error {
+	level, ok := findLevel(docType)
+	if !ok || !level.singleKind {
+		return nil
+	}
+
+	var kinds []string
+	for _, field := range childListFields(docType) {
+		if len(lists[field]) > 0 {
+			kinds = append(kinds, field)
+		}
+	}
+	if len(kinds) > 1 {
+		return fmt.Errorf("%s %s must hold a single kind of item, not %s", docType, containerID, strings.Join(kinds, " and "))
+	}
+	return nil
+}
+
+// sealContainer creates a container of a packaging level holding the items listed in childIDsJSON and
+// stores it as v, a pointer to the level's typed document. Logistic units get their ID checked or
+// generated as an SSCC.
+func (c *PharmaContract) sealContainer(ctx contractapi.TransactionContextInterface, docType string, containerID string, childIDsJSON string, v interface{}) error {
+	level, ok := findLevel(docType)
+	if !ok {
+		return fmt.Errorf("%s is not a packaging level", docType)
+	}
+
+	var err error
+	if level.logisticUnit {
+		// Validate a supplied SSCC, or generate one when no ID is given
+		containerID, err = c.resolveLogisticUnitID(ctx, containerID)
+		if err != nil {
+			return err
+		}
+	}
+	err = checkItemID(docType, containerID)
+	if err != nil {
+		return err
+	}
+
+	exists, err := c.assetExists(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%s %s already exists", docType, containerID)
+	}
+
+	var childIDs []string
+	err = json.Unmarshal([]byte(childIDsJSON), &childIDs)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s contents: %v", docType, err)
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	// Validate and update each child
+	lists, summary, err := c.packChildren(ctx, docType, containerID, childIDs, mspID)
+	if err != nil {
+		return err
+	}
+	err = checkContentKinds(docType, containerID, lists)
+	if err != nil {
+		return err
+	}
+
+	now := c.getTxTimestamp(ctx)
+	doc := map[string]interface{}{
+		"docType":          docType,
+		"id":               containerID,
+		"status":           StatusCreated,
+		"currentOwner":     mspID,
+		"currentCustodian": mspID,
+		"summary":          summary,
+		"creationTxId":     ctx.GetStub().GetTxID(), // The creation transaction ID never changes
+		"createdAt":        now,
+		"updatedAt":        now,
+	}
+	for field, ids := range lists {
+		doc[field] = ids
+	}
+
+	// Store the typed document so the JSON keeps the level's usual shape
+	err = remarshal(doc, v)
+	if err != nil {
+		return err
+	}
+	err = c.writeAsset(ctx, containerID, v)
+	if err != nil {
+		return err
+	}
+
+	return c.emitEvent(ctx, ItemEvent{
+		Name:      EventContainerSealed,
+		DocType:   docType,
+		ItemIDs:   []string{containerID},
+		NewStatus: StatusCreated,
+		ChildIDs:  childIDs,
+	})
+}
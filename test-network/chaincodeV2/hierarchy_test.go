@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestContainmentConfig(t *testing.T) {
+	// Every containment links a packaging level or order to a doc type that takes part in the supply chain
+	for _, ct := range containments {
+		if !isPackagingLevel(ct.parent) && ct.parent != DocTypeOrder {
+			t.Errorf("%s holds %ss but is neither a packaging level nor an order", ct.parent, ct.child)
+		}
+		if !containsString(itemDocTypes(), ct.child) {
+			t.Errorf("%s is packed in %ss but is not an item doc type", ct.child, ct.parent)
+		}
+	}
+
+	tests := []struct {
+		parent  string
+		child   string
+		field   string
+		pointer string
+	}{
+		{DocTypeBox, DocTypeStrip, "strips", "boxId"},
+		{DocTypePallet, DocTypeShipment, "shipments", "palletId"},
+		{DocTypeShipment, DocTypePallet, "pallets", "shipmentId"},
+		{DocTypeOrder, DocTypePallet, "itemIds", "orderId"},
+		{DocTypeBox, DocTypeCarton, "", ""},
+		{DocTypeStrip, DocTypeBox, "", ""},
+	}
+	for _, tt := range tests {
+		ct, ok := findContainment(tt.parent, tt.child)
+		if ok != (tt.field != "") || ct.field != tt.field || ct.pointer != tt.pointer {
+			t.Errorf("%s in %s: %+v, %v", tt.child, tt.parent, ct, ok)
+		}
+	}
+
+	if fields := childListFields(DocTypePallet); !reflect.DeepEqual(fields, []string{"cartons", "shipments"}) {
+		t.Errorf("pallet child fields %v", fields)
+	}
+	if fields := childListFields(DocTypeOrder); !reflect.DeepEqual(fields, []string{"itemIds"}) {
+		t.Errorf("order child fields %v", fields)
+	}
+	if isContainer(DocTypeStrip) || !isContainer(DocTypeOrder) || isPackagingLevel(DocTypeOrder) {
+		t.Error("strips must not be containers, orders must be containers but not packaging levels")
+	}
+	want := []string{DocTypeStrip, DocTypeBox, DocTypeCarton, DocTypePallet, DocTypeShipment, DocTypeOrder}
+	if docTypes := itemDocTypes(); !reflect.DeepEqual(docTypes, want) {
+		t.Errorf("item doc types %v", docTypes)
+	}
+}
+
+func TestGenericDocsReadTypedJSON(t *testing.T) {
+	// Documents written through the typed structs read the same through the generic helpers
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	typed := []struct {
+		v          interface{}
+		parentType string
+		parentID   string
+		childIDs   []string
+	}{
+		{Strip{DocType: DocTypeStrip, ID: "S1", BoxID: "B1", UpdatedAt: now}, DocTypeBox, "B1", nil},
+		{Box{DocType: DocTypeBox, ID: "B1", Strips: []string{"S1", "S2"}, CartonID: "C1"}, DocTypeCarton, "C1", []string{"S1", "S2"}},
+		{Carton{DocType: DocTypeCarton, ID: "C1", Boxes: []string{"B1"}, PalletID: "PL1"}, DocTypePallet, "PL1", []string{"B1"}},
+		{Shipment{DocType: DocTypeShipment, ID: "SH1", Cartons: []string{"C2"}, Pallets: []string{"PL1"}, OrderID: "O1"}, DocTypeOrder, "O1", []string{"C2", "PL1"}},
+	}
+	for _, tt := range typed {
+		docJSON, err := json.Marshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(docJSON, &doc); err != nil {
+			t.Fatal(err)
+		}
+		parentType, parentID := docParent(doc)
+		if parentType != tt.parentType || parentID != tt.parentID || !reflect.DeepEqual(docChildIDs(doc), tt.childIDs) {
+			t.Errorf("%s: parent %s %s, children %v", docString(doc, "id"), parentType, parentID, docChildIDs(doc))
+		}
+	}
+}
+
+func TestPackagingLoop(t *testing.T) {
+	l := newLedger()
+	buildCarton(t, l, "C1", "B1")
+	putDoc(t, l, "SH1", map[string]interface{}{"docType": DocTypeShipment, "id": "SH1", "pallets": []string{"PL1"}, "palletId": "PL1"})
+	putDoc(t, l, "PL1", map[string]interface{}{"docType": DocTypePallet, "id": "PL1", "shipments": []string{"SH1"}, "shipmentId": "SH1"})
+	carton := getDoc(t, l, "C1")
+	carton["shipmentId"] = "SH1"
+	putDoc(t, l, "C1", carton)
+
+	// A corrupted hierarchy is reported instead of walked forever
+	expectError(t, submit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.ScanBarcode(ctx, "C1-S")
+		return err
+	}), "packaging loop at SH1")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite-key indexes, maintained by writeAsset so lookups are range reads on LevelDB as well as CouchDB.
+// The last attribute of every index key is the ID of the indexed document.
+const (
+	IndexCreationTx    = "txid~id"           // creationTxId, id
+	IndexDocTypeStatus = "docType~status~id" // docType, status, id
+	IndexBatchStrip    = "batch~strip"       // batchNumber, strip id
+	IndexProductStrip  = "gtin~strip"        // product GTIN, strip id
+	IndexParentChild   = "parent~child"      // container id, id of an item packed in it
+	IndexRecipient     = "recipient~order"   // order recipient, order id
+	IndexCompanyPrefix = "prefix~msp"        // GS1 company prefix, SSCC config id
+)
+
+// indexValue is stored under every index key; the information is in the key itself
+var indexValue = []byte{0x00}
+
+// indexEntry is one composite key pointing at a document
+type indexEntry struct {
+	objectType string
+	attributes []string
+}
+
+// indexEntries returns the index keys a stored document is reachable through
+func indexEntries(id string, docJSON []byte) []indexEntry {
+	if docJSON == nil {
+		return nil
+	}
+	var doc map[string]interface{}
+	if json.Unmarshal(docJSON, &doc) != nil {
+		return nil
+	}
+	docType := docString(doc, "docType")
+
+	if docType == DocTypeSSCCConfig {
+		// An organization keeps the prefixes it used before, so they stay reserved to it
+		entries := []indexEntry{{IndexCompanyPrefix, []string{docString(doc, "companyPrefix"), id}}}
+		previous, _ := doc["previousPrefixes"].(map[string]interface{})
+		for prefix := range previous {
+			entries = append(entries, indexEntry{IndexCompanyPrefix, []string{prefix, id}})
+		}
+		return entries
+	}
+	if !containsString(queryableDocTypes(), docType) {
+		return nil
+	}
+
+	status := docString(doc, "status")
+	if status == "" {
+		status = docString(doc, "qcStatus")
+	}
+	entries := []indexEntry{{IndexDocTypeStatus, []string{docType, status, id}}}
+	if txID := docString(doc, "creationTxId"); txID != "" {
+		entries = append(entries, indexEntry{IndexCreationTx, []string{txID, id}})
+	}
+	if docType == DocTypeStrip && docString(doc, "batchNumber") != "" {
+		entries = append(entries, indexEntry{IndexBatchStrip, []string{docString(doc, "batchNumber"), id}})
+	}
+	if docType == DocTypeStrip && docString(doc, "gtin") != "" {
+		entries = append(entries, indexEntry{IndexProductStrip, []string{docString(doc, "gtin"), id}})
+	}
+	if docType == DocTypeOrder && docString(doc, "recipient") != "" {
+		entries = append(entries, indexEntry{IndexRecipient, []string{docString(doc, "recipient"), id}})
+	}
+	if _, parentID := docParent(doc); parentID != "" {
+		entries = append(entries, indexEntry{IndexParentChild, []string{parentID, id}})
+	}
+	return entries
+}
+
+// updateIndexes moves a document's index keys from those of oldJSON to those of newJSON
+func (c *PharmaContract) updateIndexes(ctx contractapi.TransactionContextInterface, id string, oldJSON []byte, newJSON []byte) error {
+	keyOf := func(entry indexEntry) (string, error) {
+		key, err := ctx.GetStub().CreateCompositeKey(entry.objectType, entry.attributes)
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s index key: %v", entry.objectType, err)
+		}
+		return key, nil
+	}
+
+	newKeys := map[string]bool{}
+	for _, entry := range indexEntries(id, newJSON) {
+		key, err := keyOf(entry)
+		if err != nil {
+			return err
+		}
+		newKeys[key] = true
+	}
+	for _, entry := range indexEntries(id, oldJSON) {
+		key, err := keyOf(entry)
+		if err != nil {
+			return err
+		}
+		if newKeys[key] {
+			delete(newKeys, key)
+			continue
+		}
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("failed to update index: %v", err)
+		}
+	}
+
+	// Write the new keys in a fixed order so every peer produces the same write set
+	keys := make([]string, 0, len(newKeys))
+	for key := range newKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := ctx.GetStub().PutState(key, indexValue)
+		if err != nil {
+			return fmt.Errorf("failed to update index: %v", err)
+		}
+	}
+	return nil
+}
+
+// getIndexedIDs returns the document IDs under a partial index key, in key order
+func (c *PharmaContract) getIndexedIDs(ctx contractapi.TransactionContextInterface, objectType string, attributes ...string) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s index: %v", objectType, err)
+		}
+		_, parts, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+		if err != nil || len(parts) == 0 {
+			return nil, fmt.Errorf("malformed %s index key %q", objectType, kv.Key)
+		}
+		ids = append(ids, parts[len(parts)-1])
+	}
+	return ids, nil
+}
+
+// getIndexedDocs reads the documents under a partial index key
+func (c *PharmaContract) getIndexedDocs(ctx contractapi.TransactionContextInterface, objectType string, attributes ...string) ([][]byte, error) {
+	ids, err := c.getIndexedIDs(ctx, objectType, attributes...)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		docJSON, err := ctx.GetStub().GetState(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get item %s: %v", id, err)
+		}
+		if docJSON != nil {
+			docs = append(docs, docJSON)
+		}
+	}
+	return docs, nil
+}
+
+// getUnpackedDocs reads the items of a doc type that are CREATED and not packed in anything
+func (c *PharmaContract) getUnpackedDocs(ctx contractapi.TransactionContextInterface, docType string) ([][]byte, error) {
+	docs, err := c.getIndexedDocs(ctx, IndexDocTypeStatus, docType, StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	var unpacked [][]byte
+	for _, docJSON := range docs {
+		var doc map[string]interface{}
+		err = json.Unmarshal(docJSON, &doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, parentID := docParent(doc); parentID == "" {
+			unpacked = append(unpacked, docJSON)
+		}
+	}
+	return unpacked, nil
+}
+
+// scanAllDocs calls fn with every document in the world state. Composite keys are not returned by
+// range reads, so only documents are visited.
+func (c *PharmaContract) scanAllDocs(ctx contractapi.TransactionContextInterface, fn func(id string, docJSON []byte) error) error {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return fmt.Errorf("failed to read world state: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read world state: %v", err)
+		}
+		err = fn(kv.Key, kv.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildIndexes writes the index keys of every document. Index keys are only written when a document
+// changes, so ledgers holding items from before the indexes existed need this run once; it is safe to
+// run again. It returns the number of documents indexed.
+func (c *PharmaContract) RebuildIndexes(ctx contractapi.TransactionContextInterface) (int, error) {
+	if err := c.checkAccess(ctx, "RebuildIndexes"); err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	err := c.scanAllDocs(ctx, func(id string, docJSON []byte) error {
+		entries := indexEntries(id, docJSON)
+		for _, entry := range entries {
+			key, err := ctx.GetStub().CreateCompositeKey(entry.objectType, entry.attributes)
+			if err != nil {
+				return fmt.Errorf("failed to create %s index key: %v", entry.objectType, err)
+			}
+			err = ctx.GetStub().PutState(key, indexValue)
+			if err != nil {
+				return fmt.Errorf("failed to update index: %v", err)
+			}
+		}
+		if len(entries) > 0 {
+			indexed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventIndexesRebuilt, Details: map[string]int{"indexed": indexed}})
+	if err != nil {
+		return 0, err
+	}
+	return indexed, nil
+}
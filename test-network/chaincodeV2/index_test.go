@@ -0,0 +1,191 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// indexedIDs returns the IDs under a partial index key in the committed state
+func indexedIDs(l *mockLedger, objectType string, attributes ...string) []string {
+	prefix := "\x00" + objectType + "\x00"
+	for _, attribute := range attributes {
+		prefix += attribute + "\x00"
+	}
+	var ids []string
+	for key := range l.state {
+		if strings.HasPrefix(key, prefix) {
+			parts := strings.Split(strings.TrimSuffix(key, "\x00"), "\x00")
+			ids = append(ids, parts[len(parts)-1])
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// indexSnapshot lists every index key in the committed state
+func indexSnapshot(l *mockLedger) string {
+	var keys []string
+	for key := range l.state {
+		if strings.HasPrefix(key, "\x00") && !strings.HasPrefix(key, "\x00"+statObjectType+"\x00") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "|")
+}
+
+func TestIndexMaintenance(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildShipment(t, l, "P2", "B1")
+	buildCarton(t, l, "C9", "B1")
+
+	statusIDs := func(docType string, status string) string {
+		return strings.Join(indexedIDs(l, IndexDocTypeStatus, docType, status), ",")
+	}
+	if got := statusIDs(DocTypeShipment, StatusCreated); got != "P1-SH,P2-SH" {
+		t.Fatalf("CREATED shipments %s", got)
+	}
+	if got := statusIDs(DocTypeStrip, StatusCreated); got != "" {
+		t.Fatalf("CREATED strips %s after sealing", got)
+	}
+
+	// Ordering, dispatching and delivering move the status keys of the whole hierarchy
+	createOrder(t, l, "O1", "P1-SH")
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.CreateOrder(ctx, "O2", idList("P2-SH"), "pharm2", "Org2MSP")
+		return err
+	})
+	if got := statusIDs(DocTypeShipment, StatusInOrder); got != "P1-SH,P2-SH" {
+		t.Fatalf("IN_ORDER shipments %s", got)
+	}
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	})
+	mustSubmit(t, l, org2Pharm, func(ctx *mockCtx) error {
+		_, err := contract.DeliverOrder(ctx, "O1")
+		return err
+	})
+	checks := []struct {
+		docType string
+		status  string
+		want    int
+	}{
+		{DocTypeShipment, StatusInOrder, 1},
+		{DocTypeShipment, StatusDelivered, 1},
+		{DocTypeStrip, StatusSealed, 9},
+		{DocTypeStrip, StatusDispatched, 0},
+		{DocTypeStrip, StatusDelivered, 8},
+		{DocTypeOrder, StatusCreated, 1},
+		{DocTypeOrder, StatusDelivered, 1},
+	}
+	for _, check := range checks {
+		if got := indexedIDs(l, IndexDocTypeStatus, check.docType, check.status); len(got) != check.want {
+			t.Errorf("%s %s: indexed %v, want %d", check.docType, check.status, got, check.want)
+		}
+	}
+
+	// Every item has exactly one status key
+	for _, id := range []string{"O1", "P1-SH", "P1-C0", "P1-B00", "P1-S000", "C9-S"} {
+		doc := getDoc(t, l, id)
+		keys := 0
+		for _, key := range strings.Split(indexSnapshot(l), "|") {
+			if strings.HasPrefix(key, "\x00"+IndexDocTypeStatus+"\x00") && strings.HasSuffix(key, "\x00"+id+"\x00") {
+				keys++
+			}
+		}
+		if keys != 1 {
+			t.Errorf("%s is %v with %d status keys", id, doc["status"], keys)
+		}
+	}
+
+	// Orders are found through the recipient index
+	var orders []*Order
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		orders, err = contract.GetOrdersByRecipient(ctx, "pharm1 (Org2MSP)")
+		return err
+	})
+	if len(orders) != 1 || orders[0].ID != "O1" || orders[0].Status != StatusDelivered {
+		t.Fatalf("orders of pharm1: %+v", orders)
+	}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		orders, err = contract.GetOrdersByRecipient(ctx, `pharm1 (Org2MSP)","docType":"order`)
+		return err
+	})
+	if len(orders) != 0 {
+		t.Fatalf("a crafted recipient found %+v", orders)
+	}
+
+	// Taking a strip out of its box drops its parent~child key
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.MoveChild(ctx, "C9-S", "")
+		return err
+	})
+	if got := indexedIDs(l, IndexParentChild, "C9-B"); len(got) != 0 {
+		t.Errorf("C9-B still indexes children %v", got)
+	}
+	if got := statusIDs(DocTypeStrip, StatusCreated); got != "C9-S" {
+		t.Errorf("CREATED strips %s after unpacking", got)
+	}
+
+	// The maintained keys are exactly those a rebuild writes
+	before := indexSnapshot(l)
+	mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+		_, err := contract.RebuildIndexes(ctx)
+		return err
+	})
+	if indexSnapshot(l) != before {
+		t.Error("rebuilding changed the indexes")
+	}
+}
+
+func TestWriteAssetTwiceInOneTransaction(t *testing.T) {
+	l := newLedger()
+	strip := func(status string) map[string]interface{} {
+		return map[string]interface{}{"docType": DocTypeStrip, "id": "X1", "status": status, "batchNumber": "B9", "gtin": testGTIN, "currentOwner": "Org1MSP"}
+	}
+	writeTwice := func(first string, second string) {
+		t.Helper()
+		mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			if err := contract.writeAsset(ctx, "X1", strip(first)); err != nil {
+				return err
+			}
+			return contract.writeAsset(ctx, "X1", strip(second))
+		})
+	}
+
+	// The indexes and counters follow the last write, not the committed document
+	writeTwice(StatusCreated, StatusSealed)
+	if got := indexedIDs(l, IndexDocTypeStatus, DocTypeStrip); len(got) != 1 || indexedIDs(l, IndexDocTypeStatus, DocTypeStrip, StatusSealed) == nil {
+		t.Fatalf("strip status index holds %v", got)
+	}
+	var stats *ItemStatistics
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		stats, err = contract.GetItemStatistics(ctx, DocTypeStrip)
+		return err
+	})
+	if stats.Total != 1 || len(stats.ByStatus) != 1 || stats.ByStatus[StatusSealed] != 1 {
+		t.Fatalf("strip statistics %+v", stats)
+	}
+
+	// Changes undone within the transaction leave no deltas behind
+	rows := statRows(l, DocTypeStrip)
+	writeTwice(StatusDispatched, StatusSealed)
+	if got := statRows(l, DocTypeStrip); got != rows {
+		t.Errorf("%d strip statistics rows became %d", rows, got)
+	}
+	before := indexSnapshot(l)
+	mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+		_, err := contract.RebuildIndexes(ctx)
+		return err
+	})
+	if indexSnapshot(l) != before {
+		t.Error("rebuilding changed the indexes")
+	}
+	checkStatistics(t, l, "rewrite")
+}
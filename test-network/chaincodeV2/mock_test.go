@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// mockLedger is an in-memory world state with key history. Each transaction runs against the committed
+// state through its own mockStub and its writes are committed only if it succeeds; like Fabric, a
+// transaction does not see its own writes. Rich queries are refused, as on LevelDB.
+type mockLedger struct {
+	state   map[string][]byte
+	history map[string][]mockModification
+	events  []mockEvent
+	silent  []string // committed transactions that wrote state without setting an event
+	txCount int
+	clock   time.Time
+}
+
+// mockModification is one committed write or delete of a key
+type mockModification struct {
+	txID      string
+	timestamp time.Time
+	value     []byte
+	isDelete  bool
+}
+
+// mockEvent is a chaincode event set by a committed transaction
+type mockEvent struct {
+	Name    string
+	Payload []byte
+}
+
+func newLedger() *mockLedger {
+	return &mockLedger{
+		state:   map[string][]byte{},
+		history: map[string][]mockModification{},
+		clock:   time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+// run executes fn as one transaction submitted by id, a minute after the previous one, and commits its
+// writes and event if fn succeeds. It returns the transaction ID.
+func (l *mockLedger) run(id *mockIdentity, fn func(ctx *mockCtx) error) (string, error) {
+	l.txCount++
+	l.clock = l.clock.Add(time.Minute)
+	stub := &mockStub{
+		l:         l,
+		txID:      fmt.Sprintf("tx%04d", l.txCount),
+		timestamp: l.clock,
+		writes:    map[string][]byte{},
+		deletes:   map[string]bool{},
+	}
+	err := fn(&mockCtx{stub: stub, id: id})
+	if err != nil {
+		return stub.txID, err
+	}
+
+	for key, value := range stub.writes {
+		l.state[key] = value
+		l.history[key] = append(l.history[key], mockModification{stub.txID, stub.timestamp, value, false})
+	}
+	for key := range stub.deletes {
+		delete(l.state, key)
+		l.history[key] = append(l.history[key], mockModification{stub.txID, stub.timestamp, nil, true})
+	}
+	if stub.event != nil {
+		l.events = append(l.events, *stub.event)
+	} else if len(stub.writes) > 0 || len(stub.deletes) > 0 {
+		l.silent = append(l.silent, stub.txID)
+	}
+	return stub.txID, nil
+}
+
+// lastEvent returns the event of the latest transaction that set one
+func (l *mockLedger) lastEvent(t *testing.T) (string, ItemEvent) {
+	t.Helper()
+	if len(l.events) == 0 {
+		t.Fatal("no event was emitted")
+	}
+	last := l.events[len(l.events)-1]
+	var event ItemEvent
+	err := json.Unmarshal(last.Payload, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return last.Name, event
+}
+
+// mockIdentity is the X.509 identity of a submitting client
+type mockIdentity struct {
+	msp   string
+	cn    string
+	ou    string
+	attrs map[string]string
+}
+
+func (m *mockIdentity) GetID() (string, error)    { return "x509::CN=" + m.cn, nil }
+func (m *mockIdentity) GetMSPID() (string, error) { return m.msp, nil }
+
+func (m *mockIdentity) GetAttributeValue(name string) (string, bool, error) {
+	value, found := m.attrs[name]
+	return value, found, nil
+}
+
+func (m *mockIdentity) AssertAttributeValue(name string, value string) error {
+	if m.attrs[name] != value {
+		return fmt.Errorf("attribute %s is not %s", name, value)
+	}
+	return nil
+}
+
+func (m *mockIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return &x509.Certificate{Subject: pkix.Name{CommonName: m.cn, OrganizationalUnit: []string{m.ou}}}, nil
+}
+
+// The identities used by the tests; roles are enrollment attributes and OUs node OUs as in the network's CA
+var (
+	org1Mfr   = &mockIdentity{msp: "Org1MSP", cn: "mfr1", ou: "client", attrs: map[string]string{"role": RoleManufacturer}}
+	org1Dist  = &mockIdentity{msp: "Org1MSP", cn: "dist1", ou: "client", attrs: map[string]string{"role": RoleDistributor}}
+	org2Dist  = &mockIdentity{msp: "Org2MSP", cn: "dist2", ou: "client", attrs: map[string]string{"role": RoleDistributor}}
+	org2Pharm = &mockIdentity{msp: "Org2MSP", cn: "pharm1", ou: "client", attrs: map[string]string{"role": RolePharmacy}}
+	regulator = &mockIdentity{msp: "Org2MSP", cn: "reg", ou: "client", attrs: map[string]string{"role": RoleRegulator}}
+	org1User  = &mockIdentity{msp: "Org1MSP", cn: "user1", ou: "client", attrs: map[string]string{}}
+	org1Admin = &mockIdentity{msp: "Org1MSP", cn: "org1admin", ou: OUAdmin, attrs: map[string]string{}}
+)
+
+// mockCtx is the transaction context handed to contract functions. Like the contract's own context, it
+// remembers the documents the transaction wrote.
+type mockCtx struct {
+	PharmaTransactionContext
+	stub *mockStub
+	id   *mockIdentity
+}
+
+func (c *mockCtx) GetStub() shim.ChaincodeStubInterface  { return c.stub }
+func (c *mockCtx) GetClientIdentity() cid.ClientIdentity { return c.id }
+
+// mockStub implements the stub functions the contract uses. Calling any other function panics on the nil
+// embedded interface.
+type mockStub struct {
+	shim.ChaincodeStubInterface
+	l         *mockLedger
+	txID      string
+	timestamp time.Time
+	writes    map[string][]byte
+	deletes   map[string]bool
+	event     *mockEvent
+}
+
+func (s *mockStub) GetTxID() string      { return s.txID }
+func (s *mockStub) GetChannelID() string { return "mychannel" }
+
+func (s *mockStub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.timestamp), nil
+}
+
+func (s *mockStub) GetState(key string) ([]byte, error) {
+	return s.l.state[key], nil
+}
+
+func (s *mockStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("empty key")
+	}
+	delete(s.deletes, key)
+	s.writes[key] = value
+	return nil
+}
+
+func (s *mockStub) DelState(key string) error {
+	delete(s.writes, key)
+	s.deletes[key] = true
+	return nil
+}
+
+// SetEvent refuses a second event: Fabric would silently keep only the last one
+func (s *mockStub) SetEvent(name string, payload []byte) error {
+	if s.event != nil {
+		return fmt.Errorf("%s event set after %s in the same transaction", name, s.event.Name)
+	}
+	s.event = &mockEvent{Name: name, Payload: payload}
+	return nil
+}
+
+func (s *mockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	key := "\x00" + objectType + "\x00"
+	for _, attribute := range attributes {
+		key += attribute + "\x00"
+	}
+	return key, nil
+}
+
+func (s *mockStub) SplitCompositeKey(key string) (string, []string, error) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, "\x00"), "\x00"), "\x00")
+	return parts[0], parts[1:], nil
+}
+
+// sortedKeys returns the committed keys starting with prefix, in key order
+func (s *mockStub) sortedKeys(prefix string) []string {
+	var keys []string
+	for key := range s.l.state {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *mockStub) kvs(keys []string) []*queryresult.KV {
+	kvs := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, &queryresult.KV{Key: key, Value: s.l.state[key]})
+	}
+	return kvs
+}
+
+// GetStateByRange returns documents only; composite keys are not returned by range reads
+func (s *mockStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	var keys []string
+	for _, key := range s.sortedKeys("") {
+		if !strings.HasPrefix(key, "\x00") && key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	return &mockKVIterator{kvs: s.kvs(keys)}, nil
+}
+
+func (s *mockStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, _ := s.CreateCompositeKey(objectType, attributes)
+	return &mockKVIterator{kvs: s.kvs(s.sortedKeys(prefix))}, nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination uses the offset of the next key as its bookmark
+func (s *mockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	prefix, _ := s.CreateCompositeKey(objectType, attributes)
+	keys := s.sortedKeys(prefix)
+
+	start := 0
+	if bookmark != "" {
+		start, _ = strconv.Atoi(bookmark)
+	}
+	if start > len(keys) {
+		start = len(keys)
+	}
+	end := start + int(pageSize)
+	next := strconv.Itoa(end)
+	if end >= len(keys) {
+		end, next = len(keys), ""
+	}
+	metadata := &pb.QueryResponseMetadata{FetchedRecordsCount: int32(end - start), Bookmark: next}
+	return &mockKVIterator{kvs: s.kvs(keys[start:end])}, metadata, nil
+}
+
+func (s *mockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("rich queries are not supported by LevelDB")
+}
+
+func (s *mockStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, fmt.Errorf("rich queries are not supported by LevelDB")
+}
+
+// GetHistoryForKey returns the modifications of a key newest first, as Fabric does
+func (s *mockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	modifications := s.l.history[key]
+	newestFirst := make([]mockModification, len(modifications))
+	for i, modification := range modifications {
+		newestFirst[len(modifications)-1-i] = modification
+	}
+	return &mockHistoryIterator{modifications: newestFirst}, nil
+}
+
+type mockKVIterator struct {
+	kvs  []*queryresult.KV
+	next int
+}
+
+func (it *mockKVIterator) HasNext() bool { return it.next < len(it.kvs) }
+func (it *mockKVIterator) Close() error  { return nil }
+
+func (it *mockKVIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	it.next++
+	return it.kvs[it.next-1], nil
+}
+
+type mockHistoryIterator struct {
+	modifications []mockModification
+	next          int
+}
+
+func (it *mockHistoryIterator) HasNext() bool { return it.next < len(it.modifications) }
+func (it *mockHistoryIterator) Close() error  { return nil }
+
+func (it *mockHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	modification := it.modifications[it.next]
+	it.next++
+	return &queryresult.KeyModification{
+		TxId:      modification.txID,
+		Value:     modification.value,
+		Timestamp: timestamppb.New(modification.timestamp),
+		IsDelete:  modification.isDelete,
+	}, nil
+}
+
+// contract is the chaincode under test; it keeps no state of its own
+var contract = &PharmaContract{}
+
+// testGTIN is the GTIN-14 of the product every test batch is made of
+const testGTIN = "00890000000014"
+
+// submit runs fn as a transaction submitted by id
+func submit(t *testing.T, l *mockLedger, id *mockIdentity, fn func(ctx *mockCtx) error) error {
+	t.Helper()
+	_, err := l.run(id, fn)
+	return err
+}
+
+// mustSubmit runs fn as a transaction submitted by id and fails the test if it is rejected
+func mustSubmit(t *testing.T, l *mockLedger, id *mockIdentity, fn func(ctx *mockCtx) error) {
+	t.Helper()
+	err := submit(t, l, id, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putDoc overwrites a committed document directly, as earlier chaincode versions may have left it
+func putDoc(t *testing.T, l *mockLedger, key string, doc map[string]interface{}) {
+	t.Helper()
+	docJSON, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.state[key] = docJSON
+}
+
+// getDoc reads a committed document
+func getDoc(t *testing.T, l *mockLedger, key string) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	err := json.Unmarshal(l.state[key], &doc)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", key, err)
+	}
+	return doc
+}
+
+// setupBatch registers the test product if needed and creates and releases a batch of it for Org1MSP
+func setupBatch(t *testing.T, l *mockLedger, batchNumber string) {
+	t.Helper()
+	if l.state["BATCH_"+batchNumber] != nil {
+		return
+	}
+	if l.state["PRODUCT_"+testGTIN] == nil {
+		mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.RegisterProduct(ctx, testGTIN, "", "Paracetamol", "500mg", "tablet", 10, "below 25C")
+			return err
+		})
+	}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateBatch(ctx, batchNumber, testGTIN, "Site A", 100)
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.ReleaseBatch(ctx, batchNumber, 100, "sha256:abc")
+		return err
+	})
+}
+
+// buildShipment packs a shipment <prefix>-SH of two cartons <prefix>-C<c>, each of two boxes
+// <prefix>-B<c><b>, each of two strips <prefix>-S<c><b><s> from the given batch
+func buildShipment(t *testing.T, l *mockLedger, prefix string, batchNumber string) {
+	t.Helper()
+	setupBatch(t, l, batchNumber)
+	var cartonIDs []string
+	for c := 0; c < 2; c++ {
+		var boxIDs []string
+		for b := 0; b < 2; b++ {
+			var stripIDs []string
+			for s := 0; s < 2; s++ {
+				stripID := fmt.Sprintf("%s-S%d%d%d", prefix, c, b, s)
+				stripIDs = append(stripIDs, stripID)
+				mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+					_, err := contract.CreateStrip(ctx, stripID, batchNumber, "Paracetamol", "2025-01-01", "2027-01-01")
+					return err
+				})
+			}
+			boxID := fmt.Sprintf("%s-B%d%d", prefix, c, b)
+			boxIDs = append(boxIDs, boxID)
+			mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+				_, err := contract.SealBox(ctx, boxID, idList(stripIDs...))
+				return err
+			})
+		}
+		cartonID := fmt.Sprintf("%s-C%d", prefix, c)
+		cartonIDs = append(cartonIDs, cartonID)
+		mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.SealCarton(ctx, cartonID, idList(boxIDs...))
+			return err
+		})
+	}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.SealShipment(ctx, prefix+"-SH", idList(cartonIDs...))
+		return err
+	})
+}
+
+// buildCarton packs one strip <id>-S into a box <id>-B into the carton id
+func buildCarton(t *testing.T, l *mockLedger, id string, batchNumber string) {
+	t.Helper()
+	setupBatch(t, l, batchNumber)
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateStrip(ctx, id+"-S", batchNumber, "", "2025-01-01", "2027-01-01")
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.SealBox(ctx, id+"-B", idList(id+"-S"))
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.SealCarton(ctx, id, idList(id+"-B"))
+		return err
+	})
+}
+
+// createOrder orders the given shipments or pallets from Org1MSP to pharm1 at Org2MSP
+func createOrder(t *testing.T, l *mockLedger, orderID string, itemIDs ...string) {
+	t.Helper()
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.CreateOrder(ctx, orderID, idList(itemIDs...), "pharm1", "Org2MSP")
+		return err
+	})
+}
+
+// idList encodes IDs as the JSON list the contract functions take
+func idList(ids ...string) string {
+	if ids == nil {
+		ids = []string{}
+	}
+	idsJSON, _ := json.Marshal(ids)
+	return string(idsJSON)
+}
+
+// expectError fails the test unless err is an error mentioning want
+func expectError(t *testing.T, err error, want string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected an error containing %q", want)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("expected an error containing %q, got %v", want, err)
+	}
+}
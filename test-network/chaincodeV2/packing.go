@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// defaultMaxChildren is the capacity of every packaging level for products without their own packing rules
+const defaultMaxChildren = 10
+
+// PackingRule limits what a single container of one packaging level may hold
+type PackingRule struct {
+	MinChildren int  `json:"minChildren"`
+	MaxChildren int  `json:"maxChildren"`
+	SameBatch   bool `json:"sameBatch"`   // Every strip in the container must come from one batch
+	SameProduct bool `json:"sameProduct"` // Every strip in the container must be of one product
+}
+
+// PackingRules holds a product's packing rule for each container doc type (box, carton, pallet, shipment)
+type PackingRules struct {
+	DocType   string                 `json:"docType"`
+	ID        string                 `json:"id"`
+	GTIN      string                 `json:"gtin"`
+	Levels    map[string]PackingRule `json:"levels"`
+	UpdatedBy string                 `json:"updatedBy"` // MSP ID of the organization that last changed the rules
+	UpdatedAt time.Time              `json:"updatedAt"`
+}
+
+// packingRulesID returns the ledger key of the packing rules for a GTIN-14
+func packingRulesID(gtin string) string {
+	return "PACKING_RULES_" + gtin
+}
+
+// defaultPackingRule is applied to levels a product has no rule for: 1 to 10 children,
+// and boxes may only hold a single product
+func defaultPackingRule(docType string) PackingRule {
+	return PackingRule{
+		MinChildren: 1,
+		MaxChildren: defaultMaxChildren,
+		SameProduct: docType == DocTypeBox,
+	}
+}
+
+// getPackingRules reads the packing rules of a GTIN-14, returning rules without levels if none were set
+func (c *PharmaContract) getPackingRules(ctx contractapi.TransactionContextInterface, gtin string) (*PackingRules, error) {
+	rulesJSON, err := ctx.GetStub().GetState(packingRulesID(gtin))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packing rules: %v", err)
+	}
+	if rulesJSON == nil {
+		return &PackingRules{
+			DocType: DocTypePackingRules,
+			ID:      packingRulesID(gtin),
+			GTIN:    gtin,
+			Levels:  map[string]PackingRule{},
+		}, nil
+	}
+
+	var rules PackingRules
+	err = json.Unmarshal(rulesJSON, &rules)
+	if err != nil {
+		return nil, err
+	}
+	if rules.Levels == nil {
+		rules.Levels = map[string]PackingRule{}
+	}
+	return &rules, nil
+}
+
+// rule returns the rule for one packaging level, falling back to the default
+func (r *PackingRules) rule(docType string) PackingRule {
+	if rule, ok := r.Levels[docType]; ok {
+		return rule
+	}
+	return defaultPackingRule(docType)
+}
+
+// checkChildIDs rejects an empty child list and IDs listed more than once
+func checkChildIDs(docType string, childIDs []string) error {
+	if len(childIDs) == 0 {
+		return fmt.Errorf("cannot seal an empty %s", docType)
+	}
+	seen := make(map[string]bool, len(childIDs))
+	for _, childID := range childIDs {
+		if childID == "" {
+			return fmt.Errorf("empty ID in %s contents", docType)
+		}
+		if seen[childID] {
+			return fmt.Errorf("%s appears more than once in %s contents", childID, docType)
+		}
+		seen[childID] = true
+	}
+	return nil
+}
+
+// readContainedStrips reads every strip packed under an item
+func (c *PharmaContract) readContainedStrips(ctx contractapi.TransactionContextInterface, id string) ([]*Strip, error) {
+	stripIDs, err := c.collectStripIDs(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	strips := make([]*Strip, 0, len(stripIDs))
+	for _, stripID := range stripIDs {
+		var strip Strip
+		err = c.readAsset(ctx, stripID, &strip)
+		if err != nil {
+			return nil, err
+		}
+		strips = append(strips, &strip)
+	}
+	return strips, nil
+}
+
+// checkPackingRules enforces the packing rules of every product in a container being sealed, given the
+// summary of its contents. When a container mixes products, each product's rule must be satisfied.
+func (c *PharmaContract) checkPackingRules(ctx contractapi.TransactionContextInterface, docType string, containerID string, childCount int, contents *ContentSummary) error {
+	gtins := contents.gtins()
+	batches := contents.Batches
+	if len(gtins) == 0 {
+		// Strips created before products were registered carry no GTIN; hold them to the default rules
+		gtins = []string{""}
+	}
+
+	for _, gtin := range gtins {
+		rules, err := c.getPackingRules(ctx, gtin)
+		if err != nil {
+			return err
+		}
+		rule := rules.rule(docType)
+		if childCount < rule.MinChildren || childCount > rule.MaxChildren {
+			return fmt.Errorf("%s %s holds %d items, product %s allows %d to %d", docType, containerID, childCount, gtin, rule.MinChildren, rule.MaxChildren)
+		}
+		if rule.SameProduct && len(gtins) > 1 {
+			return fmt.Errorf("%s %s mixes products %v, product %s requires a single product", docType, containerID, gtins, gtin)
+		}
+		if rule.SameBatch && len(batches) > 1 {
+			return fmt.Errorf("%s %s mixes batches %v, product %s requires a single batch", docType, containerID, batches, gtin)
+		}
+	}
+	return nil
+}
+
+// SetPackingRules stores the packing rules of a product; only the organization that registered it may do so.
+// rulesJSON maps container doc types to rules, e.g. {"box":{"minChildren":10,"maxChildren":10,"sameBatch":true}}.
+// Levels left out fall back to the default rule.
+func (c *PharmaContract) SetPackingRules(ctx contractapi.TransactionContextInterface, gtin string, rulesJSON string) (*PackingRules, error) {
+	if err := c.checkAccess(ctx, "SetPackingRules"); err != nil {
+		return nil, err
+	}
+
+	product, err := c.getProduct(ctx, gtin)
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != product.ManufacturerOrg {
+		return nil, fmt.Errorf("product %s belongs to %s, not %s", product.GTIN, product.ManufacturerOrg, mspID)
+	}
+
+	var levels map[string]PackingRule
+	err = json.Unmarshal([]byte(rulesJSON), &levels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse packing rules: %v", err)
+	}
+	for docType, rule := range levels {
+		if !isPackagingLevel(docType) {
+			return nil, fmt.Errorf("%s is not a packaging level", docType)
+		}
+		if rule.MinChildren < 1 || rule.MaxChildren < rule.MinChildren {
+			return nil, fmt.Errorf("%s rule needs 1 <= minChildren <= maxChildren", docType)
+		}
+	}
+
+	rules := PackingRules{
+		DocType:   DocTypePackingRules,
+		ID:        packingRulesID(product.GTIN),
+		GTIN:      product.GTIN,
+		Levels:    levels,
+		UpdatedBy: mspID,
+		UpdatedAt: c.getTxTimestamp(ctx),
+	}
+
+	err = c.writeAsset(ctx, rules.ID, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventPackingRulesSet, DocType: DocTypePackingRules, ItemIDs: []string{rules.ID}, Details: rules})
+	if err != nil {
+		return nil, err
+	}
+
+	return &rules, nil
+}
+
+// GetPackingRules returns the rule applied to each packaging level for a product, including defaults
+func (c *PharmaContract) GetPackingRules(ctx contractapi.TransactionContextInterface, gtin string) (*PackingRules, error) {
+	product, err := c.getProduct(ctx, gtin)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := c.getPackingRules(ctx, product.GTIN)
+	if err != nil {
+		return nil, err
+	}
+	for _, docType := range []string{DocTypeBox, DocTypeCarton, DocTypePallet, DocTypeShipment} {
+		rules.Levels[docType] = rules.rule(docType)
+	}
+	return rules, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestPackingRules(t *testing.T) {
+	l := newLedger()
+	setupBatch(t, l, "B1")
+	setupBatch(t, l, "B2")
+	otherGTIN := "00890000000021"
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.RegisterProduct(ctx, otherGTIN, "", "Ibuprofen", "200mg", "tablet", 10, "")
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateBatch(ctx, "B3", otherGTIN, "Site A", 100)
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.ReleaseBatch(ctx, "B3", 100, "sha256:abc")
+		return err
+	})
+	for _, strip := range []struct{ id, batchNumber string }{{"a", "B1"}, {"b", "B1"}, {"c", "B2"}, {"d", "B3"}} {
+		mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.CreateStrip(ctx, strip.id, strip.batchNumber, "", "2025-01-01", "2027-01-01")
+			return err
+		})
+	}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateStripsBulk(ctx, `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","range":{"prefix":"E","start":1,"count":11}}`)
+		return err
+	})
+
+	seal := func(boxID string, childIDsJSON string) error {
+		return submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.SealBox(ctx, boxID, childIDsJSON)
+			return err
+		})
+	}
+	setRules := func(id *mockIdentity, gtin string, rulesJSON string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.SetPackingRules(ctx, gtin, rulesJSON)
+			return err
+		})
+	}
+
+	// Without rules of its own a product gets the default: 1 to 10 children, one product per box
+	defaults := []struct {
+		childIDsJSON string
+		err          string
+	}{
+		{`[]`, "cannot seal an empty box"},
+		{`["a","a"]`, "a appears more than once in box contents"},
+		{`["a","d"]`, "mixes products"},
+		{idList("E1", "E2", "E3", "E4", "E5", "E6", "E7", "E8", "E9", "E10", "E11"), "box X holds 11 items, product 00890000000014 allows 1 to 10"},
+	}
+	for _, tt := range defaults {
+		expectError(t, seal("X", tt.childIDsJSON), tt.err)
+	}
+
+	// Rules are checked when set
+	org2Mfr := &mockIdentity{msp: "Org2MSP", cn: "mfr2", ou: "client", attrs: map[string]string{"role": RoleManufacturer}}
+	expectError(t, setRules(org2Mfr, testGTIN, `{"box":{"minChildren":2,"maxChildren":2}}`), "belongs to Org1MSP, not Org2MSP")
+	expectError(t, setRules(org1Mfr, testGTIN, `{"order":{"minChildren":1,"maxChildren":2}}`), "order is not a packaging level")
+	expectError(t, setRules(org1Mfr, testGTIN, `{"box":{"minChildren":3,"maxChildren":2}}`), "box rule needs 1 <= minChildren <= maxChildren")
+	if err := setRules(org1Mfr, testGTIN, `{"box":{"minChildren":2,"maxChildren":2,"sameBatch":true}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, seal("X", idList("a")), "box X holds 1 items, product 00890000000014 allows 2 to 2")
+	expectError(t, seal("X", idList("a", "c")), "box X mixes batches [B1 B2], product 00890000000014 requires a single batch")
+	if err := seal("X", idList("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cartons keep the default rule and may mix products
+	if err := seal("Y", idList("d")); err != nil {
+		t.Fatal(err)
+	}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.SealCarton(ctx, "Z", idList("X", "Y"))
+		return err
+	})
+
+	var rules *PackingRules
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		rules, err = contract.GetPackingRules(ctx, testGTIN)
+		return err
+	})
+	if box, carton := rules.Levels[DocTypeBox], rules.Levels[DocTypeCarton]; box.MaxChildren != 2 || !box.SameBatch || carton.MaxChildren != defaultMaxChildren || carton.SameProduct {
+		t.Errorf("rules %+v", rules.Levels)
+	}
+}
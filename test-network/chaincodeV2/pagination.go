@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Pages of items are read from the composite-key indexes of index.go with
+// GetStateByPartialCompositeKeyWithPagination rather than from CouchDB rich queries, so paging works on
+// LevelDB peers as well and a bookmark is simply the next index key. Only QueryItems, whose field filters
+// need CouchDB, pages with GetQueryResultWithPagination. The available-item variants page through the
+// CREATED entries of the docType~status index: packing seals an item, so CREATED items are the unpacked ones.
+
+// maxPageSize caps the number of records a paginated query returns at once
+const maxPageSize = 1000
+
+// PaginatedQueryResult is one page of a query. Pass Bookmark back with the next call to get the following
+// page. Index pages end with an empty Bookmark; CouchDB always returns one, so for QueryItems a page with
+// fewer records than requested is the last one.
+type PaginatedQueryResult struct {
+	Records             []interface{} `json:"records"`
+	FetchedRecordsCount int32         `json:"fetchedRecordsCount"`
+	Bookmark            string        `json:"bookmark"`
+}
+
+// checkPageSize refuses page sizes outside 1 to maxPageSize
+func checkPageSize(pageSize int32) error {
+	if pageSize < 1 || pageSize > maxPageSize {
+		return fmt.Errorf("page size must be between 1 and %d", maxPageSize)
+	}
+	return nil
+}
+
+// getIndexedPage returns one page of the documents under a partial index key, in key order, starting at
+// bookmark (empty for the first page). Index keys are range reads, so this works on LevelDB and CouchDB.
+func (c *PharmaContract) getIndexedPage(ctx contractapi.TransactionContextInterface, objectType string, attributes []string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(objectType, attributes, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", objectType, err)
+	}
+	defer resultsIterator.Close()
+
+	records := []interface{}{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s index: %v", objectType, err)
+		}
+		_, parts, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+		if err != nil || len(parts) == 0 {
+			return nil, fmt.Errorf("malformed %s index key %q", objectType, kv.Key)
+		}
+
+		var record interface{}
+		err = c.readAsset(ctx, parts[len(parts)-1], &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return &PaginatedQueryResult{
+		Records:             records,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// getQueryPage runs a complete CouchDB query (selector plus sort and index hints) and returns one page
+// of the matching documents. It needs CouchDB as the state database.
+func (c *PharmaContract) getQueryPage(ctx contractapi.TransactionContextInterface, query map[string]interface{}, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	err := checkPageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %v", err)
+	}
+
+	resultsIterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryJSON), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []interface{}{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record interface{}
+		err = json.Unmarshal(queryResult.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return &PaginatedQueryResult{
+		Records:             records,
+		FetchedRecordsCount: metadata.FetchedRecordsCount,
+		Bookmark:            metadata.Bookmark,
+	}, nil
+}
+
+// GetAllItemsWithPagination returns one page of the items of a specific type
+func (c *PharmaContract) GetAllItemsWithPagination(ctx contractapi.TransactionContextInterface, docType string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return c.getIndexedPage(ctx, IndexDocTypeStatus, []string{docType}, pageSize, bookmark)
+}
+
+// GetAllOrdersWithPagination returns one page of all orders
+func (c *PharmaContract) GetAllOrdersWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return c.getIndexedPage(ctx, IndexDocTypeStatus, []string{DocTypeOrder}, pageSize, bookmark)
+}
+
+// GetOrdersByRecipientWithPagination returns one page of the orders for a specific recipient
+func (c *PharmaContract) GetOrdersByRecipientWithPagination(ctx contractapi.TransactionContextInterface, recipient string, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return c.getIndexedPage(ctx, IndexRecipient, []string{recipient}, pageSize, bookmark)
+}
+
+// GetAvailableStripsWithPagination returns one page of the strips not yet in a box
+func (c *PharmaContract) GetAvailableStripsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return c.getIndexedPage(ctx, IndexDocTypeStatus, []string{DocTypeStrip, StatusCreated}, pageSize, bookmark)
+}
+
+// GetAvailableBoxesWithPagination returns one page of the boxes not yet in a carton
+func (c *PharmaContract) GetAvailableBoxesWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return c.getIndexedPage(ctx, IndexDocTypeStatus, []string{DocTypeBox, StatusCreated}, pageSize, bookmark)
+}
+
+// GetAvailableCartonsWithPagination returns one page of the cartons not yet in a shipment
+func (c *PharmaContract) GetAvailableCartonsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return c.getIndexedPage(ctx, IndexDocTypeStatus, []string{DocTypeCarton, StatusCreated}, pageSize, bookmark)
+}
+
+// GetAvailableShipmentsWithPagination returns one page of the shipments not yet distributed
+func (c *PharmaContract) GetAvailableShipmentsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PaginatedQueryResult, error) {
+	return c.getIndexedPage(ctx, IndexDocTypeStatus, []string{DocTypeShipment, StatusCreated}, pageSize, bookmark)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPaginationBookmarks(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateStripsBulk(ctx, `{"batchNumber":"B1","mfgDate":"2025-01-01","expDate":"2027-01-01","range":{"prefix":"L","start":1,"count":5}}`)
+		return err
+	})
+
+	// pageIDs follows the bookmarks of a paginated query to the end, returning the IDs of each page
+	pageIDs := func(query func(ctx *mockCtx, bookmark string) (*PaginatedQueryResult, error)) [][]string {
+		t.Helper()
+		var pages [][]string
+		bookmark := ""
+		for {
+			var page *PaginatedQueryResult
+			mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+				var err error
+				page, err = query(ctx, bookmark)
+				return err
+			})
+			var ids []string
+			for _, record := range page.Records {
+				ids = append(ids, docString(record.(map[string]interface{}), "id"))
+			}
+			if int(page.FetchedRecordsCount) != len(ids) {
+				t.Fatalf("page of %d records counts %d", len(ids), page.FetchedRecordsCount)
+			}
+			pages = append(pages, ids)
+			if page.Bookmark == "" {
+				return pages
+			}
+			bookmark = page.Bookmark
+		}
+	}
+
+	// The 13 strips come back once each over pages of 5, the last one ending with an empty bookmark
+	pages := pageIDs(func(ctx *mockCtx, bookmark string) (*PaginatedQueryResult, error) {
+		return contract.GetAllItemsWithPagination(ctx, DocTypeStrip, 5, bookmark)
+	})
+	seen := map[string]bool{}
+	for _, ids := range pages {
+		for _, id := range ids {
+			if seen[id] {
+				t.Errorf("%s returned twice", id)
+			}
+			seen[id] = true
+		}
+	}
+	if len(pages) != 3 || len(pages[2]) != 3 || len(seen) != 13 {
+		t.Errorf("pages %v", pages)
+	}
+
+	// Available strips are the unpacked ones
+	pages = pageIDs(func(ctx *mockCtx, bookmark string) (*PaginatedQueryResult, error) {
+		return contract.GetAvailableStripsWithPagination(ctx, 2, bookmark)
+	})
+	if !reflect.DeepEqual(pages, [][]string{{"L1", "L2"}, {"L3", "L4"}, {"L5"}}) {
+		t.Errorf("available strip pages %v", pages)
+	}
+	pages = pageIDs(func(ctx *mockCtx, bookmark string) (*PaginatedQueryResult, error) {
+		return contract.GetAvailableShipmentsWithPagination(ctx, 10, bookmark)
+	})
+	if !reflect.DeepEqual(pages, [][]string{{"P1-SH"}}) {
+		t.Errorf("available shipment pages %v", pages)
+	}
+
+	for _, pageSize := range []int32{0, maxPageSize + 1} {
+		expectError(t, submit(t, l, org1Dist, func(ctx *mockCtx) error {
+			_, err := contract.GetAllItemsWithPagination(ctx, DocTypeStrip, pageSize, "")
+			return err
+		}), "page size must be between 1 and 1000")
+	}
+}
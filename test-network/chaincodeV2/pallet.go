@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SealPallet creates a pallet holding either cartons or whole shipments. Pallets are logistic units, so
+// an empty ID is replaced by the next SSCC of the caller's organization.
+func (c *PharmaContract) SealPallet(ctx contractapi.TransactionContextInterface, palletID string, itemIDsJSON string) (*Pallet, error) {
+	if err := c.checkAccess(ctx, "SealPallet"); err != nil {
+		return nil, err
+	}
+
+	var pallet Pallet
+	err := c.sealContainer(ctx, DocTypePallet, palletID, itemIDsJSON, &pallet)
+	if err != nil {
+		return nil, err
+	}
+	return &pallet, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPalletOfCartons(t *testing.T) {
+	l := newLedger()
+	for _, cartonID := range []string{"C1", "C2", "C3"} {
+		buildCarton(t, l, cartonID, "B1")
+	}
+
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.SealPallet(ctx, "PL1", idList("C1", "C2"))
+		return err
+	})
+	pallet := getDoc(t, l, "PL1")
+	if summary, _ := pallet["summary"].(map[string]interface{}); pallet["status"] != StatusCreated || summary["unitCount"] != 2.0 {
+		t.Errorf("pallet %v", pallet)
+	}
+	if carton := getDoc(t, l, "C1"); carton["palletId"] != "PL1" || carton["status"] != StatusSealed {
+		t.Errorf("carton %v", carton)
+	}
+
+	// A shipment may carry pallets next to loose cartons
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.SealShipment(ctx, "SH1", idList("PL1", "C3"))
+		return err
+	})
+	if pallet := getDoc(t, l, "PL1"); pallet["shipmentId"] != "SH1" || pallet["status"] != StatusSealed {
+		t.Errorf("pallet %v", pallet)
+	}
+
+	// A scan walks up through the pallet to the shipment
+	var trace *TraceResult
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		var err error
+		trace, err = contract.ScanBarcode(ctx, "C1-S")
+		return err
+	})
+	traceJSON, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanned struct {
+		Ancestors []map[string]interface{} `json:"ancestors"`
+		Root      map[string]interface{}   `json:"root"`
+	}
+	if err := json.Unmarshal(traceJSON, &scanned); err != nil {
+		t.Fatal(err)
+	}
+	var ancestorIDs []string
+	for _, ancestor := range scanned.Ancestors {
+		ancestorIDs = append(ancestorIDs, docString(ancestor, "id"))
+	}
+	if strings.Join(ancestorIDs, ",") != "C1-B,C1,PL1,SH1" || scanned.Root["id"] != "SH1" {
+		t.Errorf("ancestors %v, root %v", ancestorIDs, scanned.Root["id"])
+	}
+
+	// Cartons move on and off a pallet like any other child
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.UnpackShipment(ctx, "SH1")
+		return err
+	})
+	buildCarton(t, l, "C4", "B1")
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.MoveChild(ctx, "C4", "PL1")
+		return err
+	})
+	if cartons := docStrings(getDoc(t, l, "PL1"), "cartons"); len(cartons) != 3 {
+		t.Errorf("pallet holds %v", cartons)
+	}
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.UnpackPallet(ctx, "PL1")
+		return err
+	})
+	if carton := getDoc(t, l, "C4"); carton["palletId"] != "" || carton["status"] != StatusCreated {
+		t.Errorf("carton %v", carton)
+	}
+}
+
+func TestPalletOfShipments(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildShipment(t, l, "P2", "B1")
+	buildCarton(t, l, "C1", "B1")
+
+	refused := []struct {
+		name string
+		fn   func(ctx *mockCtx) error
+		err  string
+	}{
+		{"mixed pallet", func(ctx *mockCtx) error {
+			_, err := contract.SealPallet(ctx, "PL9", idList("P1-SH", "C1"))
+			return err
+		}, "pallet PL9 must hold a single kind of item"},
+		{"strip on a pallet", func(ctx *mockCtx) error {
+			_, err := contract.SealPallet(ctx, "PL9", idList("C1-S"))
+			return err
+		}, "cannot be packed in a pallet"},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			expectError(t, submit(t, l, org1Dist, tt.fn), tt.err)
+		})
+	}
+
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.SealPallet(ctx, "PL1", idList("P1-SH", "P2-SH"))
+		return err
+	})
+	if summary, _ := getDoc(t, l, "PL1")["summary"].(map[string]interface{}); summary["unitCount"] != 16.0 {
+		t.Errorf("pallet summary %v", summary)
+	}
+
+	// A pallet of shipments is the outermost level
+	expectError(t, submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.SealShipment(ctx, "SH9", idList("PL1"))
+		return err
+	}), "pallet PL1 holds shipments and cannot be packed in a shipment")
+	expectError(t, submit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.MoveChild(ctx, "C1", "PL1")
+		return err
+	}), "pallet PL1 must hold a single kind of item")
+
+	// Orders take pallets, and dispatch reaches every strip on them
+	createOrder(t, l, "O1", "PL1")
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	})
+	for _, id := range []string{"PL1", "P1-SH", "P2-C1", "P2-S111"} {
+		if status := getDoc(t, l, id)["status"]; status != StatusDispatched {
+			t.Errorf("%s is %v", id, status)
+		}
+	}
+
+	var trace *BlockchainTraceResult
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		var err error
+		trace, err = contract.GetFullTraceFromBlockchain(ctx, "P2-S111")
+		return err
+	})
+	traceJSON, err := json.Marshal(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"P2-B11", "P2-C1", "P2-SH", "PL1", "O1"} {
+		if !strings.Contains(string(traceJSON), `"`+id+`"`) {
+			t.Errorf("trace of P2-S111 misses %s", id)
+		}
+	}
+}
@@ -15,13 +15,49 @@ type PharmaContract struct {
 	contractapi.Contract
 }
 
+// PharmaTransactionContext is the transaction context of the contract. Fabric does not return a
+// transaction's own writes from GetState, so it remembers the documents written so far; writeAsset
+// moves indexes and counters from those rather than from the committed documents.
+type PharmaTransactionContext struct {
+	contractapi.TransactionContext
+	written map[string][]byte
+}
+
+// writtenDoc returns the document this transaction last wrote under id, if it wrote one
+func (ctx *PharmaTransactionContext) writtenDoc(id string) ([]byte, bool) {
+	docJSON, ok := ctx.written[id]
+	return docJSON, ok
+}
+
+// recordWrite remembers that this transaction wrote docJSON under id
+func (ctx *PharmaTransactionContext) recordWrite(id string, docJSON []byte) {
+	if ctx.written == nil {
+		ctx.written = map[string][]byte{}
+	}
+	ctx.written[id] = docJSON
+}
+
+// docWriteTracker is implemented by transaction contexts that remember their own writes
+type docWriteTracker interface {
+	writtenDoc(id string) ([]byte, bool)
+	recordWrite(id string, docJSON []byte)
+}
+
 // DocType constants
 const (
-	DocTypeStrip    = "strip"
-	DocTypeBox      = "box"
-	DocTypeCarton   = "carton"
-	DocTypeShipment = "shipment"
-	DocTypeOrder    = "order"
+	DocTypeStrip        = "strip"
+	DocTypeBox          = "box"
+	DocTypeCarton       = "carton"
+	DocTypeShipment     = "shipment"
+	DocTypePallet       = "pallet"
+	DocTypeOrder        = "order"
+	DocTypeRecall       = "recall"
+	DocTypeBatch        = "batch"
+	DocTypeProduct      = "product"
+	DocTypeSSCCConfig   = "ssccConfig"
+	DocTypePackingRules = "packingRules"
+	DocTypeTransfer     = "transfer"
+	DocTypeReceipt      = "receipt"
 )
 
 // Status constants
@@ -32,88 +68,150 @@ const (
 	StatusDispatched = "DISPATCHED"
 	StatusShipped    = "SHIPPED"
 	StatusDelivered  = "DELIVERED"
+	StatusRecalled   = "RECALLED"
+
+	StatusPartiallyDelivered = "PARTIALLY_DELIVERED" // Received with shortages or damage
+	StatusDamaged            = "DAMAGED"             // Received unusable
+	StatusRejected           = "REJECTED"            // Refused by the receiving organization
+	StatusCancelled          = "CANCELLED"           // Order withdrawn by its sender before dispatch
 )
 
 // Strip represents a single medicine strip (smallest unit)
 type Strip struct {
-	DocType      string    `json:"docType"`
-	ID           string    `json:"id"`
-	BatchNumber  string    `json:"batchNumber"`
-	MedicineType string    `json:"medicineType"`
-	MfgDate      string    `json:"mfgDate"`
-	ExpDate      string    `json:"expDate"`
-	Status       string    `json:"status"`
-	BoxID        string    `json:"boxId"`
-	CreationTxId string    `json:"creationTxId"` // The transaction ID when this strip was created (never changes)
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	DocType          string         `json:"docType"`
+	ID               string         `json:"id"`
+	BatchNumber      string         `json:"batchNumber"`
+	GTIN             string         `json:"gtin"`         // Product of the batch
+	MedicineType     string         `json:"medicineType"` // Product name, copied from the product master data
+	MfgDate          string         `json:"mfgDate"`
+	ExpDate          string         `json:"expDate"`
+	Status           string         `json:"status"`
+	CurrentOwner     string         `json:"currentOwner,omitempty"`     // MSP ID of the organization that owns the strip
+	CurrentCustodian string         `json:"currentCustodian,omitempty"` // MSP ID of the organization holding the strip
+	PendingTransfer  string         `json:"pendingTransfer,omitempty"`  // Transfer of the strip awaiting acceptance
+	BoxID            string         `json:"boxId"`
+	PackingChange    *PackingChange `json:"packingChange,omitempty"` // Last unpack or move this strip took part in
+	CreationTxId     string         `json:"creationTxId"`            // The transaction ID when this strip was created (never changes)
+	CreatedAt        time.Time      `json:"createdAt"`
+	UpdatedAt        time.Time      `json:"updatedAt"`
 }
 
-// Box contains multiple strips (10 strips per box)
+// Box contains multiple strips (up to 10 per box unless the product's packing rules say otherwise)
 type Box struct {
-	DocType      string    `json:"docType"`
-	ID           string    `json:"id"`
-	Strips       []string  `json:"strips"`
-	CartonID     string    `json:"cartonId"`
-	Status       string    `json:"status"`
-	CreationTxId string    `json:"creationTxId"` // The transaction ID when this box was created (never changes)
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	DocType          string          `json:"docType"`
+	ID               string          `json:"id"`
+	Strips           []string        `json:"strips"`
+	CartonID         string          `json:"cartonId"`
+	Status           string          `json:"status"`
+	CurrentOwner     string          `json:"currentOwner,omitempty"`     // MSP ID of the organization that owns the box
+	CurrentCustodian string          `json:"currentCustodian,omitempty"` // MSP ID of the organization holding the box
+	PendingTransfer  string          `json:"pendingTransfer,omitempty"`  // Transfer of the box awaiting acceptance
+	RecalledBatches  []string        `json:"recalledBatches,omitempty"`  // Recalled batches packed in this box
+	Summary          *ContentSummary `json:"summary,omitempty"`          // What the box holds, kept current as contents change
+	PackingChange    *PackingChange  `json:"packingChange,omitempty"`    // Last unpack or move this box took part in
+	CreationTxId     string          `json:"creationTxId"`               // The transaction ID when this box was created (never changes)
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
 }
 
-// Carton contains multiple boxes (10 boxes per carton)
+// Carton contains multiple boxes (up to 10 per carton unless the product's packing rules say otherwise)
 type Carton struct {
-	DocType      string    `json:"docType"`
-	ID           string    `json:"id"`
-	Boxes        []string  `json:"boxes"`
-	ShipmentID   string    `json:"shipmentId"`
-	Status       string    `json:"status"`
-	CreationTxId string    `json:"creationTxId"` // The transaction ID when this carton was created (never changes)
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	DocType          string          `json:"docType"`
+	ID               string          `json:"id"`
+	Boxes            []string        `json:"boxes"`
+	ShipmentID       string          `json:"shipmentId"`
+	PalletID         string          `json:"palletId,omitempty"` // Set instead of ShipmentID when the carton is palletized
+	Status           string          `json:"status"`
+	CurrentOwner     string          `json:"currentOwner,omitempty"`     // MSP ID of the organization that owns the carton
+	CurrentCustodian string          `json:"currentCustodian,omitempty"` // MSP ID of the organization holding the carton
+	PendingTransfer  string          `json:"pendingTransfer,omitempty"`  // Transfer of the carton awaiting acceptance
+	RecalledBatches  []string        `json:"recalledBatches,omitempty"`  // Recalled batches packed in this carton
+	Summary          *ContentSummary `json:"summary,omitempty"`          // What the carton holds, kept current as contents change
+	PackingChange    *PackingChange  `json:"packingChange,omitempty"`    // Last unpack or move this carton took part in
+	CreationTxId     string          `json:"creationTxId"`               // The transaction ID when this carton was created (never changes)
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
 }
 
-// Shipment contains multiple cartons (10 cartons per shipment)
+// Shipment contains multiple cartons and pallets of cartons (up to 10 per shipment unless the product's
+// packing rules say otherwise)
 type Shipment struct {
-	DocType       string    `json:"docType"`
-	ID            string    `json:"id"`
-	Cartons       []string  `json:"cartons"`
-	OrderID       string    `json:"orderId"` // The order this shipment belongs to
-	Status        string    `json:"status"`
-	Distributor   string    `json:"distributor"`
-	DistributedAt time.Time `json:"distributedAt"`
-	CreationTxId  string    `json:"creationTxId"` // The transaction ID when this shipment was created (never changes)
-	CreatedAt     time.Time `json:"createdAt"`
-	UpdatedAt     time.Time `json:"updatedAt"`
+	DocType          string          `json:"docType"`
+	ID               string          `json:"id"`
+	Cartons          []string        `json:"cartons"`
+	Pallets          []string        `json:"pallets,omitempty"`
+	OrderID          string          `json:"orderId"`            // The order this shipment belongs to
+	PalletID         string          `json:"palletId,omitempty"` // Air freight pallet carrying this shipment
+	Status           string          `json:"status"`
+	CurrentOwner     string          `json:"currentOwner,omitempty"`     // MSP ID of the organization that owns the shipment
+	CurrentCustodian string          `json:"currentCustodian,omitempty"` // MSP ID of the organization holding the shipment
+	PendingTransfer  string          `json:"pendingTransfer,omitempty"`  // Transfer of the shipment awaiting acceptance
+	Distributor      string          `json:"distributor"`                // Enrollment ID of the client that distributed the shipment
+	DistributorOrg   string          `json:"distributorOrg,omitempty"`   // MSP ID of that client
+	DistributedAt    time.Time       `json:"distributedAt"`
+	RecalledBatches  []string        `json:"recalledBatches,omitempty"` // Recalled batches packed in this shipment
+	Summary          *ContentSummary `json:"summary,omitempty"`         // What the shipment holds, kept current as contents change
+	PackingChange    *PackingChange  `json:"packingChange,omitempty"`   // Last unpack or move this shipment took part in
+	CreationTxId     string          `json:"creationTxId"`              // The transaction ID when this shipment was created (never changes)
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
+}
+
+// Pallet holds either cartons, on their way into a shipment, or whole shipments for air freight
+type Pallet struct {
+	DocType          string          `json:"docType"`
+	ID               string          `json:"id"`
+	Cartons          []string        `json:"cartons,omitempty"`
+	Shipments        []string        `json:"shipments,omitempty"`
+	ShipmentID       string          `json:"shipmentId"` // The shipment a pallet of cartons is loaded into
+	OrderID          string          `json:"orderId"`    // The order a pallet belongs to when ordered directly
+	Status           string          `json:"status"`
+	CurrentOwner     string          `json:"currentOwner,omitempty"`     // MSP ID of the organization that owns the pallet
+	CurrentCustodian string          `json:"currentCustodian,omitempty"` // MSP ID of the organization holding the pallet
+	PendingTransfer  string          `json:"pendingTransfer,omitempty"`  // Transfer of the pallet awaiting acceptance
+	RecalledBatches  []string        `json:"recalledBatches,omitempty"`  // Recalled batches packed on this pallet
+	Summary          *ContentSummary `json:"summary,omitempty"`          // What the pallet holds, kept current as contents change
+	PackingChange    *PackingChange  `json:"packingChange,omitempty"`    // Last unpack or move this pallet took part in
+	CreationTxId     string          `json:"creationTxId"`               // The transaction ID when this pallet was created (never changes)
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
 }
 
 // Order represents a pharmaceutical order
 type Order struct {
-	DocType      string    `json:"docType"`
-	ID           string    `json:"id"`
-	ItemType     string    `json:"itemType"` // shipment (orders only contain shipments)
-	ItemIDs      []string  `json:"itemIds"`
-	SenderId     string    `json:"senderId"`    // User ID of the sender (who created the order)
-	SenderOrg    string    `json:"senderOrg"`   // Organization of the sender
-	ReceiverId   string    `json:"receiverId"`  // User ID of the receiver
-	ReceiverOrg  string    `json:"receiverOrg"` // Organization of the receiver
-	Recipient    string    `json:"recipient"`   // Legacy field - display name of recipient
-	Status       string    `json:"status"`
-	DispatchedAt time.Time `json:"dispatchedAt"`
-	DeliveredAt  time.Time `json:"deliveredAt"`
-	CreationTxId string    `json:"creationTxId"` // The transaction ID when this order was created (never changes)
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	DocType          string    `json:"docType"`
+	ID               string    `json:"id"`
+	ItemType         string    `json:"itemType"` // shipment or pallet, or mixed when the order holds both
+	ItemIDs          []string  `json:"itemIds"`
+	SenderId         string    `json:"senderId"`    // Enrollment ID of the client that created the order
+	SenderOrg        string    `json:"senderOrg"`   // MSP ID of that client
+	ReceiverId       string    `json:"receiverId"`  // User ID of the receiver
+	ReceiverOrg      string    `json:"receiverOrg"` // Organization of the receiver
+	Recipient        string    `json:"recipient"`   // Legacy field - display name of recipient
+	Status           string    `json:"status"`
+	CurrentOwner     string    `json:"currentOwner,omitempty"`     // MSP ID of the organization that owns the order
+	CurrentCustodian string    `json:"currentCustodian,omitempty"` // MSP ID of the organization holding the order
+	PendingTransfer  string    `json:"pendingTransfer,omitempty"`  // Transfer of the order awaiting acceptance
+	DispatchedAt     time.Time `json:"dispatchedAt"`
+	DeliveredAt      time.Time `json:"deliveredAt"`
+	ReceiptID        string    `json:"receiptId,omitempty"`       // Receipt recorded by ReceiveOrder, listing any discrepancies
+	CancelReason     string    `json:"cancelReason,omitempty"`    // Given by the sender when cancelling
+	RecalledBatches  []string  `json:"recalledBatches,omitempty"` // Recalled batches packed in this order
+	CreationTxId     string    `json:"creationTxId"`              // The transaction ID when this order was created (never changes)
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
 }
 
 // TraceResult represents the complete trace hierarchy
 type TraceResult struct {
-	ItemType    string      `json:"itemType"`
-	Item        interface{} `json:"item"`
-	Parent      interface{} `json:"parent,omitempty"`
-	GrandParent interface{} `json:"grandParent,omitempty"`
-	Root        interface{} `json:"root,omitempty"`
-	Children    interface{} `json:"children,omitempty"`
+	ItemType    string          `json:"itemType"`
+	Item        interface{}     `json:"item"`
+	Parent      interface{}     `json:"parent,omitempty"`
+	GrandParent interface{}     `json:"grandParent,omitempty"`
+	Root        interface{}     `json:"root,omitempty"`
+	Children    interface{}     `json:"children,omitempty"`
+	Summary     *ContentSummary `json:"summary,omitempty"`   // Contents of a box, carton, pallet or shipment
+	Ancestors   interface{}     `json:"ancestors,omitempty"` // Every container above the item, innermost first, ending with its order
 }
 
 // InitLedger initializes the ledger with sample data
@@ -133,6 +231,14 @@ func (c *PharmaContract) getTxTimestamp(ctx contractapi.TransactionContextInterf
 
 // CreateStrip creates a new medicine strip
 func (c *PharmaContract) CreateStrip(ctx contractapi.TransactionContextInterface, id string, batchNumber string, medicineType string, mfgDate string, expDate string) (*Strip, error) {
+	if err := c.checkAccess(ctx, "CreateStrip"); err != nil {
+		return nil, err
+	}
+
+	err := checkItemID(DocTypeStrip, id)
+	if err != nil {
+		return nil, err
+	}
 	exists, err := c.assetExists(ctx, id)
 	if err != nil {
 		return nil, err
@@ -141,94 +247,28 @@ func (c *PharmaContract) CreateStrip(ctx contractapi.TransactionContextInterface
 		return nil, fmt.Errorf("strip %s already exists", id)
 	}
 
-	// Get transaction ID - this uniquely identifies THIS strip's creation
-	txId := ctx.GetStub().GetTxID()
-
-	// Get transaction timestamp for consistency across peers
-	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
-	}
-	now := txTimestamp.AsTime()
-	strip := Strip{
-		DocType:      DocTypeStrip,
-		ID:           id,
-		BatchNumber:  batchNumber,
-		MedicineType: medicineType,
-		MfgDate:      mfgDate,
-		ExpDate:      expDate,
-		Status:       StatusCreated,
-		BoxID:        "",
-		CreationTxId: txId, // Store the creation transaction ID (never changes)
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-
-	stripJSON, err := json.Marshal(strip)
+	err = validateStripDates(mfgDate, expDate)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(id, stripJSON)
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
 	}
 
-	return &strip, nil
-}
-
-// SealBox creates a box containing specified strips
-func (c *PharmaContract) SealBox(ctx contractapi.TransactionContextInterface, boxID string, stripIDsJSON string) (*Box, error) {
-	exists, err := c.assetExists(ctx, boxID)
+	// Strips can only be created by the batch's manufacturer against a released batch, and take their
+	// product from it
+	product, err := c.getReleasedBatchProduct(ctx, batchNumber, mspID)
 	if err != nil {
 		return nil, err
 	}
-	if exists {
-		return nil, fmt.Errorf("box %s already exists", boxID)
-	}
-
-	var stripIDs []string
-	err = json.Unmarshal([]byte(stripIDsJSON), &stripIDs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse strip IDs: %v", err)
+	// medicineType is optional; when given it must name the batch's product (by name or GTIN)
+	if medicineType != "" && !strings.EqualFold(medicineType, product.Name) && medicineType != product.GTIN {
+		return nil, fmt.Errorf("medicine type %s does not match product %s (%s) of batch %s", medicineType, product.Name, product.GTIN, batchNumber)
 	}
 
-	// Validate and update each strip
-	for _, stripID := range stripIDs {
-		stripJSON, err := ctx.GetStub().GetState(stripID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get strip %s: %v", stripID, err)
-		}
-		if stripJSON == nil {
-			return nil, fmt.Errorf("strip %s does not exist", stripID)
-		}
-
-		var strip Strip
-		err = json.Unmarshal(stripJSON, &strip)
-		if err != nil {
-			return nil, err
-		}
-
-		if strip.BoxID != "" {
-			return nil, fmt.Errorf("strip %s is already in box %s", stripID, strip.BoxID)
-		}
-
-		strip.BoxID = boxID
-		strip.Status = StatusSealed
-		strip.UpdatedAt = c.getTxTimestamp(ctx)
-
-		updatedStripJSON, err := json.Marshal(strip)
-		if err != nil {
-			return nil, err
-		}
-
-		err = ctx.GetStub().PutState(stripID, updatedStripJSON)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Get transaction ID - this uniquely identifies THIS box's creation
+	// Get transaction ID - this uniquely identifies THIS strip's creation
 	txId := ctx.GetStub().GetTxID()
 
 	// Get transaction timestamp for consistency across peers
@@ -237,199 +277,90 @@ func (c *PharmaContract) SealBox(ctx contractapi.TransactionContextInterface, bo
 		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
 	}
 	now := txTimestamp.AsTime()
-	box := Box{
-		DocType:      DocTypeBox,
-		ID:           boxID,
-		Strips:       stripIDs,
-		CartonID:     "",
-		Status:       StatusCreated,
-		CreationTxId: txId, // Store the creation transaction ID (never changes)
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-
-	boxJSON, err := json.Marshal(box)
+	strip := Strip{
+		DocType:          DocTypeStrip,
+		ID:               id,
+		BatchNumber:      batchNumber,
+		GTIN:             product.GTIN,
+		MedicineType:     product.Name,
+		MfgDate:          mfgDate,
+		ExpDate:          expDate,
+		Status:           StatusCreated,
+		CurrentOwner:     mspID,
+		CurrentCustodian: mspID,
+		BoxID:            "",
+		CreationTxId:     txId, // Store the creation transaction ID (never changes)
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	err = c.writeAsset(ctx, id, strip)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(boxID, boxJSON)
+	err = c.emitEvent(ctx, ItemEvent{Name: EventStripsCreated, DocType: DocTypeStrip, ItemIDs: []string{id}, NewStatus: StatusCreated})
 	if err != nil {
 		return nil, err
 	}
 
-	return &box, nil
+	return &strip, nil
 }
 
-// SealCarton creates a carton containing specified boxes
-func (c *PharmaContract) SealCarton(ctx contractapi.TransactionContextInterface, cartonID string, boxIDsJSON string) (*Carton, error) {
-	exists, err := c.assetExists(ctx, cartonID)
-	if err != nil {
+// SealBox creates a box containing specified strips
+func (c *PharmaContract) SealBox(ctx contractapi.TransactionContextInterface, boxID string, stripIDsJSON string) (*Box, error) {
+	if err := c.checkAccess(ctx, "SealBox"); err != nil {
 		return nil, err
 	}
-	if exists {
-		return nil, fmt.Errorf("carton %s already exists", cartonID)
-	}
-
-	var boxIDs []string
-	err = json.Unmarshal([]byte(boxIDsJSON), &boxIDs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse box IDs: %v", err)
-	}
-
-	// Validate and update each box
-	for _, boxID := range boxIDs {
-		boxJSON, err := ctx.GetStub().GetState(boxID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get box %s: %v", boxID, err)
-		}
-		if boxJSON == nil {
-			return nil, fmt.Errorf("box %s does not exist", boxID)
-		}
 
-		var box Box
-		err = json.Unmarshal(boxJSON, &box)
-		if err != nil {
-			return nil, err
-		}
-
-		if box.CartonID != "" {
-			return nil, fmt.Errorf("box %s is already in carton %s", boxID, box.CartonID)
-		}
-
-		box.CartonID = cartonID
-		box.Status = StatusSealed
-		box.UpdatedAt = c.getTxTimestamp(ctx)
-
-		updatedBoxJSON, err := json.Marshal(box)
-		if err != nil {
-			return nil, err
-		}
-
-		err = ctx.GetStub().PutState(boxID, updatedBoxJSON)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Get transaction ID - this uniquely identifies THIS carton's creation
-	txId := ctx.GetStub().GetTxID()
-
-	// Get transaction timestamp for consistency across peers
-	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	var box Box
+	err := c.sealContainer(ctx, DocTypeBox, boxID, stripIDsJSON, &box)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
-	}
-	now := txTimestamp.AsTime()
-	carton := Carton{
-		DocType:      DocTypeCarton,
-		ID:           cartonID,
-		Boxes:        boxIDs,
-		ShipmentID:   "",
-		Status:       StatusCreated,
-		CreationTxId: txId, // Store the creation transaction ID (never changes)
-		CreatedAt:    now,
-		UpdatedAt:    now,
+		return nil, err
 	}
+	return &box, nil
+}
 
-	cartonJSON, err := json.Marshal(carton)
-	if err != nil {
+// SealCarton creates a carton containing specified boxes
+func (c *PharmaContract) SealCarton(ctx contractapi.TransactionContextInterface, cartonID string, boxIDsJSON string) (*Carton, error) {
+	if err := c.checkAccess(ctx, "SealCarton"); err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(cartonID, cartonJSON)
+	var carton Carton
+	err := c.sealContainer(ctx, DocTypeCarton, cartonID, boxIDsJSON, &carton)
 	if err != nil {
 		return nil, err
 	}
-
 	return &carton, nil
 }
 
-// SealShipment creates a shipment containing specified cartons
-func (c *PharmaContract) SealShipment(ctx contractapi.TransactionContextInterface, shipmentID string, cartonIDsJSON string) (*Shipment, error) {
-	exists, err := c.assetExists(ctx, shipmentID)
-	if err != nil {
+// SealShipment creates a shipment containing specified cartons and pallets of cartons
+func (c *PharmaContract) SealShipment(ctx contractapi.TransactionContextInterface, shipmentID string, itemIDsJSON string) (*Shipment, error) {
+	if err := c.checkAccess(ctx, "SealShipment"); err != nil {
 		return nil, err
 	}
-	if exists {
-		return nil, fmt.Errorf("shipment %s already exists", shipmentID)
-	}
-
-	var cartonIDs []string
-	err = json.Unmarshal([]byte(cartonIDsJSON), &cartonIDs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse carton IDs: %v", err)
-	}
-
-	// Validate and update each carton
-	for _, cartonID := range cartonIDs {
-		cartonJSON, err := ctx.GetStub().GetState(cartonID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get carton %s: %v", cartonID, err)
-		}
-		if cartonJSON == nil {
-			return nil, fmt.Errorf("carton %s does not exist", cartonID)
-		}
-
-		var carton Carton
-		err = json.Unmarshal(cartonJSON, &carton)
-		if err != nil {
-			return nil, err
-		}
-
-		if carton.ShipmentID != "" {
-			return nil, fmt.Errorf("carton %s is already in shipment %s", cartonID, carton.ShipmentID)
-		}
-
-		carton.ShipmentID = shipmentID
-		carton.Status = StatusSealed
-		carton.UpdatedAt = c.getTxTimestamp(ctx)
-
-		updatedCartonJSON, err := json.Marshal(carton)
-		if err != nil {
-			return nil, err
-		}
-
-		err = ctx.GetStub().PutState(cartonID, updatedCartonJSON)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Get transaction ID - this uniquely identifies THIS shipment's creation
-	txId := ctx.GetStub().GetTxID()
 
-	// Get transaction timestamp for consistency across peers
-	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	var shipment Shipment
+	err := c.sealContainer(ctx, DocTypeShipment, shipmentID, itemIDsJSON, &shipment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
-	}
-	now := txTimestamp.AsTime()
-	shipment := Shipment{
-		DocType:      DocTypeShipment,
-		ID:           shipmentID,
-		Cartons:      cartonIDs,
-		Status:       StatusCreated,
-		CreationTxId: txId, // Store the creation transaction ID (never changes)
-		CreatedAt:    now,
-		UpdatedAt:    now,
+		return nil, err
 	}
+	return &shipment, nil
+}
 
-	shipmentJSON, err := json.Marshal(shipment)
-	if err != nil {
+// DistributeShipment marks a shipment as shipped, recording the submitting client as its distributor.
+// A shipment allocated to an order leaves with the order through DispatchOrder instead.
+func (c *PharmaContract) DistributeShipment(ctx contractapi.TransactionContextInterface, shipmentID string) (*Shipment, error) {
+	if err := c.checkAccess(ctx, "DistributeShipment"); err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(shipmentID, shipmentJSON)
+	distributorOrg, distributor, err := c.getSubmitter(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &shipment, nil
-}
-
-// DistributeShipment updates shipment with distributor information
-func (c *PharmaContract) DistributeShipment(ctx contractapi.TransactionContextInterface, shipmentID string, distributor string) (*Shipment, error) {
 	shipmentJSON, err := ctx.GetStub().GetState(shipmentID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get shipment: %v", err)
@@ -444,23 +375,40 @@ func (c *PharmaContract) DistributeShipment(ctx contractapi.TransactionContextIn
 		return nil, err
 	}
 
+	if shipment.OrderID != "" {
+		return nil, fmt.Errorf("shipment %s is allocated to order %s and is shipped by dispatching the order", shipmentID, shipment.OrderID)
+	}
+	err = checkTransition(DocTypeShipment, shipmentID, shipment.Status, StatusShipped)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get transaction timestamp for consistency across peers
 	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
 	}
 	now := txTimestamp.AsTime()
+	oldStatus := shipment.Status
 	shipment.Status = StatusShipped
 	shipment.Distributor = distributor
+	shipment.DistributorOrg = distributorOrg
 	shipment.DistributedAt = now
 	shipment.UpdatedAt = now
 
-	updatedJSON, err := json.Marshal(shipment)
+	err = c.writeAsset(ctx, shipmentID, shipment)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(shipmentID, updatedJSON)
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventShipmentDistributed,
+		DocType:   DocTypeShipment,
+		ItemIDs:   []string{shipmentID},
+		OldStatus: oldStatus,
+		NewStatus: StatusShipped,
+		Details:   map[string]string{"distributor": distributor, "distributorOrg": distributorOrg},
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -499,198 +447,99 @@ func (c *PharmaContract) ScanBarcode(ctx contractapi.TransactionContextInterface
 		var strip Strip
 		json.Unmarshal(itemJSON, &strip)
 		result.Item = strip
-		result.Parent, result.GrandParent, result.Root = c.getStripParents(ctx, strip)
 
 	case DocTypeBox:
 		var box Box
 		json.Unmarshal(itemJSON, &box)
 		result.Item = box
-		result.Children = c.getBoxStrips(ctx, box)
-		result.Parent, result.GrandParent = c.getBoxParents(ctx, box)
+		result.Summary = box.Summary
 
 	case DocTypeCarton:
 		var carton Carton
 		json.Unmarshal(itemJSON, &carton)
 		result.Item = carton
-		result.Children = c.getCartonBoxes(ctx, carton)
-		result.Parent = c.getCartonParent(ctx, carton)
+		result.Summary = carton.Summary
+
+	case DocTypePallet:
+		var pallet Pallet
+		json.Unmarshal(itemJSON, &pallet)
+		result.Item = pallet
+		result.Summary = pallet.Summary
 
 	case DocTypeShipment:
 		var shipment Shipment
 		json.Unmarshal(itemJSON, &shipment)
 		result.Item = shipment
-		result.Children = c.getShipmentCartons(ctx, shipment)
+		result.Summary = shipment.Summary
 
 	case DocTypeOrder:
 		var order Order
 		json.Unmarshal(itemJSON, &order)
 		result.Item = order
-		result.Children = c.getOrderItems(ctx, order)
 	}
 
-	return result, nil
-}
-
-// Helper functions for trace
-func (c *PharmaContract) getStripParents(ctx contractapi.TransactionContextInterface, strip Strip) (interface{}, interface{}, interface{}) {
-	if strip.BoxID == "" {
-		return nil, nil, nil
-	}
-
-	boxJSON, _ := ctx.GetStub().GetState(strip.BoxID)
-	if boxJSON == nil {
-		return nil, nil, nil
+	if isContainer(docType) {
+		result.Children = c.getChildDocs(ctx, rawItem)
 	}
 
-	var box Box
-	json.Unmarshal(boxJSON, &box)
-
-	if box.CartonID == "" {
-		return box, nil, nil
-	}
-
-	cartonJSON, _ := ctx.GetStub().GetState(box.CartonID)
-	if cartonJSON == nil {
-		return box, nil, nil
-	}
-
-	var carton Carton
-	json.Unmarshal(cartonJSON, &carton)
-
-	if carton.ShipmentID == "" {
-		return box, carton, nil
-	}
-
-	shipmentJSON, _ := ctx.GetStub().GetState(carton.ShipmentID)
-	if shipmentJSON == nil {
-		return box, carton, nil
+	// Parent and GrandParent are the two innermost containers, Root the outermost one below the order
+	ancestors, err := c.getAncestors(ctx, rawItem)
+	if err != nil {
+		return nil, err
 	}
-
-	var shipment Shipment
-	json.Unmarshal(shipmentJSON, &shipment)
-
-	return box, carton, shipment
-}
-
-func (c *PharmaContract) getBoxStrips(ctx contractapi.TransactionContextInterface, box Box) []Strip {
-	var strips []Strip
-	for _, stripID := range box.Strips {
-		stripJSON, _ := ctx.GetStub().GetState(stripID)
-		if stripJSON != nil {
-			var strip Strip
-			json.Unmarshal(stripJSON, &strip)
-			strips = append(strips, strip)
+	var containers []map[string]interface{}
+	for _, ancestor := range ancestors {
+		if docString(ancestor, "docType") != DocTypeOrder {
+			containers = append(containers, ancestor)
 		}
 	}
-	return strips
-}
-
-func (c *PharmaContract) getBoxParents(ctx contractapi.TransactionContextInterface, box Box) (interface{}, interface{}) {
-	if box.CartonID == "" {
-		return nil, nil
+	if len(containers) > 0 {
+		result.Parent = containers[0]
 	}
-
-	cartonJSON, _ := ctx.GetStub().GetState(box.CartonID)
-	if cartonJSON == nil {
-		return nil, nil
+	if len(containers) > 1 {
+		result.GrandParent = containers[1]
 	}
-
-	var carton Carton
-	json.Unmarshal(cartonJSON, &carton)
-
-	if carton.ShipmentID == "" {
-		return carton, nil
+	if len(containers) > 2 {
+		result.Root = containers[len(containers)-1]
 	}
-
-	shipmentJSON, _ := ctx.GetStub().GetState(carton.ShipmentID)
-	if shipmentJSON == nil {
-		return carton, nil
+	if len(ancestors) > 0 {
+		result.Ancestors = ancestors
 	}
 
-	var shipment Shipment
-	json.Unmarshal(shipmentJSON, &shipment)
-
-	return carton, shipment
-}
-
-func (c *PharmaContract) getCartonBoxes(ctx contractapi.TransactionContextInterface, carton Carton) []Box {
-	var boxes []Box
-	for _, boxID := range carton.Boxes {
-		boxJSON, _ := ctx.GetStub().GetState(boxID)
-		if boxJSON != nil {
-			var box Box
-			json.Unmarshal(boxJSON, &box)
-			boxes = append(boxes, box)
+	// Containers sealed before summaries existed get theirs computed from their strips
+	if result.Summary == nil && isPackagingLevel(docType) {
+		result.Summary, err = c.getContentSummary(ctx, itemID)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return boxes
-}
-
-func (c *PharmaContract) getCartonParent(ctx contractapi.TransactionContextInterface, carton Carton) interface{} {
-	if carton.ShipmentID == "" {
-		return nil
-	}
-
-	shipmentJSON, _ := ctx.GetStub().GetState(carton.ShipmentID)
-	if shipmentJSON == nil {
-		return nil
-	}
 
-	var shipment Shipment
-	json.Unmarshal(shipmentJSON, &shipment)
-
-	return shipment
-}
-
-func (c *PharmaContract) getShipmentCartons(ctx contractapi.TransactionContextInterface, shipment Shipment) []Carton {
-	var cartons []Carton
-	for _, cartonID := range shipment.Cartons {
-		cartonJSON, _ := ctx.GetStub().GetState(cartonID)
-		if cartonJSON != nil {
-			var carton Carton
-			json.Unmarshal(cartonJSON, &carton)
-			cartons = append(cartons, carton)
-		}
-	}
-	return cartons
+	return result, nil
 }
 
-func (c *PharmaContract) getOrderItems(ctx contractapi.TransactionContextInterface, order Order) interface{} {
-	var items []interface{}
-	for _, itemID := range order.ItemIDs {
-		itemJSON, _ := ctx.GetStub().GetState(itemID)
-		if itemJSON != nil {
-			var item map[string]interface{}
-			json.Unmarshal(itemJSON, &item)
-			items = append(items, item)
+// getChildDocs loads the items directly packed in a container
+func (c *PharmaContract) getChildDocs(ctx contractapi.TransactionContextInterface, doc map[string]interface{}) []map[string]interface{} {
+	var children []map[string]interface{}
+	for _, childID := range docChildIDs(doc) {
+		child, err := c.readDoc(ctx, childID)
+		if err == nil {
+			children = append(children, child)
 		}
 	}
-	return items
+	return children
 }
 
 // GetAvailableStrips returns all strips not yet in a box
 func (c *PharmaContract) GetAvailableStrips(ctx contractapi.TransactionContextInterface) ([]*Strip, error) {
-	return c.queryStripsByStatus(ctx, "")
-}
-
-func (c *PharmaContract) queryStripsByStatus(ctx contractapi.TransactionContextInterface, boxID string) ([]*Strip, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"%s","boxId":"%s"}}`, DocTypeStrip, boxID)
-
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	stripDocs, err := c.getUnpackedDocs(ctx, DocTypeStrip)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
 	var strips []*Strip
-	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
+	for _, stripDoc := range stripDocs {
 		var strip Strip
-		err = json.Unmarshal(queryResult.Value, &strip)
+		err = json.Unmarshal(stripDoc, &strip)
 		if err != nil {
 			return nil, err
 		}
@@ -702,23 +551,15 @@ func (c *PharmaContract) queryStripsByStatus(ctx contractapi.TransactionContextI
 
 // GetAvailableBoxes returns all boxes not yet in a carton
 func (c *PharmaContract) GetAvailableBoxes(ctx contractapi.TransactionContextInterface) ([]*Box, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"%s","cartonId":""}}`, DocTypeBox)
-
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	boxDocs, err := c.getUnpackedDocs(ctx, DocTypeBox)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
 	var boxes []*Box
-	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
+	for _, boxDoc := range boxDocs {
 		var box Box
-		err = json.Unmarshal(queryResult.Value, &box)
+		err = json.Unmarshal(boxDoc, &box)
 		if err != nil {
 			return nil, err
 		}
@@ -728,25 +569,17 @@ func (c *PharmaContract) GetAvailableBoxes(ctx contractapi.TransactionContextInt
 	return boxes, nil
 }
 
-// GetAvailableCartons returns all cartons not yet in a shipment
+// GetAvailableCartons returns all cartons not yet in a shipment or on a pallet
 func (c *PharmaContract) GetAvailableCartons(ctx contractapi.TransactionContextInterface) ([]*Carton, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"%s","shipmentId":""}}`, DocTypeCarton)
-
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	cartonDocs, err := c.getUnpackedDocs(ctx, DocTypeCarton)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
 	var cartons []*Carton
-	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
+	for _, cartonDoc := range cartonDocs {
 		var carton Carton
-		err = json.Unmarshal(queryResult.Value, &carton)
+		err = json.Unmarshal(cartonDoc, &carton)
 		if err != nil {
 			return nil, err
 		}
@@ -758,23 +591,15 @@ func (c *PharmaContract) GetAvailableCartons(ctx contractapi.TransactionContextI
 
 // GetAvailableShipments returns all shipments not yet distributed
 func (c *PharmaContract) GetAvailableShipments(ctx contractapi.TransactionContextInterface) ([]*Shipment, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"%s","status":"%s"}}`, DocTypeShipment, StatusCreated)
-
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	shipmentDocs, err := c.getUnpackedDocs(ctx, DocTypeShipment)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
 	var shipments []*Shipment
-	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
+	for _, shipmentDoc := range shipmentDocs {
 		var shipment Shipment
-		err = json.Unmarshal(queryResult.Value, &shipment)
+		err = json.Unmarshal(shipmentDoc, &shipment)
 		if err != nil {
 			return nil, err
 		}
@@ -784,9 +609,79 @@ func (c *PharmaContract) GetAvailableShipments(ctx contractapi.TransactionContex
 	return shipments, nil
 }
 
-// CreateOrder creates a new order for shipments
-// Parameters: orderID, itemIDsJSON (shipment IDs), senderId, senderOrg, receiverId, receiverOrg
-func (c *PharmaContract) CreateOrder(ctx contractapi.TransactionContextInterface, orderID string, itemIDsJSON string, senderId string, senderOrg string, receiverId string, receiverOrg string) (*Order, error) {
+// allocateToOrder puts a shipment or pallet into an order. Allocation is exclusive: the item must not be
+// ordered or distributed already, nor packed in anything. It must also be in the custody of mspID and
+// must not hold recalled batches. It returns the item's doc type.
+func (c *PharmaContract) allocateToOrder(ctx contractapi.TransactionContextInterface, orderID string, itemID string, mspID string, now time.Time) (string, error) {
+	item, err := c.readDoc(ctx, itemID)
+	if err != nil {
+		return "", err
+	}
+
+	docType := docString(item, "docType")
+	if _, ok := findContainment(DocTypeOrder, docType); !ok {
+		return "", fmt.Errorf("item %s is a %s, orders hold shipments or pallets", itemID, docType)
+	}
+	// Checked before the generic parent and status checks below, which would also refuse these items but
+	// without saying which order holds them or that they left through DistributeShipment
+	if otherOrderID := docString(item, "orderId"); otherOrderID != "" {
+		return "", fmt.Errorf("%s %s is already allocated to order %s", docType, itemID, otherOrderID)
+	}
+	if docString(item, "status") == StatusShipped {
+		return "", fmt.Errorf("%s %s was already distributed by %s", docType, itemID, docString(item, "distributor"))
+	}
+	if parentType, parentID := docParent(item); parentID != "" {
+		return "", fmt.Errorf("%s %s is already in %s %s", docType, itemID, parentType, parentID)
+	}
+	err = checkCustody(item, mspID)
+	if err != nil {
+		return "", err
+	}
+
+	err = checkTransition(docType, itemID, docString(item, "status"), StatusInOrder)
+	if err != nil {
+		return "", err
+	}
+	err = checkNotRecalled(item)
+	if err != nil {
+		return "", err
+	}
+
+	// Update the item with orderId for traceability
+	item["orderId"] = orderID
+	item["status"] = StatusInOrder
+	item["updatedAt"] = now
+
+	err = c.writeAsset(ctx, itemID, item)
+	if err != nil {
+		return "", fmt.Errorf("failed to update %s %s: %v", docType, itemID, err)
+	}
+	return docType, nil
+}
+
+// mergeItemType combines the item type of an order so far with the doc type of one more item: an order
+// holding both shipments and pallets is mixed
+func mergeItemType(itemType string, docType string) string {
+	switch itemType {
+	case "", docType:
+		return docType
+	default:
+		return "mixed"
+	}
+}
+
+// CreateOrder creates a new order for shipments and pallets
+// Parameters: orderID, itemIDsJSON (shipment or pallet IDs), receiverId, receiverOrg
+// The sender is the submitting client: its enrollment ID and MSP ID are recorded as senderId and senderOrg.
+func (c *PharmaContract) CreateOrder(ctx contractapi.TransactionContextInterface, orderID string, itemIDsJSON string, receiverId string, receiverOrg string) (*Order, error) {
+	if err := c.checkAccess(ctx, "CreateOrder"); err != nil {
+		return nil, err
+	}
+
+	err := checkItemID(DocTypeOrder, orderID)
+	if err != nil {
+		return nil, err
+	}
 	exists, err := c.assetExists(ctx, orderID)
 	if err != nil {
 		return nil, err
@@ -795,14 +690,14 @@ func (c *PharmaContract) CreateOrder(ctx contractapi.TransactionContextInterface
 		return nil, fmt.Errorf("order %s already exists", orderID)
 	}
 
-	var itemIDs []string
-	err = json.Unmarshal([]byte(itemIDsJSON), &itemIDs)
+	itemIDs, err := parseOrderItemIDs(itemIDsJSON)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse shipment IDs: %v", err)
+		return nil, err
 	}
 
-	if len(itemIDs) == 0 {
-		return nil, fmt.Errorf("at least one shipment must be selected")
+	senderOrg, senderId, err := c.getSubmitter(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get transaction ID and timestamp early for consistency
@@ -813,66 +708,43 @@ func (c *PharmaContract) CreateOrder(ctx contractapi.TransactionContextInterface
 	}
 	now := txTimestamp.AsTime()
 
-	// Validate all items are shipments, exist, and update them with orderId
+	// Validate all items are top-level shipments or pallets, exist, and update them with orderId
+	itemType := ""
 	for _, itemID := range itemIDs {
-		itemJSON, err := ctx.GetStub().GetState(itemID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get shipment %s: %v", itemID, err)
-		}
-		if itemJSON == nil {
-			return nil, fmt.Errorf("shipment %s does not exist", itemID)
-		}
-
-		// Verify it's a shipment
-		var shipment Shipment
-		err = json.Unmarshal(itemJSON, &shipment)
+		docType, err := c.allocateToOrder(ctx, orderID, itemID, senderOrg, now)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse shipment %s: %v", itemID, err)
-		}
-		if shipment.DocType != DocTypeShipment {
-			return nil, fmt.Errorf("item %s is not a shipment", itemID)
-		}
-
-		// Update shipment with orderId for traceability
-		shipment.OrderID = orderID
-		shipment.Status = StatusInOrder
-		shipment.UpdatedAt = now
-
-		updatedShipmentJSON, err := json.Marshal(shipment)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal shipment %s: %v", itemID, err)
-		}
-		err = ctx.GetStub().PutState(itemID, updatedShipmentJSON)
-		if err != nil {
-			return nil, fmt.Errorf("failed to update shipment %s: %v", itemID, err)
+			return nil, err
 		}
+		itemType = mergeItemType(itemType, docType)
 	}
 
 	// Create recipient display name from receiver info
 	recipient := fmt.Sprintf("%s (%s)", receiverId, receiverOrg)
 
 	order := Order{
-		DocType:      DocTypeOrder,
-		ID:           orderID,
-		ItemType:     "shipment", // Orders only contain shipments
-		ItemIDs:      itemIDs,
-		SenderId:     senderId,
-		SenderOrg:    senderOrg,
-		ReceiverId:   receiverId,
-		ReceiverOrg:  receiverOrg,
-		Recipient:    recipient,
-		Status:       StatusCreated,
-		CreationTxId: txId, // Store the creation transaction ID (never changes)
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-
-	orderJSON, err := json.Marshal(order)
+		DocType:          DocTypeOrder,
+		ID:               orderID,
+		ItemType:         itemType,
+		ItemIDs:          itemIDs,
+		SenderId:         senderId,
+		SenderOrg:        senderOrg,
+		ReceiverId:       receiverId,
+		ReceiverOrg:      receiverOrg,
+		Recipient:        recipient,
+		Status:           StatusCreated,
+		CurrentOwner:     senderOrg,
+		CurrentCustodian: senderOrg,
+		CreationTxId:     txId, // Store the creation transaction ID (never changes)
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	err = c.writeAsset(ctx, orderID, order)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(orderID, orderJSON)
+	err = c.emitEvent(ctx, ItemEvent{Name: EventOrderCreated, DocType: DocTypeOrder, ItemIDs: []string{orderID}, NewStatus: StatusCreated, ChildIDs: itemIDs})
 	if err != nil {
 		return nil, err
 	}
@@ -880,8 +752,13 @@ func (c *PharmaContract) CreateOrder(ctx contractapi.TransactionContextInterface
 	return &order, nil
 }
 
-// DispatchOrder marks an order as dispatched
+// DispatchOrder marks an order as dispatched. Only the organization holding the order, its sender unless
+// custody has been transferred, can dispatch it, and not while a transfer of it is pending.
 func (c *PharmaContract) DispatchOrder(ctx contractapi.TransactionContextInterface, orderID string) (*Order, error) {
+	if err := c.checkAccess(ctx, "DispatchOrder"); err != nil {
+		return nil, err
+	}
+
 	orderJSON, err := ctx.GetStub().GetState(orderID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get order: %v", err)
@@ -896,22 +773,72 @@ func (c *PharmaContract) DispatchOrder(ctx contractapi.TransactionContextInterfa
 		return nil, err
 	}
 
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if order.PendingTransfer != "" {
+		return nil, fmt.Errorf("order %s is awaiting transfer %s", orderID, order.PendingTransfer)
+	}
+	holder := order.CurrentCustodian
+	if holder == "" {
+		holder = order.CurrentOwner
+	}
+	if holder == "" {
+		holder = order.SenderOrg
+	}
+	if mspID != holder {
+		return nil, fmt.Errorf("order %s is held by %s and can only be dispatched by it, not %s", orderID, holder, mspID)
+	}
+
+	err = checkTransition(DocTypeOrder, orderID, order.Status, StatusDispatched)
+	if err != nil {
+		return nil, err
+	}
+	if len(order.RecalledBatches) > 0 {
+		return nil, fmt.Errorf("order %s contains recalled batches %v and cannot be dispatched", orderID, order.RecalledBatches)
+	}
+
+	// Recalled strips must not leave even if a container they were repacked into lost the flag
+	err = c.checkContentsRecalled(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	err = c.checkContentsShelfLife(ctx, orderID, c.getTxTimestamp(ctx))
+	if err != nil {
+		return nil, err
+	}
+
 	// Get transaction timestamp for consistency across peers
 	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
 	}
 	now := txTimestamp.AsTime()
+	oldStatus := order.Status
 	order.Status = StatusDispatched
 	order.DispatchedAt = now
 	order.UpdatedAt = now
 
-	updatedJSON, err := json.Marshal(order)
+	// Propagate the new status to every unit packed in this order
+	err = c.cascadeOrderStatus(ctx, &order, StatusDispatched, "", now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.writeAsset(ctx, orderID, order)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(orderID, updatedJSON)
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventOrderDispatched,
+		DocType:   DocTypeOrder,
+		ItemIDs:   []string{orderID},
+		OldStatus: oldStatus,
+		NewStatus: StatusDispatched,
+		ChildIDs:  order.ItemIDs,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -919,8 +846,14 @@ func (c *PharmaContract) DispatchOrder(ctx contractapi.TransactionContextInterfa
 	return &order, nil
 }
 
-// DeliverOrder marks an order as delivered
+// DeliverOrder marks an order as delivered in full, passing the order and everything in it to the custody
+// and ownership of the receiving organization. Only the receiver can confirm delivery; use ReceiveOrder to
+// record what actually arrived.
 func (c *PharmaContract) DeliverOrder(ctx contractapi.TransactionContextInterface, orderID string) (*Order, error) {
+	if err := c.checkAccess(ctx, "DeliverOrder"); err != nil {
+		return nil, err
+	}
+
 	orderJSON, err := ctx.GetStub().GetState(orderID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get order: %v", err)
@@ -935,22 +868,51 @@ func (c *PharmaContract) DeliverOrder(ctx contractapi.TransactionContextInterfac
 		return nil, err
 	}
 
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != order.ReceiverOrg {
+		return nil, fmt.Errorf("order %s can only be received by %s, not %s", orderID, order.ReceiverOrg, mspID)
+	}
+
+	err = checkTransition(DocTypeOrder, orderID, order.Status, StatusDelivered)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get transaction timestamp for consistency across peers
 	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
 	}
 	now := txTimestamp.AsTime()
+	oldStatus := order.Status
 	order.Status = StatusDelivered
+	order.CurrentOwner = order.ReceiverOrg
+	order.CurrentCustodian = order.ReceiverOrg
 	order.DeliveredAt = now
 	order.UpdatedAt = now
 
-	updatedJSON, err := json.Marshal(order)
+	// Propagate the new status and holder to every unit packed in this order
+	err = c.cascadeOrderStatus(ctx, &order, StatusDelivered, order.ReceiverOrg, now)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(orderID, updatedJSON)
+	err = c.writeAsset(ctx, orderID, order)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventOrderDelivered,
+		DocType:   DocTypeOrder,
+		ItemIDs:   []string{orderID},
+		OldStatus: oldStatus,
+		NewStatus: StatusDelivered,
+		ChildIDs:  order.ItemIDs,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -979,23 +941,15 @@ func (c *PharmaContract) GetOrder(ctx contractapi.TransactionContextInterface, o
 
 // GetAllOrders retrieves all orders
 func (c *PharmaContract) GetAllOrders(ctx contractapi.TransactionContextInterface) ([]*Order, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"%s"}}`, DocTypeOrder)
-
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	orderDocs, err := c.getIndexedDocs(ctx, IndexDocTypeStatus, DocTypeOrder)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
 	var orders []*Order
-	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
+	for _, orderDoc := range orderDocs {
 		var order Order
-		err = json.Unmarshal(queryResult.Value, &order)
+		err = json.Unmarshal(orderDoc, &order)
 		if err != nil {
 			return nil, err
 		}
@@ -1010,25 +964,17 @@ func (c *PharmaContract) GetAllOrders(ctx contractapi.TransactionContextInterfac
 	return orders, nil
 }
 
-// GetOrdersByRecipient retrieves orders for a specific recipient
+// GetOrdersByRecipient retrieves orders for a specific recipient, read through the recipient~order index
 func (c *PharmaContract) GetOrdersByRecipient(ctx contractapi.TransactionContextInterface, recipient string) ([]*Order, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"%s","recipient":"%s"}}`, DocTypeOrder, recipient)
-
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	orderDocs, err := c.getIndexedDocs(ctx, IndexRecipient, recipient)
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
 	var orders []*Order
-	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
+	for _, orderDoc := range orderDocs {
 		var order Order
-		err = json.Unmarshal(queryResult.Value, &order)
+		err = json.Unmarshal(orderDoc, &order)
 		if err != nil {
 			return nil, err
 		}
@@ -1070,25 +1016,25 @@ func (c *PharmaContract) GetTransactionHistory(ctx contractapi.TransactionContex
 	return history, nil
 }
 
-// GetAllItems returns all items of a specific type
+// GetAllItems returns all items of a specific type. Items are read through the docType~status~id index;
+// configuration doc types are not indexed and are only found on CouchDB.
 func (c *PharmaContract) GetAllItems(ctx contractapi.TransactionContextInterface, docType string) ([]interface{}, error) {
-	queryString := fmt.Sprintf(`{"selector":{"docType":"%s"}}`, docType)
-
-	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	var itemDocs [][]byte
+	var err error
+	if containsString(queryableDocTypes(), docType) {
+		itemDocs, err = c.getIndexedDocs(ctx, IndexDocTypeStatus, docType)
+	} else {
+		// Configuration documents are not indexed and need CouchDB
+		itemDocs, err = c.getQueryResultForSelector(ctx, map[string]interface{}{"docType": docType})
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
 
 	var items []interface{}
-	for resultsIterator.HasNext() {
-		queryResult, err := resultsIterator.Next()
-		if err != nil {
-			return nil, err
-		}
-
+	for _, itemDoc := range itemDocs {
 		var item interface{}
-		err = json.Unmarshal(queryResult.Value, &item)
+		err = json.Unmarshal(itemDoc, &item)
 		if err != nil {
 			return nil, err
 		}
@@ -1098,39 +1044,25 @@ func (c *PharmaContract) GetAllItems(ctx contractapi.TransactionContextInterface
 	return items, nil
 }
 
-// GetStatistics returns counts for all item types
-func (c *PharmaContract) GetStatistics(ctx contractapi.TransactionContextInterface) (map[string]int, error) {
-	stats := map[string]int{
-		"strips":    0,
-		"boxes":     0,
-		"cartons":   0,
-		"shipments": 0,
-		"orders":    0,
-	}
-
-	docTypes := []string{DocTypeStrip, DocTypeBox, DocTypeCarton, DocTypeShipment, DocTypeOrder}
-	keys := []string{"strips", "boxes", "cartons", "shipments", "orders"}
-
-	for i, docType := range docTypes {
-		queryString := fmt.Sprintf(`{"selector":{"docType":"%s"}}`, docType)
-		resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
-		if err != nil {
-			continue
-		}
+// reservedKeyPrefixes are the key prefixes of the records stored alongside items (see batchID, productID,
+// recallID, receiptID, packingRulesID, ssccConfigID and transferID)
+var reservedKeyPrefixes = []string{"BATCH_", "PRODUCT_", "RECALL_", "RECEIPT_", "PACKING_RULES_", "SSCC_CONFIG_", "TRANSFER_"}
 
-		count := 0
-		for resultsIterator.HasNext() {
-			_, err := resultsIterator.Next()
-			if err != nil {
-				break
-			}
-			count++
+// checkItemID rejects a client-chosen ID for an item of docType that is empty or could squat a record key
+// or a composite key
+func checkItemID(docType string, id string) error {
+	if id == "" {
+		return fmt.Errorf("%s ID is required", docType)
+	}
+	if strings.ContainsRune(id, 0) {
+		return fmt.Errorf("%s ID %q must not contain a null character", docType, id)
+	}
+	for _, prefix := range reservedKeyPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return fmt.Errorf("%s ID %s uses the reserved prefix %s", docType, id, prefix)
 		}
-		resultsIterator.Close()
-		stats[keys[i]] = count
 	}
-
-	return stats, nil
+	return nil
 }
 
 // Helper function to check if an asset exists
@@ -1142,6 +1074,94 @@ func (c *PharmaContract) assetExists(ctx contractapi.TransactionContextInterface
 	return assetJSON != nil, nil
 }
 
+// readAsset loads the item stored under id into v
+func (c *PharmaContract) readAsset(ctx contractapi.TransactionContextInterface, id string, v interface{}) error {
+	assetJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return fmt.Errorf("failed to get item %s: %v", id, err)
+	}
+	if assetJSON == nil {
+		return fmt.Errorf("item %s does not exist", id)
+	}
+	err = json.Unmarshal(assetJSON, v)
+	if err != nil {
+		return fmt.Errorf("failed to parse item %s: %v", id, err)
+	}
+	return nil
+}
+
+// writeAsset stores v under id and updates the secondary indexes and statistics counters. They follow
+// the document as the transaction left it, so an item written twice in one transaction is indexed and
+// counted once; this needs the PharmaTransactionContext the chaincode is started with.
+func (c *PharmaContract) writeAsset(ctx contractapi.TransactionContextInterface, id string, v interface{}) error {
+	assetJSON, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal item %s: %v", id, err)
+	}
+	committedJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return fmt.Errorf("failed to read item %s: %v", id, err)
+	}
+	var writtenJSON []byte
+	tracker, tracked := ctx.(docWriteTracker)
+	if tracked {
+		writtenJSON, _ = tracker.writtenDoc(id)
+	}
+
+	err = ctx.GetStub().PutState(id, assetJSON)
+	if err != nil {
+		return fmt.Errorf("failed to update item %s: %v", id, err)
+	}
+	if tracked {
+		tracker.recordWrite(id, assetJSON)
+	}
+
+	oldJSON := committedJSON
+	if writtenJSON != nil {
+		oldJSON = writtenJSON
+	}
+	err = c.updateIndexes(ctx, id, oldJSON, assetJSON)
+	if err != nil {
+		return err
+	}
+	return c.recordStatistics(ctx, id, committedJSON, writtenJSON, assetJSON)
+}
+
+// selectorQuery encodes a CouchDB query for selector. Values are JSON-encoded, never spliced into the
+// query text, so caller input cannot change the shape of the selector.
+func selectorQuery(selector map[string]interface{}) (string, error) {
+	queryJSON, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", fmt.Errorf("failed to build query: %v", err)
+	}
+	return string(queryJSON), nil
+}
+
+// getQueryResultForSelector runs a CouchDB rich query for selector and returns the matching documents
+func (c *PharmaContract) getQueryResultForSelector(ctx contractapi.TransactionContextInterface, selector map[string]interface{}) ([][]byte, error) {
+	queryString, err := selectorQuery(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var results [][]byte
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, queryResult.Value)
+	}
+
+	return results, nil
+}
+
 // GetItem retrieves any item by ID
 func (c *PharmaContract) GetItem(ctx contractapi.TransactionContextInterface, id string) (interface{}, error) {
 	itemJSON, err := ctx.GetStub().GetState(id)
@@ -1164,35 +1184,28 @@ func (c *PharmaContract) GetItem(ctx contractapi.TransactionContextInterface, id
 // SearchItems searches items by partial ID match
 func (c *PharmaContract) SearchItems(ctx contractapi.TransactionContextInterface, searchTerm string) ([]interface{}, error) {
 	// Search across all doc types
-	docTypes := []string{DocTypeStrip, DocTypeBox, DocTypeCarton, DocTypeShipment, DocTypeOrder}
+	docTypes := itemDocTypes()
 
 	var results []interface{}
 	searchLower := strings.ToLower(searchTerm)
 
 	for _, docType := range docTypes {
-		queryString := fmt.Sprintf(`{"selector":{"docType":"%s"}}`, docType)
-		resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+		// Match on the IDs in the index keys and only read the documents that match
+		ids, err := c.getIndexedIDs(ctx, IndexDocTypeStatus, docType)
 		if err != nil {
-			continue
+			return nil, err
 		}
 
-		for resultsIterator.HasNext() {
-			queryResult, err := resultsIterator.Next()
-			if err != nil {
-				break
+		for _, id := range ids {
+			if !strings.Contains(strings.ToLower(id), searchLower) {
+				continue
 			}
-
-			var item map[string]interface{}
-			json.Unmarshal(queryResult.Value, &item)
-
-			// Check if ID contains search term
-			if id, ok := item["id"].(string); ok {
-				if strings.Contains(strings.ToLower(id), searchLower) {
-					results = append(results, item)
-				}
+			item, err := c.readDoc(ctx, id)
+			if err != nil {
+				return nil, err
 			}
+			results = append(results, item)
 		}
-		resultsIterator.Close()
 	}
 
 	return results, nil
@@ -1222,6 +1235,7 @@ type BlockchainTraceResult struct {
 type TxHashTraceResult struct {
 	TransactionInfo map[string]interface{} `json:"transactionInfo"`
 	Traceability    *BlockchainTraceResult `json:"traceability"`
+	CreatedItemIDs  []string               `json:"createdItemIds,omitempty"` // Every item the transaction created, in ID order
 }
 
 // getLatestFromBlockchain fetches the latest value for a key from blockchain history
@@ -1286,261 +1300,43 @@ func (c *PharmaContract) getBlockchainItemData(ctx contractapi.TransactionContex
 	}, nil
 }
 
-// getStripParentsFromBlockchain fetches strip's parents from blockchain
-func (c *PharmaContract) getStripParentsFromBlockchain(ctx contractapi.TransactionContextInterface, stripData map[string]interface{}) ([]*BlockchainItemData, error) {
-	parents := []*BlockchainItemData{}
-
-	boxID, _ := stripData["boxId"].(string)
-	if boxID == "" {
-		return parents, nil
-	}
-
-	// Get Box
-	boxData, err := c.getBlockchainItemData(ctx, boxID)
-	if err != nil {
-		return parents, nil
-	}
-	parents = append(parents, boxData)
-
-	// Get Carton from Box
-	boxCurrent, _ := boxData.Current.(map[string]interface{})
-	cartonID, _ := boxCurrent["cartonId"].(string)
-	if cartonID == "" {
-		return parents, nil
-	}
-
-	cartonData, err := c.getBlockchainItemData(ctx, cartonID)
-	if err != nil {
-		return parents, nil
-	}
-	parents = append(parents, cartonData)
-
-	// Get Shipment from Carton
-	cartonCurrent, _ := cartonData.Current.(map[string]interface{})
-	shipmentID, _ := cartonCurrent["shipmentId"].(string)
-	if shipmentID == "" {
-		return parents, nil
-	}
-
-	shipmentData, err := c.getBlockchainItemData(ctx, shipmentID)
-	if err != nil {
-		return parents, nil
-	}
-	parents = append(parents, shipmentData)
-
-	// Get Order from Shipment
-	shipmentCurrent, _ := shipmentData.Current.(map[string]interface{})
-	orderID, _ := shipmentCurrent["orderId"].(string)
-	if orderID != "" {
-		orderData, err := c.getBlockchainItemData(ctx, orderID)
-		if err == nil {
-			parents = append(parents, orderData)
-		}
-	}
-
-	return parents, nil
-}
-
-// getBoxChildrenFromBlockchain fetches box's children (strips) from blockchain
-func (c *PharmaContract) getBoxChildrenFromBlockchain(ctx contractapi.TransactionContextInterface, boxData map[string]interface{}) ([]*BlockchainItemData, error) {
-	children := []*BlockchainItemData{}
-
-	stripsInterface, ok := boxData["strips"].([]interface{})
-	if !ok {
-		return children, nil
-	}
-
-	for _, stripIDInterface := range stripsInterface {
-		stripID, ok := stripIDInterface.(string)
-		if !ok {
-			continue
-		}
-		stripData, err := c.getBlockchainItemData(ctx, stripID)
-		if err != nil {
-			continue
-		}
-		children = append(children, stripData)
-	}
-
-	return children, nil
-}
-
-// getBoxParentsFromBlockchain fetches box's parents from blockchain
-func (c *PharmaContract) getBoxParentsFromBlockchain(ctx contractapi.TransactionContextInterface, boxData map[string]interface{}) ([]*BlockchainItemData, error) {
+// getParentsFromBlockchain fetches the containers an item is packed in, innermost first, up to its order
+func (c *PharmaContract) getParentsFromBlockchain(ctx contractapi.TransactionContextInterface, itemData map[string]interface{}) ([]*BlockchainItemData, error) {
 	parents := []*BlockchainItemData{}
+	seen := map[string]bool{}
 
-	cartonID, _ := boxData["cartonId"].(string)
-	if cartonID == "" {
-		return parents, nil
-	}
-
-	cartonData, err := c.getBlockchainItemData(ctx, cartonID)
-	if err != nil {
-		return parents, nil
-	}
-	parents = append(parents, cartonData)
-
-	cartonCurrent, _ := cartonData.Current.(map[string]interface{})
-	shipmentID, _ := cartonCurrent["shipmentId"].(string)
-	if shipmentID == "" {
-		return parents, nil
-	}
-
-	shipmentData, err := c.getBlockchainItemData(ctx, shipmentID)
-	if err != nil {
-		return parents, nil
-	}
-	parents = append(parents, shipmentData)
-
-	// Get Order from Shipment
-	shipmentCurrent, _ := shipmentData.Current.(map[string]interface{})
-	orderID, _ := shipmentCurrent["orderId"].(string)
-	if orderID != "" {
-		orderData, err := c.getBlockchainItemData(ctx, orderID)
-		if err == nil {
-			parents = append(parents, orderData)
-		}
-	}
-
-	return parents, nil
-}
-
-// getCartonChildrenFromBlockchain fetches carton's children (boxes with their strips) from blockchain
-func (c *PharmaContract) getCartonChildrenFromBlockchain(ctx contractapi.TransactionContextInterface, cartonData map[string]interface{}) ([]*BlockchainItemData, error) {
-	children := []*BlockchainItemData{}
-
-	boxesInterface, ok := cartonData["boxes"].([]interface{})
-	if !ok {
-		return children, nil
-	}
-
-	for _, boxIDInterface := range boxesInterface {
-		boxID, ok := boxIDInterface.(string)
-		if !ok {
-			continue
-		}
-		boxData, err := c.getBlockchainItemData(ctx, boxID)
-		if err != nil {
-			continue
+	for {
+		_, parentID := docParent(itemData)
+		if parentID == "" || seen[parentID] {
+			return parents, nil
 		}
-		children = append(children, boxData)
-
-		// Also get strips for this box
-		boxCurrent, _ := boxData.Current.(map[string]interface{})
-		stripChildren, _ := c.getBoxChildrenFromBlockchain(ctx, boxCurrent)
-		children = append(children, stripChildren...)
-	}
-
-	return children, nil
-}
-
-// getCartonParentsFromBlockchain fetches carton's parents from blockchain
-func (c *PharmaContract) getCartonParentsFromBlockchain(ctx contractapi.TransactionContextInterface, cartonData map[string]interface{}) ([]*BlockchainItemData, error) {
-	parents := []*BlockchainItemData{}
-
-	shipmentID, _ := cartonData["shipmentId"].(string)
-	if shipmentID == "" {
-		return parents, nil
-	}
-
-	shipmentData, err := c.getBlockchainItemData(ctx, shipmentID)
-	if err != nil {
-		return parents, nil
-	}
-	parents = append(parents, shipmentData)
-
-	// Get Order from Shipment
-	shipmentCurrent, _ := shipmentData.Current.(map[string]interface{})
-	orderID, _ := shipmentCurrent["orderId"].(string)
-	if orderID == "" {
-		return parents, nil
-	}
-
-	orderData, err := c.getBlockchainItemData(ctx, orderID)
-	if err != nil {
-		return parents, nil
-	}
-	parents = append(parents, orderData)
-
-	return parents, nil
-}
+		seen[parentID] = true
 
-// getShipmentParentsFromBlockchain fetches shipment's parents (order) from blockchain
-func (c *PharmaContract) getShipmentParentsFromBlockchain(ctx contractapi.TransactionContextInterface, shipmentData map[string]interface{}) ([]*BlockchainItemData, error) {
-	parents := []*BlockchainItemData{}
-
-	orderID, _ := shipmentData["orderId"].(string)
-	if orderID == "" {
-		return parents, nil
-	}
-
-	orderData, err := c.getBlockchainItemData(ctx, orderID)
-	if err != nil {
-		return parents, nil
-	}
-	parents = append(parents, orderData)
-
-	return parents, nil
-}
-
-// getShipmentChildrenFromBlockchain fetches shipment's children (cartons, boxes, strips) from blockchain
-func (c *PharmaContract) getShipmentChildrenFromBlockchain(ctx contractapi.TransactionContextInterface, shipmentData map[string]interface{}) ([]*BlockchainItemData, error) {
-	children := []*BlockchainItemData{}
-
-	cartonsInterface, ok := shipmentData["cartons"].([]interface{})
-	if !ok {
-		return children, nil
-	}
-
-	for _, cartonIDInterface := range cartonsInterface {
-		cartonID, ok := cartonIDInterface.(string)
-		if !ok {
-			continue
-		}
-		cartonData, err := c.getBlockchainItemData(ctx, cartonID)
+		parentData, err := c.getBlockchainItemData(ctx, parentID)
 		if err != nil {
-			continue
+			return parents, nil
 		}
-		children = append(children, cartonData)
+		parents = append(parents, parentData)
 
-		// Also get boxes and strips for this carton
-		cartonCurrent, _ := cartonData.Current.(map[string]interface{})
-		cartonChildren, _ := c.getCartonChildrenFromBlockchain(ctx, cartonCurrent)
-		children = append(children, cartonChildren...)
+		itemData, _ = parentData.Current.(map[string]interface{})
 	}
-
-	return children, nil
 }
 
-// getOrderChildrenFromBlockchain fetches order's items (shipments) and their full hierarchy from blockchain
-func (c *PharmaContract) getOrderChildrenFromBlockchain(ctx contractapi.TransactionContextInterface, orderData map[string]interface{}) ([]*BlockchainItemData, error) {
+// getChildrenFromBlockchain fetches everything packed in a container from blockchain, each child
+// followed by its own children (e.g. a carton's boxes, each followed by its strips)
+func (c *PharmaContract) getChildrenFromBlockchain(ctx contractapi.TransactionContextInterface, containerData map[string]interface{}) ([]*BlockchainItemData, error) {
 	children := []*BlockchainItemData{}
 
-	itemIDsInterface, ok := orderData["itemIds"].([]interface{})
-	if !ok {
-		return children, nil
-	}
-
-	for _, itemIDInterface := range itemIDsInterface {
-		itemID, ok := itemIDInterface.(string)
-		if !ok {
-			continue
-		}
-		itemData, err := c.getBlockchainItemData(ctx, itemID)
+	for _, childID := range docChildIDs(containerData) {
+		childData, err := c.getBlockchainItemData(ctx, childID)
 		if err != nil {
 			continue
 		}
-		children = append(children, itemData)
+		children = append(children, childData)
 
-		// If the item is a shipment, also get its full hierarchy (cartons, boxes, strips)
-		itemCurrent, _ := itemData.Current.(map[string]interface{})
-		docType, _ := itemCurrent["docType"].(string)
-
-		if docType == DocTypeShipment {
-			// Get all children of this shipment (cartons, boxes, strips)
-			shipmentChildren, _ := c.getShipmentChildrenFromBlockchain(ctx, itemCurrent)
-			children = append(children, shipmentChildren...)
-		}
+		childCurrent, _ := childData.Current.(map[string]interface{})
+		grandchildren, _ := c.getChildrenFromBlockchain(ctx, childCurrent)
+		children = append(children, grandchildren...)
 	}
 
 	return children, nil
@@ -1562,87 +1358,59 @@ func (c *PharmaContract) GetFullTraceFromBlockchain(ctx contractapi.TransactionC
 	}
 
 	current, _ := itemData.Current.(map[string]interface{})
-	docType := itemData.ItemType
-
-	switch docType {
-	case DocTypeStrip:
-		// Strip has no children, only parents
-		parents, _ := c.getStripParentsFromBlockchain(ctx, current)
-		result.Parents = parents
-
-	case DocTypeBox:
-		// Box has strips as children, carton and shipment as parents
-		children, _ := c.getBoxChildrenFromBlockchain(ctx, current)
-		result.Children = children
-		parents, _ := c.getBoxParentsFromBlockchain(ctx, current)
-		result.Parents = parents
 
-	case DocTypeCarton:
-		// Carton has boxes (and their strips) as children, shipment as parent
-		children, _ := c.getCartonChildrenFromBlockchain(ctx, current)
-		result.Children = children
-		parents, _ := c.getCartonParentsFromBlockchain(ctx, current)
-		result.Parents = parents
-
-	case DocTypeShipment:
-		// Shipment has cartons (and their boxes, strips) as children, order as parent
-		children, _ := c.getShipmentChildrenFromBlockchain(ctx, current)
-		result.Children = children
-		parents, _ := c.getShipmentParentsFromBlockchain(ctx, current)
-		result.Parents = parents
-
-	case DocTypeOrder:
-		// Order has items as children
-		children, _ := c.getOrderChildrenFromBlockchain(ctx, current)
+	// Containers have children (and their children, down to strips); anything packed has parents up to its order
+	if isContainer(itemData.ItemType) {
+		children, _ := c.getChildrenFromBlockchain(ctx, current)
 		result.Children = children
 	}
+	parents, _ := c.getParentsFromBlockchain(ctx, current)
+	result.Parents = parents
 
 	return result, nil
 }
 
-// GetItemByCreationTxHash searches for an item by its creationTxId field
-// This is the PRIMARY way to find items by their unique creation transaction hash
-// Each item stores its own creationTxId when created, which never changes
-func (c *PharmaContract) GetItemByCreationTxHash(ctx contractapi.TransactionContextInterface, txHash string) (map[string]interface{}, error) {
-	// Search all item types for matching creationTxId
-	docTypes := []string{DocTypeStrip, DocTypeBox, DocTypeCarton, DocTypeShipment, DocTypeOrder}
+// GetItemsByCreationTxHash returns the items created by a transaction, searched by their creationTxId field,
+// in ID order. Each item stores the creationTxId of the transaction that created it, which never changes.
+// Most transactions create a single item, but CreateStripsBulk creates every strip of the request in one
+// transaction, so they all share its ID.
+func (c *PharmaContract) GetItemsByCreationTxHash(ctx contractapi.TransactionContextInterface, txHash string) ([]map[string]interface{}, error) {
+	ids, err := c.getIndexedIDs(ctx, IndexCreationTx, txHash)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, docType := range docTypes {
-		// Query for items with this creationTxId
-		queryString := fmt.Sprintf(`{"selector":{"docType":"%s","creationTxId":"%s"}}`, docType, txHash)
-		resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	items := []map[string]interface{}{}
+	for _, id := range ids {
+		item, err := c.readDoc(ctx, id)
 		if err != nil {
-			continue
+			return nil, err
 		}
-
-		if resultsIterator.HasNext() {
-			queryResult, err := resultsIterator.Next()
-			resultsIterator.Close()
-			if err != nil {
-				continue
-			}
-
-			var item map[string]interface{}
-			err = json.Unmarshal(queryResult.Value, &item)
-			if err != nil {
-				return nil, err
-			}
-
-			return item, nil
+		if docString(item, "creationTxId") == txHash {
+			items = append(items, item)
 		}
-		resultsIterator.Close()
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no item found with creationTxId %s", txHash)
 	}
 
-	return nil, fmt.Errorf("no item found with creationTxId %s", txHash)
+	return items, nil
 }
 
 // GetTraceByTxHash fetches traceability by searching for a specific transaction hash
-// PRIORITY 1: Search by creationTxId field (unique to each item, never shared)
+// PRIORITY 1: Search by creationTxId field. A transaction that created several items, such as a bulk strip
+// creation, is traced through the first of them by ID and CreatedItemIDs lists them all.
 // PRIORITY 2: Fall back to history search (for backward compatibility with items created before creationTxId was added)
 func (c *PharmaContract) GetTraceByTxHash(ctx contractapi.TransactionContextInterface, txHash string) (*TxHashTraceResult, error) {
-	// FIRST: Try to find by creationTxId (this is the unique creation hash for each item)
-	item, err := c.GetItemByCreationTxHash(ctx, txHash)
-	if err == nil && item != nil {
+	// FIRST: Try to find the items created by the transaction
+	items, err := c.GetItemsByCreationTxHash(ctx, txHash)
+	if err == nil {
+		item := items[0]
+		createdItemIDs := make([]string, 0, len(items))
+		for _, created := range items {
+			createdItemIDs = append(createdItemIDs, docString(created, "id"))
+		}
+
 		// Found the item by its creation transaction ID
 		itemId, _ := item["id"].(string)
 		docType, _ := item["docType"].(string)
@@ -1682,28 +1450,24 @@ func (c *PharmaContract) GetTraceByTxHash(ctx contractapi.TransactionContextInte
 		return &TxHashTraceResult{
 			TransactionInfo: transactionInfo,
 			Traceability:    traceability,
+			CreatedItemIDs:  createdItemIDs,
 		}, nil
 	}
 
 	// FALLBACK: Search transaction history (for items created before creationTxId was added)
 	// Search from largest container to smallest to prioritize parent items
-	docTypes := []string{DocTypeShipment, DocTypeCarton, DocTypeBox, DocTypeStrip, DocTypeOrder}
+	docTypes := []string{DocTypeShipment, DocTypePallet, DocTypeCarton, DocTypeBox, DocTypeStrip, DocTypeOrder}
 
 	for _, docType := range docTypes {
-		queryString := fmt.Sprintf(`{"selector":{"docType":"%s"}}`, docType)
-		resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+		itemIDs, err := c.getIndexedIDs(ctx, IndexDocTypeStatus, docType)
 		if err != nil {
-			continue
+			return nil, err
 		}
 
-		for resultsIterator.HasNext() {
-			queryResult, err := resultsIterator.Next()
-			if err != nil {
-				break
-			}
+		for _, itemID := range itemIDs {
 
 			// Check history of this item for the TxHash
-			historyIterator, err := ctx.GetStub().GetHistoryForKey(queryResult.Key)
+			historyIterator, err := ctx.GetStub().GetHistoryForKey(itemID)
 			if err != nil {
 				continue
 			}
@@ -1717,7 +1481,6 @@ func (c *PharmaContract) GetTraceByTxHash(ctx contractapi.TransactionContextInte
 				// Found the transaction!
 				if record.TxId == txHash {
 					historyIterator.Close()
-					resultsIterator.Close()
 
 					// Get transaction info
 					var txValue interface{}
@@ -1728,14 +1491,14 @@ func (c *PharmaContract) GetTraceByTxHash(ctx contractapi.TransactionContextInte
 					transactionInfo := map[string]interface{}{
 						"txId":      record.TxId,
 						"timestamp": record.Timestamp.AsTime().Format(time.RFC3339),
-						"itemId":    queryResult.Key,
+						"itemId":    itemID,
 						"itemType":  docType,
 						"isDelete":  record.IsDelete,
 						"value":     txValue,
 					}
 
 					// Get full traceability from blockchain
-					traceability, err := c.GetFullTraceFromBlockchain(ctx, queryResult.Key)
+					traceability, err := c.GetFullTraceFromBlockchain(ctx, itemID)
 					if err != nil {
 						return nil, err
 					}
@@ -1748,7 +1511,6 @@ func (c *PharmaContract) GetTraceByTxHash(ctx contractapi.TransactionContextInte
 			}
 			historyIterator.Close()
 		}
-		resultsIterator.Close()
 	}
 
 	return nil, fmt.Errorf("transaction %s not found", txHash)
@@ -1761,7 +1523,10 @@ func (c *PharmaContract) GetItemHistoryFromBlockchain(ctx contractapi.Transactio
 }
 
 func main() {
-	pharmaChaincode, err := contractapi.NewChaincode(&PharmaContract{})
+	pharmaContract := &PharmaContract{}
+	pharmaContract.TransactionContextHandler = new(PharmaTransactionContext)
+
+	pharmaChaincode, err := contractapi.NewChaincode(pharmaContract)
 	if err != nil {
 		fmt.Printf("Error creating pharma chaincode: %v\n", err)
 		return
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Product is the master data record for a medicine, keyed by its GTIN
+type Product struct {
+	DocType           string    `json:"docType"`
+	ID                string    `json:"id"`
+	GTIN              string    `json:"gtin"` // GTIN-14, zero-padded
+	NDC               string    `json:"ndc"`  // National Drug Code, where applicable
+	Name              string    `json:"name"`
+	Strength          string    `json:"strength"`
+	DosageForm        string    `json:"dosageForm"`
+	PackSize          int       `json:"packSize"` // Units (e.g. tablets) per strip
+	StorageConditions string    `json:"storageConditions"`
+	ManufacturerOrg   string    `json:"manufacturerOrg"` // MSP ID of the organization that registered the product
+	CreationTxId      string    `json:"creationTxId"`    // The transaction ID when this product was registered (never changes)
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+// productID returns the ledger key of the product record for a GTIN-14
+func productID(gtin string) string {
+	return "PRODUCT_" + gtin
+}
+
+// getProduct reads the product record for a GTIN in any of the GS1 lengths
+func (c *PharmaContract) getProduct(ctx contractapi.TransactionContextInterface, gtin string) (*Product, error) {
+	gtin, err := normalizeGTIN(gtin)
+	if err != nil {
+		return nil, err
+	}
+
+	var product Product
+	err = c.readAsset(ctx, productID(gtin), &product)
+	if err != nil {
+		return nil, fmt.Errorf("product %s: %v", gtin, err)
+	}
+	return &product, nil
+}
+
+// validateProductFields checks the fields shared by RegisterProduct and UpdateProduct
+func validateProductFields(name string, strength string, dosageForm string, packSize int) error {
+	if strings.TrimSpace(name) == "" || strings.TrimSpace(strength) == "" || strings.TrimSpace(dosageForm) == "" {
+		return fmt.Errorf("product name, strength and dosage form are required")
+	}
+	if packSize <= 0 {
+		return fmt.Errorf("pack size must be positive")
+	}
+	return nil
+}
+
+// checkDuplicateProduct refuses a name, strength and dosage form already registered under a GTIN other than
+// gtin, so the same medicine is not registered twice under different spellings of its name
+func (c *PharmaContract) checkDuplicateProduct(ctx contractapi.TransactionContextInterface, gtin string, name string, strength string, dosageForm string) error {
+	products, err := c.GetAllProducts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, existing := range products {
+		if existing.GTIN != gtin &&
+			strings.EqualFold(strings.TrimSpace(existing.Name), strings.TrimSpace(name)) &&
+			strings.EqualFold(strings.TrimSpace(existing.Strength), strings.TrimSpace(strength)) &&
+			strings.EqualFold(strings.TrimSpace(existing.DosageForm), strings.TrimSpace(dosageForm)) {
+			return fmt.Errorf("%s %s %s is already registered as product %s", name, strength, dosageForm, existing.GTIN)
+		}
+	}
+	return nil
+}
+
+// RegisterProduct adds a medicine to the product master data
+func (c *PharmaContract) RegisterProduct(ctx contractapi.TransactionContextInterface, gtin string, ndc string, name string, strength string, dosageForm string, packSize int, storageConditions string) (*Product, error) {
+	if err := c.checkAccess(ctx, "RegisterProduct"); err != nil {
+		return nil, err
+	}
+
+	gtin, err := normalizeGTIN(gtin)
+	if err != nil {
+		return nil, err
+	}
+	err = validateProductFields(name, strength, dosageForm, packSize)
+	if err != nil {
+		return nil, err
+	}
+
+	id := productID(gtin)
+	exists, err := c.assetExists(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("product %s already exists", gtin)
+	}
+
+	err = c.checkDuplicateProduct(ctx, gtin, name, strength, dosageForm)
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	now := c.getTxTimestamp(ctx)
+	product := Product{
+		DocType:           DocTypeProduct,
+		ID:                id,
+		GTIN:              gtin,
+		NDC:               ndc,
+		Name:              strings.TrimSpace(name),
+		Strength:          strings.TrimSpace(strength),
+		DosageForm:        strings.TrimSpace(dosageForm),
+		PackSize:          packSize,
+		StorageConditions: storageConditions,
+		ManufacturerOrg:   mspID,
+		CreationTxId:      ctx.GetStub().GetTxID(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	err = c.writeAsset(ctx, id, product)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventProductRegistered, DocType: DocTypeProduct, ItemIDs: []string{id}, Details: product})
+	if err != nil {
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+// UpdateProduct changes a product's master data; only the registering organization may do so
+func (c *PharmaContract) UpdateProduct(ctx contractapi.TransactionContextInterface, gtin string, ndc string, name string, strength string, dosageForm string, packSize int, storageConditions string) (*Product, error) {
+	if err := c.checkAccess(ctx, "UpdateProduct"); err != nil {
+		return nil, err
+	}
+
+	product, err := c.getProduct(ctx, gtin)
+	if err != nil {
+		return nil, err
+	}
+	err = validateProductFields(name, strength, dosageForm, packSize)
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID != product.ManufacturerOrg {
+		return nil, fmt.Errorf("product %s belongs to %s, not %s", product.GTIN, product.ManufacturerOrg, mspID)
+	}
+	err = c.checkDuplicateProduct(ctx, product.GTIN, name, strength, dosageForm)
+	if err != nil {
+		return nil, err
+	}
+
+	product.NDC = ndc
+	product.Name = strings.TrimSpace(name)
+	product.Strength = strings.TrimSpace(strength)
+	product.DosageForm = strings.TrimSpace(dosageForm)
+	product.PackSize = packSize
+	product.StorageConditions = storageConditions
+	product.UpdatedAt = c.getTxTimestamp(ctx)
+
+	err = c.writeAsset(ctx, product.ID, product)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventProductUpdated, DocType: DocTypeProduct, ItemIDs: []string{product.ID}, Details: product})
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// GetProduct retrieves a product by GTIN
+func (c *PharmaContract) GetProduct(ctx contractapi.TransactionContextInterface, gtin string) (*Product, error) {
+	return c.getProduct(ctx, gtin)
+}
+
+// GetAllProducts returns every registered product sorted by name
+func (c *PharmaContract) GetAllProducts(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+	productDocs, err := c.getIndexedDocs(ctx, IndexDocTypeStatus, DocTypeProduct)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []*Product{}
+	for _, productDoc := range productDocs {
+		var product Product
+		err = json.Unmarshal(productDoc, &product)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, &product)
+	}
+
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].Name < products[j].Name
+	})
+
+	return products, nil
+}
+
+// GetItemsByProduct returns every strip of a product
+func (c *PharmaContract) GetItemsByProduct(ctx contractapi.TransactionContextInterface, gtin string) ([]*Strip, error) {
+	gtin, err := normalizeGTIN(gtin)
+	if err != nil {
+		return nil, err
+	}
+
+	stripDocs, err := c.getIndexedDocs(ctx, IndexProductStrip, gtin)
+	if err != nil {
+		return nil, err
+	}
+
+	strips := []*Strip{}
+	for _, stripDoc := range stripDocs {
+		var strip Strip
+		err = json.Unmarshal(stripDoc, &strip)
+		if err != nil {
+			return nil, err
+		}
+		strips = append(strips, &strip)
+	}
+
+	return strips, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestRegisterProductDuplicates(t *testing.T) {
+	l := newLedger()
+	setupBatch(t, l, "B1")
+
+	register := func(gtin string, name string, strength string, dosageForm string) error {
+		return submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.RegisterProduct(ctx, gtin, "", name, strength, dosageForm, 10, "")
+			return err
+		})
+	}
+
+	tests := []struct {
+		name string
+		gtin string
+		err  string
+	}{
+		{"same GTIN", testGTIN, "product 00890000000014 already exists"},
+		{"same GTIN-12", "890000000014", "product 00890000000014 already exists"},
+		{"bad check digit", "00890000000019", "invalid GTIN"},
+		{"respelled name", "00890000000021", "is already registered as product 00890000000014"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectError(t, register(tt.gtin, " paracetamol", "500MG", "Tablet"), tt.err)
+		})
+	}
+
+	// Another strength of the same medicine is a different product
+	if err := register("00890000000021", "Paracetamol", "250mg", "tablet"); err != nil {
+		t.Fatal(err)
+	}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateStrip(ctx, "S1", "B1", "paracetamol", "2025-01-01", "2027-01-01")
+		return err
+	})
+	strips := []*Strip{}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		strips, err = contract.GetItemsByProduct(ctx, "890000000014")
+		return err
+	})
+	if len(strips) != 1 || strips[0].ID != "S1" {
+		t.Errorf("strips of %s: %v", testGTIN, strips)
+	}
+}
+
+func TestUpdateProduct(t *testing.T) {
+	l := newLedger()
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.RegisterProduct(ctx, testGTIN, "", "Paracetamol", "500mg", "tablet", 10, "")
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.RegisterProduct(ctx, "00890000000021", "", "Ibuprofen", "200mg", "tablet", 10, "")
+		return err
+	})
+
+	update := func(id *mockIdentity, gtin string, name string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.UpdateProduct(ctx, gtin, "", name, "500mg", "tablet", 20, "below 25C")
+			return err
+		})
+	}
+
+	// A product cannot be renamed onto another registered product, or changed by another organization
+	org2Mfr := &mockIdentity{msp: "Org2MSP", cn: "mfr2", ou: "client", attrs: map[string]string{"role": RoleManufacturer}}
+	expectError(t, update(org2Mfr, testGTIN, "Paracetamol"), "belongs to Org1MSP, not Org2MSP")
+	expectError(t, update(org1Mfr, "00890000000021", "PARACETAMOL "), "is already registered as product 00890000000014")
+
+	// Keeping its own name is not a duplicate
+	if err := update(org1Mfr, testGTIN, "Paracetamol"); err != nil {
+		t.Fatal(err)
+	}
+	if product := getDoc(t, l, productID(testGTIN)); product["packSize"] != 20.0 || product["storageConditions"] != "below 25C" {
+		t.Errorf("product %v", product)
+	}
+	if name, _ := l.lastEvent(t); name != EventProductUpdated {
+		t.Errorf("event %s", name)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// defaultQueryLimit is the page size of QueryItems when the filter sets no limit
+const defaultQueryLimit = 100
+
+// FieldRange bounds a field; unset bounds are ignored. Dates compare as YYYY-MM-DD or RFC 3339 strings.
+type FieldRange struct {
+	Gt  interface{} `json:"gt,omitempty"`
+	Gte interface{} `json:"gte,omitempty"`
+	Lt  interface{} `json:"lt,omitempty"`
+	Lte interface{} `json:"lte,omitempty"`
+}
+
+// SortField orders QueryItems results by one field
+type SortField struct {
+	Field      string `json:"field"`
+	Descending bool   `json:"descending"`
+}
+
+// ItemFilter is the structured filter accepted by QueryItems, e.g.
+// {"docType":"strip","status":"SEALED","equals":{"batchNumber":"B1"},"ranges":{"expDate":{"lt":"2026-01-01"}},
+// "sort":{"field":"expDate"},"limit":50}
+type ItemFilter struct {
+	DocType  string                 `json:"docType"`
+	Status   string                 `json:"status"`
+	Equals   map[string]interface{} `json:"equals"`
+	Ranges   map[string]FieldRange  `json:"ranges"`
+	Sort     *SortField             `json:"sort"`
+	Limit    int32                  `json:"limit"`
+	Bookmark string                 `json:"bookmark"` // From the previous page, empty for the first page
+}
+
+// queryableFields lists the document fields QueryItems may filter on
+var queryableFields = map[string]bool{
+	"id":               true,
+	"docType":          true,
+	"status":           true,
+	"batchNumber":      true,
+	"gtin":             true,
+	"medicineType":     true,
+	"mfgDate":          true,
+	"expDate":          true,
+	"boxId":            true,
+	"cartonId":         true,
+	"palletId":         true,
+	"shipmentId":       true,
+	"orderId":          true,
+	"itemType":         true,
+	"senderId":         true,
+	"senderOrg":        true,
+	"receiverId":       true,
+	"receiverOrg":      true,
+	"recipient":        true,
+	"distributor":      true,
+	"manufacturerOrg":  true,
+	"currentOwner":     true,
+	"currentCustodian": true,
+	"itemId":           true,
+	"fromOrg":          true,
+	"toOrg":            true,
+	"creationTxId":     true,
+	"createdAt":        true,
+	"updatedAt":        true,
+}
+
+// sortIndexes maps each field QueryItems can sort by to the index in META-INF/statedb/couchdb/indexes
+// covering docType and that field. CouchDB only sorts on indexed fields.
+var sortIndexes = map[string]string{
+	"status":      "indexDocTypeStatus",
+	"batchNumber": "indexDocTypeBatch",
+	"gtin":        "indexDocTypeGtin",
+	"expDate":     "indexDocTypeExpDate",
+	"createdAt":   "indexDocTypeCreatedAt",
+	"updatedAt":   "indexDocTypeUpdatedAt",
+}
+
+// queryableDocTypes returns the doc types QueryItems can return
+func queryableDocTypes() []string {
+	return append(itemDocTypes(), DocTypeBatch, DocTypeProduct, DocTypeRecall, DocTypeTransfer, DocTypeReceipt)
+}
+
+// checkQueryValue accepts only plain strings, numbers and booleans, so a value can never carry an operator
+func checkQueryValue(field string, value interface{}) error {
+	switch value.(type) {
+	case string, float64, bool:
+		return nil
+	}
+	return fmt.Errorf("value of %s must be a string, number or boolean", field)
+}
+
+// buildItemQuery turns a filter into a CouchDB query. Every field is checked against the allowlist and
+// every value is matched with an explicit operator.
+func buildItemQuery(filter *ItemFilter) (map[string]interface{}, error) {
+	if filter.DocType == "" {
+		return nil, fmt.Errorf("docType is required")
+	}
+	if !containsString(queryableDocTypes(), filter.DocType) {
+		return nil, fmt.Errorf("cannot query doc type %s", filter.DocType)
+	}
+
+	conditions := map[string]map[string]interface{}{
+		"docType": {"$eq": filter.DocType},
+	}
+	condition := func(field string) (map[string]interface{}, error) {
+		if !queryableFields[field] {
+			return nil, fmt.Errorf("cannot query field %s", field)
+		}
+		if conditions[field] == nil {
+			conditions[field] = map[string]interface{}{}
+		}
+		return conditions[field], nil
+	}
+
+	if filter.Status != "" {
+		conditions["status"] = map[string]interface{}{"$eq": filter.Status}
+	}
+	for field, value := range filter.Equals {
+		cond, err := condition(field)
+		if err != nil {
+			return nil, err
+		}
+		err = checkQueryValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		cond["$eq"] = value
+	}
+	for field, bounds := range filter.Ranges {
+		cond, err := condition(field)
+		if err != nil {
+			return nil, err
+		}
+		for op, value := range map[string]interface{}{"$gt": bounds.Gt, "$gte": bounds.Gte, "$lt": bounds.Lt, "$lte": bounds.Lte} {
+			if value == nil {
+				continue
+			}
+			err = checkQueryValue(field, value)
+			if err != nil {
+				return nil, err
+			}
+			cond[op] = value
+		}
+	}
+
+	selector := map[string]interface{}{}
+	for field, cond := range conditions {
+		selector[field] = cond
+	}
+	query := map[string]interface{}{"selector": selector}
+
+	if filter.Sort != nil {
+		index, ok := sortIndexes[filter.Sort.Field]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %s", filter.Sort.Field)
+		}
+		direction := "asc"
+		if filter.Sort.Descending {
+			direction = "desc"
+		}
+		// The sort must follow the index: docType first, in the same direction
+		query["sort"] = []map[string]string{{"docType": direction}, {filter.Sort.Field: direction}}
+		query["use_index"] = []string{"_design/" + index + "Doc", index}
+	}
+
+	return query, nil
+}
+
+// QueryItems returns one page of the items matching a structured filter (see ItemFilter). The filter is
+// encoded as JSON rather than formatted into the query, and only allowlisted fields can be filtered or
+// sorted on. The limit defaults to 100 and may not exceed 1000. Arbitrary filters and sorts are CouchDB
+// rich queries, so QueryItems needs CouchDB as the state database.
+func (c *PharmaContract) QueryItems(ctx contractapi.TransactionContextInterface, filterJSON string) (*PaginatedQueryResult, error) {
+	var filter ItemFilter
+	err := json.Unmarshal([]byte(filterJSON), &filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse filter: %v", err)
+	}
+
+	query, err := buildItemQuery(&filter)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = defaultQueryLimit
+	}
+	return c.getQueryPage(ctx, query, limit, filter.Bookmark)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildItemQuery(t *testing.T) {
+	filter := &ItemFilter{
+		DocType: DocTypeStrip,
+		Status:  StatusSealed,
+		Equals:  map[string]interface{}{"batchNumber": "B1"},
+		Ranges:  map[string]FieldRange{"expDate": {Gte: "2025-01-01", Lt: "2026-01-01"}},
+		Sort:    &SortField{Field: "expDate", Descending: true},
+	}
+	query, err := buildItemQuery(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"selector":{"batchNumber":{"$eq":"B1"},"docType":{"$eq":"strip"},"expDate":{"$gte":"2025-01-01","$lt":"2026-01-01"},"status":{"$eq":"SEALED"}},` +
+		`"sort":[{"docType":"desc"},{"expDate":"desc"}],"use_index":["_design/indexDocTypeExpDateDoc","indexDocTypeExpDate"]}`
+	if string(queryJSON) != want {
+		t.Errorf("query %s", queryJSON)
+	}
+}
+
+func TestBuildItemQueryRejectsInjection(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		err    string
+	}{
+		{"no doc type", `{}`, "docType is required"},
+		{"doc type breaking out", `{"docType":"strip\"},\"$or\":[{\"docType\":\"order"}`, "cannot query doc type"},
+		{"configuration doc type", `{"docType":"ssccConfig"}`, "cannot query doc type ssccConfig"},
+		{"operator as field", `{"docType":"strip","equals":{"$or":[{"status":"SEALED"}]}}`, "cannot query field $or"},
+		{"unlisted field", `{"docType":"order","equals":{"details.secret":"x"}}`, "cannot query field details.secret"},
+		{"operator as value", `{"docType":"strip","equals":{"batchNumber":{"$ne":""}}}`, "value of batchNumber must be a string, number or boolean"},
+		{"operator as bound", `{"docType":"strip","ranges":{"expDate":{"gt":{"$regex":".*"}}}}`, "value of expDate must be a string, number or boolean"},
+		{"unindexed sort", `{"docType":"strip","sort":{"field":"medicineType"}}`, "cannot sort by medicineType"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter ItemFilter
+			if err := json.Unmarshal([]byte(tt.filter), &filter); err != nil {
+				t.Fatal(err)
+			}
+			_, err := buildItemQuery(&filter)
+			expectError(t, err, tt.err)
+		})
+	}
+}
+
+func TestSortIndexesAreDefined(t *testing.T) {
+	// Every sortable field needs a CouchDB index on docType and that field
+	for field, name := range sortIndexes {
+		indexJSON, err := os.ReadFile(filepath.Join("META-INF", "statedb", "couchdb", "indexes", name+".json"))
+		if err != nil {
+			t.Errorf("sort by %s: %v", field, err)
+			continue
+		}
+		var index struct {
+			Index struct {
+				Fields []string `json:"fields"`
+			} `json:"index"`
+			Ddoc string `json:"ddoc"`
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(indexJSON, &index); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(index.Index.Fields, []string{"docType", field}) || index.Ddoc != name+"Doc" || index.Name != name {
+			t.Errorf("index %s: %+v", name, index)
+		}
+	}
+}
+
+func TestQueryItemsLimit(t *testing.T) {
+	l := newLedger()
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{`{"docType":"strip","limit":1001}`, "page size must be between 1 and 1000"},
+		{`{"docType":"strip","limit":-1}`, "page size must be between 1 and 1000"},
+		{`not json`, "failed to parse filter"},
+		{`{"docType":"strip"}`, "rich queries are not supported by LevelDB"}, // Reaches the stub with the default limit
+	}
+	for _, tt := range tests {
+		expectError(t, submit(t, l, org1Dist, func(ctx *mockCtx) error {
+			_, err := contract.QueryItems(ctx, tt.filter)
+			return err
+		}), tt.err)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Recall records a batch recall and everything it affected at the time it was issued
+type Recall struct {
+	DocType      string        `json:"docType"`
+	ID           string        `json:"id"`
+	BatchNumber  string        `json:"batchNumber"`
+	Reason       string        `json:"reason"`
+	RecalledBy   string        `json:"recalledBy"` // MSP ID of the organization that issued the recall
+	Impact       *RecallImpact `json:"impact"`
+	CreationTxId string        `json:"creationTxId"` // The transaction ID when this recall was issued (never changes)
+	CreatedAt    time.Time     `json:"createdAt"`
+	UpdatedAt    time.Time     `json:"updatedAt"`
+}
+
+// RecallImpact lists the units, containers, orders and receiving organizations holding a batch
+type RecallImpact struct {
+	BatchNumber  string   `json:"batchNumber"`
+	Recalled     bool     `json:"recalled"`
+	StripIDs     []string `json:"stripIds"`
+	BoxIDs       []string `json:"boxIds"`
+	CartonIDs    []string `json:"cartonIds"`
+	PalletIDs    []string `json:"palletIds"`
+	ShipmentIDs  []string `json:"shipmentIds"`
+	OrderIDs     []string `json:"orderIds"`
+	ReceiverOrgs []string `json:"receiverOrgs"`
+}
+
+// recallID returns the ledger key of the recall record for a batch
+func recallID(batchNumber string) string {
+	return "RECALL_" + batchNumber
+}
+
+// appendUnique appends s to list unless it is empty or already present
+func appendUnique(list []string, s string) []string {
+	if s == "" || containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// checkNotRecalled refuses a recalled strip, or a container or order holding recalled batches
+func checkNotRecalled(doc map[string]interface{}) error {
+	docType, id := docString(doc, "docType"), docString(doc, "id")
+	if docType == DocTypeStrip && docString(doc, "status") == StatusRecalled {
+		return fmt.Errorf("strip %s of batch %s has been recalled", id, docString(doc, "batchNumber"))
+	}
+	if recalled := docStrings(doc, "recalledBatches"); len(recalled) > 0 {
+		return fmt.Errorf("%s %s contains recalled batches %v", docType, id, recalled)
+	}
+	return nil
+}
+
+// recalledBatchesOf returns the recalled batches an item holds: the batch of a recalled strip, or the
+// batches a container or order is flagged with
+func recalledBatchesOf(doc map[string]interface{}) []string {
+	if docString(doc, "docType") == DocTypeStrip {
+		if docString(doc, "status") == StatusRecalled {
+			return []string{docString(doc, "batchNumber")}
+		}
+		return nil
+	}
+	return docStrings(doc, "recalledBatches")
+}
+
+// checkContentsRecalled refuses an item if any strip packed under it has been recalled, whether or not
+// its containers carry the flag
+func (c *PharmaContract) checkContentsRecalled(ctx contractapi.TransactionContextInterface, id string) error {
+	stripIDs, err := c.collectStripIDs(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var recalled []string
+	for _, stripID := range stripIDs {
+		strip, err := c.readDoc(ctx, stripID)
+		if err != nil {
+			return err
+		}
+		if docString(strip, "status") == StatusRecalled {
+			recalled = append(recalled, stripID)
+		}
+	}
+	if len(recalled) > 0 {
+		return fmt.Errorf("%s holds recalled strips %v", id, recalled)
+	}
+	return nil
+}
+
+// computeRecallImpact walks every strip of a batch up through its containers to the order holding it
+func (c *PharmaContract) computeRecallImpact(ctx contractapi.TransactionContextInterface, batchNumber string) (*RecallImpact, error) {
+	stripDocs, err := c.getIndexedDocs(ctx, IndexBatchStrip, batchNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query strips for batch %s: %v", batchNumber, err)
+	}
+
+	impact := &RecallImpact{
+		BatchNumber:  batchNumber,
+		StripIDs:     []string{},
+		BoxIDs:       []string{},
+		CartonIDs:    []string{},
+		PalletIDs:    []string{},
+		ShipmentIDs:  []string{},
+		OrderIDs:     []string{},
+		ReceiverOrgs: []string{},
+	}
+
+	visited := map[string]bool{}
+	for _, stripDoc := range stripDocs {
+		var strip map[string]interface{}
+		err = json.Unmarshal(stripDoc, &strip)
+		if err != nil {
+			return nil, err
+		}
+		impact.StripIDs = appendUnique(impact.StripIDs, docString(strip, "id"))
+
+		// Walk up until reaching a container already seen through another strip
+		doc := strip
+		for {
+			parentDocType, parentID := docParent(doc)
+			if parentID == "" || visited[parentID] {
+				break
+			}
+			visited[parentID] = true
+
+			doc, err = c.readDoc(ctx, parentID)
+			if err != nil {
+				return nil, err
+			}
+			switch parentDocType {
+			case DocTypeBox:
+				impact.BoxIDs = append(impact.BoxIDs, parentID)
+			case DocTypeCarton:
+				impact.CartonIDs = append(impact.CartonIDs, parentID)
+			case DocTypePallet:
+				impact.PalletIDs = append(impact.PalletIDs, parentID)
+			case DocTypeShipment:
+				impact.ShipmentIDs = append(impact.ShipmentIDs, parentID)
+			case DocTypeOrder:
+				impact.OrderIDs = append(impact.OrderIDs, parentID)
+				impact.ReceiverOrgs = appendUnique(impact.ReceiverOrgs, docString(doc, "receiverOrg"))
+			}
+		}
+	}
+
+	return impact, nil
+}
+
+// RecallBatch marks every strip of a batch as RECALLED, flags each container and order holding it,
+// and emits a BatchRecalled event
+func (c *PharmaContract) RecallBatch(ctx contractapi.TransactionContextInterface, batchNumber string, reason string) (*Recall, error) {
+	if err := c.checkAccess(ctx, "RecallBatch"); err != nil {
+		return nil, err
+	}
+
+	if batchNumber == "" {
+		return nil, fmt.Errorf("batch number is required")
+	}
+	if reason == "" {
+		return nil, fmt.Errorf("recall reason is required")
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	err = c.checkRecallOwnership(ctx, batchNumber, mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	id := recallID(batchNumber)
+	exists, err := c.assetExists(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("batch %s has already been recalled", batchNumber)
+	}
+
+	impact, err := c.computeRecallImpact(ctx, batchNumber)
+	if err != nil {
+		return nil, err
+	}
+	if len(impact.StripIDs) == 0 {
+		return nil, fmt.Errorf("no strips found for batch %s", batchNumber)
+	}
+	impact.Recalled = true
+
+	txId := ctx.GetStub().GetTxID()
+	now := c.getTxTimestamp(ctx)
+
+	for _, stripID := range impact.StripIDs {
+		var strip Strip
+		err = c.readAsset(ctx, stripID, &strip)
+		if err != nil {
+			return nil, err
+		}
+		err = checkTransition(DocTypeStrip, stripID, strip.Status, StatusRecalled)
+		if err != nil {
+			return nil, err
+		}
+		strip.Status = StatusRecalled
+		strip.UpdatedAt = now
+		err = c.writeAsset(ctx, stripID, strip)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Flag every container and order holding the batch
+	var holderIDs []string
+	for _, ids := range [][]string{impact.BoxIDs, impact.CartonIDs, impact.PalletIDs, impact.ShipmentIDs, impact.OrderIDs} {
+		holderIDs = append(holderIDs, ids...)
+	}
+	for _, holderID := range holderIDs {
+		holder, err := c.readDoc(ctx, holderID)
+		if err != nil {
+			return nil, err
+		}
+		holder["recalledBatches"] = appendUnique(docStrings(holder, "recalledBatches"), batchNumber)
+		holder["updatedAt"] = now
+		err = c.writeAsset(ctx, holderID, holder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Batches registered with CreateBatch are marked recalled so no further strips can be made from them
+	batchExists, err := c.assetExists(ctx, batchID(batchNumber))
+	if err != nil {
+		return nil, err
+	}
+	if batchExists {
+		batch, err := c.getBatch(ctx, batchNumber)
+		if err != nil {
+			return nil, err
+		}
+		err = checkTransition(DocTypeBatch, batchNumber, batch.QCStatus, StatusRecalled)
+		if err != nil {
+			return nil, err
+		}
+		batch.QCStatus = StatusRecalled
+		batch.UpdatedAt = now
+		err = c.writeAsset(ctx, batch.ID, batch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	recall := Recall{
+		DocType:      DocTypeRecall,
+		ID:           id,
+		BatchNumber:  batchNumber,
+		Reason:       reason,
+		RecalledBy:   mspID,
+		Impact:       impact,
+		CreationTxId: txId,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	err = c.writeAsset(ctx, id, recall)
+	if err != nil {
+		return nil, err
+	}
+
+	// The event lists the recalled strips; the recall record with its full impact is in the details
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventBatchRecalled,
+		DocType:   DocTypeStrip,
+		ItemIDs:   impact.StripIDs,
+		NewStatus: StatusRecalled,
+		ChildIDs:  holderIDs,
+		Details:   recall,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &recall, nil
+}
+
+// checkRecallOwnership lets regulators recall any batch, and everyone else only batches registered to their
+// own organization
+func (c *PharmaContract) checkRecallOwnership(ctx contractapi.TransactionContextInterface, batchNumber string, mspID string) error {
+	role, _, err := ctx.GetClientIdentity().GetAttributeValue(RoleAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to read %s attribute: %v", RoleAttribute, err)
+	}
+	if role == RoleRegulator {
+		return nil
+	}
+
+	batch, err := c.getBatch(ctx, batchNumber)
+	if err != nil {
+		return err
+	}
+	if mspID != batch.ManufacturerOrg {
+		return fmt.Errorf("batch %s belongs to %s, only its manufacturer or a regulator can recall it", batchNumber, batch.ManufacturerOrg)
+	}
+	return nil
+}
+
+// GetRecallImpact returns the orders, receiving organizations and containers holding a batch.
+// It can be run before a recall to assess its impact, or after one to follow it up.
+func (c *PharmaContract) GetRecallImpact(ctx contractapi.TransactionContextInterface, batchNumber string) (*RecallImpact, error) {
+	impact, err := c.computeRecallImpact(ctx, batchNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	recalled, err := c.assetExists(ctx, recallID(batchNumber))
+	if err != nil {
+		return nil, err
+	}
+	impact.Recalled = recalled
+
+	return impact, nil
+}
+
+// GetRecall retrieves the recall record for a batch
+func (c *PharmaContract) GetRecall(ctx contractapi.TransactionContextInterface, batchNumber string) (*Recall, error) {
+	var recall Recall
+	err := c.readAsset(ctx, recallID(batchNumber), &recall)
+	if err != nil {
+		return nil, err
+	}
+	return &recall, nil
+}
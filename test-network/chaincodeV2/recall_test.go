@@ -0,0 +1,211 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecallBatch(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildShipment(t, l, "P2", "B2")
+	createOrder(t, l, "O1", "P1-SH")
+
+	var recall *Recall
+	mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+		var err error
+		recall, err = contract.RecallBatch(ctx, "B1", "contamination")
+		return err
+	})
+
+	impact := recall.Impact
+	counts := []struct {
+		name string
+		ids  []string
+		want int
+	}{
+		{"strips", impact.StripIDs, 8},
+		{"boxes", impact.BoxIDs, 4},
+		{"cartons", impact.CartonIDs, 2},
+		{"shipments", impact.ShipmentIDs, 1},
+		{"orders", impact.OrderIDs, 1},
+	}
+	for _, count := range counts {
+		if len(count.ids) != count.want {
+			t.Errorf("%d %s affected, want %d: %v", len(count.ids), count.name, count.want, count.ids)
+		}
+	}
+	if !impact.Recalled || !reflect.DeepEqual(impact.ReceiverOrgs, []string{"Org2MSP"}) || recall.RecalledBy != "Org2MSP" {
+		t.Errorf("impact %+v recalled by %s", impact, recall.RecalledBy)
+	}
+
+	// The recall reaches every strip of the batch and flags everything holding them, and nothing else
+	flags := []struct {
+		id       string
+		status   string
+		recalled bool
+	}{
+		{"P1-S000", StatusRecalled, false},
+		{"P1-S111", StatusRecalled, false},
+		{"P1-B01", StatusSealed, true},
+		{"P1-C1", StatusSealed, true},
+		{"P1-SH", StatusInOrder, true},
+		{"O1", StatusCreated, true},
+		{"P2-S000", StatusSealed, false},
+		{"P2-B00", StatusSealed, false},
+		{"P2-SH", StatusCreated, false},
+	}
+	for _, flag := range flags {
+		doc := getDoc(t, l, flag.id)
+		recalled := reflect.DeepEqual(doc["recalledBatches"], []interface{}{"B1"})
+		if doc["status"] != flag.status || recalled != flag.recalled {
+			t.Errorf("%s is %v with recalled batches %v", flag.id, doc["status"], doc["recalledBatches"])
+		}
+	}
+	if batch := getDoc(t, l, batchID("B1")); batch["qcStatus"] != StatusRecalled {
+		t.Errorf("batch B1 is %v", batch["qcStatus"])
+	}
+
+	name, event := l.lastEvent(t)
+	if name != EventBatchRecalled || len(event.ItemIDs) != 8 || event.NewStatus != StatusRecalled || event.ActorMSP != "Org2MSP" {
+		t.Errorf("event %s %+v", name, event)
+	}
+
+	// Recalled stock can no longer move or be added to
+	refused := []struct {
+		name string
+		id   *mockIdentity
+		fn   func(ctx *mockCtx) error
+		err  string
+	}{
+		{"dispatch", org1Dist, func(ctx *mockCtx) error {
+			_, err := contract.DispatchOrder(ctx, "O1")
+			return err
+		}, "recalled"},
+		{"repack", org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.MoveChild(ctx, "P1-S000", "P2-B00")
+			return err
+		}, "has been recalled"},
+		{"new strip", org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.CreateStrip(ctx, "NEW", "B1", "", "2025-01-01", "2027-01-01")
+			return err
+		}, "RECALLED"},
+		{"recall again", regulator, func(ctx *mockCtx) error {
+			_, err := contract.RecallBatch(ctx, "B1", "again")
+			return err
+		}, "already been recalled"},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			expectError(t, submit(t, l, tt.id, tt.fn), tt.err)
+		})
+	}
+}
+
+func TestRecallBatchChecks(t *testing.T) {
+	l := newLedger()
+	setupBatch(t, l, "B1")
+
+	tests := []struct {
+		batchNumber string
+		reason      string
+		err         string
+	}{
+		{"", "contamination", "batch number is required"},
+		{"B1", "", "recall reason is required"},
+		{"B1", "contamination", "no strips found for batch B1"},
+	}
+	for _, tt := range tests {
+		err := submit(t, l, regulator, func(ctx *mockCtx) error {
+			_, err := contract.RecallBatch(ctx, tt.batchNumber, tt.reason)
+			return err
+		})
+		expectError(t, err, tt.err)
+	}
+	expectError(t, submit(t, l, org2Pharm, func(ctx *mockCtx) error {
+		_, err := contract.RecallBatch(ctx, "B1", "contamination")
+		return err
+	}), "access denied")
+}
+
+func TestRecallBatchOwnership(t *testing.T) {
+	l := newLedger()
+	buildCarton(t, l, "C1", "B1")
+	org2Mfr := &mockIdentity{msp: "Org2MSP", cn: "mfr2", ou: "client", attrs: map[string]string{"role": RoleManufacturer}}
+
+	recall := func(id *mockIdentity, batchNumber string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.RecallBatch(ctx, batchNumber, "contamination")
+			return err
+		})
+	}
+
+	// Manufacturers can only recall their own batches
+	expectError(t, recall(org2Mfr, "B1"), "batch B1 belongs to Org1MSP, only its manufacturer or a regulator can recall it")
+	expectError(t, recall(org2Mfr, "B9"), "batch B9")
+	if status := getDoc(t, l, "C1-S")["status"]; status != StatusSealed {
+		t.Fatalf("C1-S is %v after a refused recall", status)
+	}
+	if err := recall(org1Mfr, "B1"); err != nil {
+		t.Fatal(err)
+	}
+	if name, event := l.lastEvent(t); name != EventBatchRecalled || event.ActorMSP != "Org1MSP" {
+		t.Errorf("event %s %+v", name, event)
+	}
+}
+
+func TestRecallRepack(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildShipment(t, l, "P2", "B2")
+	mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+		_, err := contract.RecallBatch(ctx, "B1", "contamination")
+		return err
+	})
+
+	move := func(childID string, newParentID string) error {
+		return submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.MoveChild(ctx, childID, newParentID)
+			return err
+		})
+	}
+	recalled := func(id string) bool {
+		return getDoc(t, l, id)["recalledBatches"] != nil
+	}
+
+	// Flagged units cannot be packed into clean containers
+	expectError(t, move("P1-S000", "P2-B00"), "has been recalled")
+	expectError(t, move("P1-B00", "P2-C0"), "contains recalled batches")
+
+	// Taking the recalled strips out clears the flag on the containers left without them
+	for _, stripID := range []string{"P1-S000", "P1-S001"} {
+		if err := move(stripID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if recalled("P1-B00") || !recalled("P1-C0") {
+		t.Fatalf("P1-B00 flagged %v, P1-C0 flagged %v", recalled("P1-B00"), recalled("P1-C0"))
+	}
+	expectError(t, submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.SealBox(ctx, "NB", idList("P1-S000"))
+		return err
+	}), "has been recalled")
+
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.UnpackShipment(ctx, "P1-SH")
+		return err
+	})
+	if recalled("P1-SH") || !recalled("P1-C1") {
+		t.Fatalf("P1-SH flagged %v, P1-C1 flagged %v", recalled("P1-SH"), recalled("P1-C1"))
+	}
+
+	// A recalled strip in a tree left unflagged by earlier chaincode versions still blocks dispatch
+	createOrder(t, l, "O1", "P2-SH")
+	strip := getDoc(t, l, "P2-S110")
+	strip["status"] = StatusRecalled
+	putDoc(t, l, "P2-S110", strip)
+	expectError(t, submit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	}), "O1 holds recalled strips [P2-S110]")
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Discrepancy kinds
+const (
+	DiscrepancyShortage = "SHORTAGE" // Part of the order that was not received
+	DiscrepancyOverage  = "OVERAGE"  // Scanned on receipt but not part of the order
+	DiscrepancyDamaged  = "DAMAGED"  // Received but unusable
+)
+
+// Receipt records what the receiving organization actually got for an order and how it differed from
+// what was dispatched. Its status is the outcome of the receipt: DELIVERED, PARTIALLY_DELIVERED or REJECTED.
+type Receipt struct {
+	DocType       string        `json:"docType"`
+	ID            string        `json:"id"`
+	OrderID       string        `json:"orderId"`
+	SenderId      string        `json:"senderId"`
+	SenderOrg     string        `json:"senderOrg"`
+	ReceiverOrg   string        `json:"receiverOrg"`
+	ReceivedBy    string        `json:"receivedBy"` // Enrollment ID of the client that recorded the receipt
+	Status        string        `json:"status"`
+	ScannedIDs    []string      `json:"scannedIds"`
+	DamagedIDs    []string      `json:"damagedIds"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	CreationTxId  string        `json:"creationTxId"` // The transaction ID when the order was received (never changes)
+	CreatedAt     time.Time     `json:"createdAt"`
+	UpdatedAt     time.Time     `json:"updatedAt"`
+}
+
+// Discrepancy is one unit whose receipt differed from the order. Shortages are reported at the outermost
+// unit missing entirely: a missing carton is listed once, not with each of its boxes and strips.
+type Discrepancy struct {
+	Kind        string `json:"kind"`
+	ItemID      string `json:"itemId"`
+	ItemType    string `json:"itemType,omitempty"`    // Empty for scanned IDs unknown to the ledger
+	ContainerID string `json:"containerId,omitempty"` // Where a missing unit should have been: a container or the order
+}
+
+// receiptID returns the ledger key of the receipt of an order
+func receiptID(orderID string) string {
+	return "RECEIPT_" + orderID
+}
+
+// receiving holds the scans of one receipt while the order's contents are reconciled against them
+type receiving struct {
+	scanned       map[string]bool
+	damaged       map[string]bool
+	ids           []string                          // Every unit in the order, in the order visited
+	docs          map[string]map[string]interface{} // The units keyed by ID
+	statuses      map[string]string                 // Status each received unit moves to; missing units have none
+	discrepancies []Discrepancy
+}
+
+// reconcile works out the status of a unit of the order from the scans: received units are DELIVERED,
+// damaged ones DAMAGED, and containers only partly received PARTIALLY_DELIVERED. A unit counts as received
+// when it or a container it arrived in was scanned. It returns an empty status for a unit missing entirely.
+func (c *PharmaContract) reconcile(ctx contractapi.TransactionContextInterface, r *receiving, id string, received bool, damaged bool) (string, error) {
+	doc, err := c.readDoc(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	r.ids = append(r.ids, id)
+	r.docs[id] = doc
+	received = received || r.scanned[id] || r.damaged[id]
+	damaged = damaged || r.damaged[id]
+
+	status := ""
+	childIDs := docChildIDs(doc)
+	if len(childIDs) == 0 {
+		switch {
+		case damaged:
+			status = StatusDamaged
+		case received:
+			status = StatusDelivered
+		}
+	} else {
+		counts := map[string]int{}
+		var missing []string
+		for _, childID := range childIDs {
+			childStatus, err := c.reconcile(ctx, r, childID, received, damaged)
+			if err != nil {
+				return "", err
+			}
+			if childStatus == "" {
+				missing = append(missing, childID)
+			}
+			counts[childStatus]++
+		}
+		switch {
+		case counts[StatusDelivered] == len(childIDs):
+			status = StatusDelivered
+		case counts[StatusDelivered] > 0 || counts[StatusPartiallyDelivered] > 0:
+			status = StatusPartiallyDelivered
+		case counts[StatusDamaged] > 0:
+			status = StatusDamaged
+		}
+		// Children missing from a container that did arrive are shortages; an entirely missing
+		// container is reported by whatever holds it
+		if status != "" {
+			for _, childID := range missing {
+				r.addShortage(childID, id)
+			}
+		}
+	}
+
+	if status != "" {
+		r.statuses[id] = status
+	}
+	return status, nil
+}
+
+// addShortage records a unit of the order that was not received
+func (r *receiving) addShortage(id string, containerID string) {
+	r.discrepancies = append(r.discrepancies, Discrepancy{
+		Kind:        DiscrepancyShortage,
+		ItemID:      id,
+		ItemType:    docString(r.docs[id], "docType"),
+		ContainerID: containerID,
+	})
+}
+
+// ReceiveOrder records the receipt of a dispatched order. Only the receiving organization can receive it,
+// listing in receivedIDsJSON every unit it scanned on arrival (a sealed container stands for everything in
+// it) and in damagedIDsJSON the units that arrived unusable. Units of the order that were not scanned are
+// recorded as shortages and scanned units from elsewhere as overages. The order is DELIVERED when
+// everything arrived intact, REJECTED when nothing did, and PARTIALLY_DELIVERED otherwise. Unless it is
+// rejected, the order and the units that arrived pass to the custody and ownership of the receiver.
+func (c *PharmaContract) ReceiveOrder(ctx contractapi.TransactionContextInterface, orderID string, receivedIDsJSON string, damagedIDsJSON string) (*Receipt, error) {
+	if err := c.checkAccess(ctx, "ReceiveOrder"); err != nil {
+		return nil, err
+	}
+
+	var order Order
+	err := c.readAsset(ctx, orderID, &order)
+	if err != nil {
+		return nil, err
+	}
+	if order.DocType != DocTypeOrder {
+		return nil, fmt.Errorf("%s is not an order", orderID)
+	}
+
+	mspID, enrollmentID, err := c.getSubmitter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mspID != order.ReceiverOrg {
+		return nil, fmt.Errorf("order %s can only be received by %s, not %s", orderID, order.ReceiverOrg, mspID)
+	}
+	if order.Status != StatusDispatched {
+		return nil, fmt.Errorf("order %s is %s, only dispatched orders can be received", orderID, order.Status)
+	}
+
+	scannedIDs, damagedIDs := []string{}, []string{}
+	err = json.Unmarshal([]byte(receivedIDsJSON), &scannedIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse received unit IDs: %v", err)
+	}
+	if damagedIDsJSON != "" {
+		err = json.Unmarshal([]byte(damagedIDsJSON), &damagedIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse damaged unit IDs: %v", err)
+		}
+	}
+
+	r := &receiving{
+		scanned:       map[string]bool{},
+		damaged:       map[string]bool{},
+		docs:          map[string]map[string]interface{}{},
+		statuses:      map[string]string{},
+		discrepancies: []Discrepancy{},
+	}
+	for _, id := range scannedIDs {
+		r.scanned[id] = true
+	}
+	for _, id := range damagedIDs {
+		r.damaged[id] = true
+	}
+
+	// Reconcile the scans against everything dispatched in the order
+	intact := 0
+	for _, itemID := range order.ItemIDs {
+		status, err := c.reconcile(ctx, r, itemID, false, false)
+		if err != nil {
+			return nil, err
+		}
+		switch status {
+		case "":
+			r.addShortage(itemID, orderID)
+		case StatusDelivered, StatusPartiallyDelivered:
+			intact++
+		}
+	}
+	for _, id := range damagedIDs {
+		if r.docs[id] == nil {
+			return nil, fmt.Errorf("damaged unit %s is not part of order %s", id, orderID)
+		}
+		// Report a damaged container once rather than with each unit in it
+		if !r.insideDamaged(id) {
+			r.discrepancies = append(r.discrepancies, Discrepancy{Kind: DiscrepancyDamaged, ItemID: id, ItemType: docString(r.docs[id], "docType")})
+		}
+	}
+	for _, id := range scannedIDs {
+		if r.docs[id] != nil {
+			continue
+		}
+		overage := Discrepancy{Kind: DiscrepancyOverage, ItemID: id}
+		var doc map[string]interface{}
+		if c.readAsset(ctx, id, &doc) == nil {
+			overage.ItemType = docString(doc, "docType")
+		}
+		r.discrepancies = append(r.discrepancies, overage)
+	}
+
+	outcome := StatusPartiallyDelivered
+	switch {
+	case intact == 0:
+		outcome = StatusRejected
+	case len(r.discrepancies) == 0 || onlyOverages(r.discrepancies):
+		outcome = StatusDelivered
+	}
+	err = checkTransition(DocTypeOrder, orderID, order.Status, outcome)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each unit takes the status it was received in, or REJECTED when the order is refused as a whole;
+	// missing units were never received and stay DISPATCHED either way
+	now := c.getTxTimestamp(ctx)
+	for _, id := range r.ids {
+		doc := r.docs[id]
+		status := r.statuses[id]
+		if outcome == StatusRejected && status != "" {
+			status = StatusRejected
+		}
+		// A recalled strip keeps its RECALLED status wherever its container goes
+		if status == "" || docString(doc, "status") == StatusRecalled {
+			continue
+		}
+		docType := docString(doc, "docType")
+		err = checkTransition(docType, id, docString(doc, "status"), status)
+		if err != nil {
+			return nil, err
+		}
+		doc["status"] = status
+		if outcome != StatusRejected {
+			handOver(doc, order.ReceiverOrg)
+		}
+		doc["updatedAt"] = now
+		err = c.writeAsset(ctx, id, doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	receipt := Receipt{
+		DocType:       DocTypeReceipt,
+		ID:            receiptID(orderID),
+		OrderID:       orderID,
+		SenderId:      order.SenderId,
+		SenderOrg:     order.SenderOrg,
+		ReceiverOrg:   order.ReceiverOrg,
+		ReceivedBy:    enrollmentID,
+		Status:        outcome,
+		ScannedIDs:    scannedIDs,
+		DamagedIDs:    damagedIDs,
+		Discrepancies: r.discrepancies,
+		CreationTxId:  txID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	err = c.writeAsset(ctx, receipt.ID, receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	oldStatus := order.Status
+	order.Status = outcome
+	order.ReceiptID = receipt.ID
+	if outcome != StatusRejected {
+		order.CurrentOwner = order.ReceiverOrg
+		order.CurrentCustodian = order.ReceiverOrg
+		order.DeliveredAt = now
+	}
+	order.UpdatedAt = now
+	err = c.writeAsset(ctx, orderID, order)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventOrderReceived,
+		DocType:   DocTypeOrder,
+		ItemIDs:   []string{orderID},
+		OldStatus: oldStatus,
+		NewStatus: outcome,
+		ChildIDs:  order.ItemIDs,
+		Details:   receipt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &receipt, nil
+}
+
+// insideDamaged reports whether a unit of the order is packed in a container reported damaged
+func (r *receiving) insideDamaged(id string) bool {
+	for {
+		_, parentID := docParent(r.docs[id])
+		if r.docs[parentID] == nil {
+			return false
+		}
+		if r.damaged[parentID] {
+			return true
+		}
+		id = parentID
+	}
+}
+
+// onlyOverages reports whether every discrepancy is an overage, which does not hold up a delivery
+func onlyOverages(discrepancies []Discrepancy) bool {
+	for _, discrepancy := range discrepancies {
+		if discrepancy.Kind != DiscrepancyOverage {
+			return false
+		}
+	}
+	return true
+}
+
+// GetOrderReceipt retrieves the receipt of an order, with its discrepancies
+func (c *PharmaContract) GetOrderReceipt(ctx contractapi.TransactionContextInterface, orderID string) (*Receipt, error) {
+	var receipt Receipt
+	err := c.readAsset(ctx, receiptID(orderID), &receipt)
+	if err != nil {
+		return nil, fmt.Errorf("order %s has not been received: %v", orderID, err)
+	}
+	return &receipt, nil
+}
+
+// GetDiscrepanciesBySender returns the receipts of orders sent by an organization that recorded any
+// shortage, overage or damage
+func (c *PharmaContract) GetDiscrepanciesBySender(ctx contractapi.TransactionContextInterface, senderOrg string) ([]*Receipt, error) {
+	receiptDocs, err := c.getIndexedDocs(ctx, IndexDocTypeStatus, DocTypeReceipt)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := []*Receipt{}
+	for _, receiptDoc := range receiptDocs {
+		var receipt Receipt
+		err = json.Unmarshal(receiptDoc, &receipt)
+		if err != nil {
+			return nil, err
+		}
+		if receipt.SenderOrg == senderOrg && len(receipt.Discrepancies) > 0 {
+			receipts = append(receipts, &receipt)
+		}
+	}
+	return receipts, nil
+}
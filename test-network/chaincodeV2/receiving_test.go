@@ -0,0 +1,152 @@
+package main
+
+import "testing"
+
+// dispatchedOrder builds shipment P1-SH, orders it as O1 and dispatches it to Org2MSP
+func dispatchedOrder(t *testing.T) *mockLedger {
+	t.Helper()
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	createOrder(t, l, "O1", "P1-SH")
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	})
+	return l
+}
+
+func TestReceiveOrder(t *testing.T) {
+	tests := []struct {
+		name          string
+		received      []string
+		damaged       []string
+		outcome       string
+		discrepancies map[string]string // Kind of each discrepancy by item ID
+		statuses      map[string]string
+		holders       map[string]string
+	}{
+		{
+			name:          "sealed shipment scanned",
+			received:      []string{"P1-SH"},
+			outcome:       StatusDelivered,
+			discrepancies: map[string]string{},
+			statuses:      map[string]string{"O1": StatusDelivered, "P1-SH": StatusDelivered, "P1-C1": StatusDelivered, "P1-S111": StatusDelivered},
+			holders:       map[string]string{"O1": "Org2MSP", "P1-SH": "Org2MSP", "P1-S111": "Org2MSP"},
+		},
+		{
+			name:          "overage does not hold up delivery",
+			received:      []string{"P1-SH", "X-1"},
+			outcome:       StatusDelivered,
+			discrepancies: map[string]string{"X-1": DiscrepancyOverage},
+			statuses:      map[string]string{"O1": StatusDelivered, "P1-S000": StatusDelivered},
+			holders:       map[string]string{"O1": "Org2MSP"},
+		},
+		{
+			name:          "shortage, damage and overage",
+			received:      []string{"P1-C0", "P1-B10", "X-1"},
+			damaged:       []string{"P1-S100"},
+			outcome:       StatusPartiallyDelivered,
+			discrepancies: map[string]string{"P1-B11": DiscrepancyShortage, "P1-S100": DiscrepancyDamaged, "X-1": DiscrepancyOverage},
+			statuses: map[string]string{
+				"O1": StatusPartiallyDelivered, "P1-SH": StatusPartiallyDelivered,
+				"P1-C0": StatusDelivered, "P1-S000": StatusDelivered,
+				"P1-C1": StatusPartiallyDelivered, "P1-B10": StatusPartiallyDelivered,
+				"P1-S100": StatusDamaged, "P1-S101": StatusDelivered,
+				"P1-B11": StatusDispatched, "P1-S110": StatusDispatched,
+			},
+			holders: map[string]string{"O1": "Org2MSP", "P1-SH": "Org2MSP", "P1-S100": "Org2MSP", "P1-B11": "Org1MSP", "P1-S110": "Org1MSP"},
+		},
+		{
+			name:          "damaged carton reported once",
+			received:      []string{"P1-C0"},
+			damaged:       []string{"P1-C1"},
+			outcome:       StatusPartiallyDelivered,
+			discrepancies: map[string]string{"P1-C1": DiscrepancyDamaged},
+			statuses:      map[string]string{"P1-SH": StatusPartiallyDelivered, "P1-C1": StatusDamaged, "P1-B11": StatusDamaged, "P1-S110": StatusDamaged},
+			holders:       map[string]string{"P1-C1": "Org2MSP"},
+		},
+		{
+			name:          "nothing usable arrived",
+			damaged:       []string{"P1-C1"},
+			outcome:       StatusRejected,
+			discrepancies: map[string]string{"P1-C0": DiscrepancyShortage, "P1-C1": DiscrepancyDamaged},
+			statuses:      map[string]string{"O1": StatusRejected, "P1-SH": StatusRejected, "P1-S100": StatusRejected, "P1-C0": StatusDispatched, "P1-S000": StatusDispatched},
+			holders:       map[string]string{"O1": "Org1MSP", "P1-SH": "Org1MSP", "P1-S100": "Org1MSP"},
+		},
+		{
+			name:          "nothing arrived",
+			outcome:       StatusRejected,
+			discrepancies: map[string]string{"P1-SH": DiscrepancyShortage},
+			statuses:      map[string]string{"O1": StatusRejected, "P1-SH": StatusDispatched, "P1-S000": StatusDispatched},
+			holders:       map[string]string{"O1": "Org1MSP", "P1-SH": "Org1MSP"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := dispatchedOrder(t)
+
+			var receipt *Receipt
+			mustSubmit(t, l, org2Pharm, func(ctx *mockCtx) error {
+				var err error
+				receipt, err = contract.ReceiveOrder(ctx, "O1", idList(tt.received...), idList(tt.damaged...))
+				return err
+			})
+
+			if receipt.Status != tt.outcome {
+				t.Errorf("outcome %s, want %s", receipt.Status, tt.outcome)
+			}
+			if len(receipt.Discrepancies) != len(tt.discrepancies) {
+				t.Errorf("discrepancies %+v, want %v", receipt.Discrepancies, tt.discrepancies)
+			}
+			for _, discrepancy := range receipt.Discrepancies {
+				if tt.discrepancies[discrepancy.ItemID] != discrepancy.Kind {
+					t.Errorf("unexpected discrepancy %+v", discrepancy)
+				}
+			}
+			for id, status := range tt.statuses {
+				if got := getDoc(t, l, id)["status"]; got != status {
+					t.Errorf("%s is %v, want %s", id, got, status)
+				}
+			}
+			for id, holder := range tt.holders {
+				doc := getDoc(t, l, id)
+				if doc["currentOwner"] != holder || doc["currentCustodian"] != holder {
+					t.Errorf("%s is held by %v/%v, want %s", id, doc["currentOwner"], doc["currentCustodian"], holder)
+				}
+			}
+
+			name, event := l.lastEvent(t)
+			if name != EventOrderReceived || event.NewStatus != tt.outcome {
+				t.Errorf("event %s with status %s", name, event.NewStatus)
+			}
+			if receiptDoc := getDoc(t, l, receiptID("O1")); receiptDoc["receivedBy"] != "pharm1" || receiptDoc["status"] != tt.outcome {
+				t.Errorf("receipt %v", receiptDoc)
+			}
+		})
+	}
+}
+
+func TestReceiveOrderChecks(t *testing.T) {
+	l := dispatchedOrder(t)
+	buildShipment(t, l, "P2", "B1")
+	createOrder(t, l, "O2", "P2-SH")
+
+	receive := func(id *mockIdentity, orderID string, receivedIDsJSON string, damagedIDsJSON string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.ReceiveOrder(ctx, orderID, receivedIDsJSON, damagedIDsJSON)
+			return err
+		})
+	}
+
+	expectError(t, receive(org1Dist, "O1", idList("P1-SH"), ""), "can only be received by Org2MSP")
+	expectError(t, receive(org2Pharm, "O2", idList("P2-SH"), ""), "only dispatched orders can be received")
+	expectError(t, receive(org2Pharm, "O1", idList("P1-SH"), idList("P2-S000")), "damaged unit P2-S000 is not part of order O1")
+	expectError(t, receive(org2Pharm, "O1", "P1-SH", ""), "failed to parse received unit IDs")
+	expectError(t, receive(org2Pharm, "P1-SH", idList("P1-SH"), ""), "is not an order")
+
+	if err := receive(org2Pharm, "O1", idList("P1-SH"), ""); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, receive(org2Pharm, "O1", idList("P1-SH"), ""), "only dispatched orders can be received")
+}
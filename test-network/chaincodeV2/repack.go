@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Packing change actions recorded on repacked items
+const (
+	PackingActionUnpacked = "UNPACKED"
+	PackingActionMoved    = "MOVED"
+)
+
+// PackingChange records the last unpack or move an item took part in, so each step shows up in its history
+type PackingChange struct {
+	Action       string `json:"action"`
+	ItemID       string `json:"itemId"`       // The item that was unpacked or moved
+	FromParentID string `json:"fromParentId"` // Empty when the item was not packed before
+	ToParentID   string `json:"toParentId"`   // Empty when the item was taken out of its parent
+	TxID         string `json:"txId"`
+}
+
+// packedItem is a strip or container document being changed by a repack transaction. It is kept as a
+// generic document so every level is handled by the same code.
+type packedItem struct {
+	id    string
+	doc   map[string]interface{}
+	dirty bool
+}
+
+func (p *packedItem) docType() string {
+	docType, _ := p.doc["docType"].(string)
+	return docType
+}
+
+func (p *packedItem) str(field string) string {
+	value, _ := p.doc[field].(string)
+	return value
+}
+
+func (p *packedItem) parent() (string, string) {
+	return docParent(p.doc)
+}
+
+func (p *packedItem) childIDs() []string {
+	return docChildIDs(p.doc)
+}
+
+func (p *packedItem) set(field string, value interface{}) {
+	p.doc[field] = value
+	p.dirty = true
+}
+
+// repackSession caches the items touched by one transaction. Fabric does not return a transaction's own
+// writes on read, so every item is read once, changed in memory and written once at the end.
+type repackSession struct {
+	c     *PharmaContract
+	ctx   contractapi.TransactionContextInterface
+	items map[string]*packedItem
+	keys  []string
+	now   time.Time
+	txID  string
+}
+
+func (c *PharmaContract) newRepackSession(ctx contractapi.TransactionContextInterface) *repackSession {
+	return &repackSession{
+		c:     c,
+		ctx:   ctx,
+		items: map[string]*packedItem{},
+		now:   c.getTxTimestamp(ctx),
+		txID:  ctx.GetStub().GetTxID(),
+	}
+}
+
+func (s *repackSession) load(id string) (*packedItem, error) {
+	if item, ok := s.items[id]; ok {
+		return item, nil
+	}
+	var doc map[string]interface{}
+	err := s.c.readAsset(s.ctx, id, &doc)
+	if err != nil {
+		return nil, err
+	}
+	item := &packedItem{id: id, doc: doc}
+	s.items[id] = item
+	s.keys = append(s.keys, id)
+	return item, nil
+}
+
+// summaryOf returns the content summary of an item as changed so far in this transaction
+func (s *repackSession) summaryOf(item *packedItem) (*ContentSummary, error) {
+	if item.docType() == DocTypeStrip {
+		var strip Strip
+		err := remarshal(item.doc, &strip)
+		if err != nil {
+			return nil, err
+		}
+		return summarizeStrips([]*Strip{&strip}), nil
+	}
+	if item.doc["summary"] == nil {
+		return s.c.getContentSummary(s.ctx, item.id)
+	}
+	var summary ContentSummary
+	err := remarshal(item.doc["summary"], &summary)
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// refreshSummaries recomputes the content summary of a container and of every container above it
+func (s *repackSession) refreshSummaries(id string) error {
+	for id != "" {
+		container, err := s.load(id)
+		if err != nil {
+			return err
+		}
+		var summaries []*ContentSummary
+		for _, childID := range container.childIDs() {
+			child, err := s.load(childID)
+			if err != nil {
+				return err
+			}
+			summary, err := s.summaryOf(child)
+			if err != nil {
+				return err
+			}
+			summaries = append(summaries, summary)
+		}
+		container.set("summary", mergeSummaries(summaries))
+
+		parentDocType, parentID := container.parent()
+		if !isPackagingLevel(parentDocType) {
+			return nil
+		}
+		id = parentID
+	}
+	return nil
+}
+
+// refreshRecalls recomputes the recalled batches flagged on a container, and on every container and order
+// above it, from what they hold after this transaction
+func (s *repackSession) refreshRecalls(id string) error {
+	for id != "" {
+		container, err := s.load(id)
+		if err != nil {
+			return err
+		}
+		var recalled []string
+		for _, childID := range container.childIDs() {
+			child, err := s.load(childID)
+			if err != nil {
+				return err
+			}
+			for _, batchNumber := range recalledBatchesOf(child.doc) {
+				recalled = appendUnique(recalled, batchNumber)
+			}
+		}
+		if len(recalled) > 0 {
+			container.set("recalledBatches", recalled)
+		} else if _, ok := container.doc["recalledBatches"]; ok {
+			delete(container.doc, "recalledBatches")
+			container.dirty = true
+		}
+		_, id = container.parent()
+	}
+	return nil
+}
+
+// record stamps an item with the packing change it took part in
+func (s *repackSession) record(item *packedItem, change PackingChange) {
+	change.TxID = s.txID
+	item.set("packingChange", change)
+	item.set("updatedAt", s.now)
+}
+
+// commit writes every changed item
+func (s *repackSession) commit() error {
+	for _, id := range s.keys {
+		item := s.items[id]
+		if !item.dirty {
+			continue
+		}
+		err := s.c.writeAsset(s.ctx, id, item.doc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remarshal converts a generic JSON value into a typed one
+func remarshal(from interface{}, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+// checkRepackable refuses to change items that have left with a dispatched order, and items the caller's
+// organization does not hold
+func checkRepackable(item *packedItem, mspID string) error {
+	status := item.str("status")
+	if status == StatusDispatched || status == StatusDelivered {
+		return fmt.Errorf("%s %s is %s with its order and can no longer be repacked", item.docType(), item.id, status)
+	}
+	return checkCustody(item.doc, mspID)
+}
+
+// setChildStatus moves a child to SEALED when it is packed and back to CREATED when it is taken out.
+// Recalled strips keep their status.
+func setChildStatus(item *packedItem, packed bool) error {
+	from := item.str("status")
+	to := StatusCreated
+	if packed {
+		to = StatusSealed
+	}
+	if from == to || (from == StatusRecalled && !packed) {
+		return nil
+	}
+	err := checkTransition(item.docType(), item.id, from, to)
+	if err != nil {
+		return err
+	}
+	item.set("status", to)
+	return nil
+}
+
+// unpack empties a top-level container, releasing each child from it
+func (c *PharmaContract) unpack(ctx contractapi.TransactionContextInterface, docType string, containerID string, v interface{}) error {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	s := c.newRepackSession(ctx)
+	container, err := s.load(containerID)
+	if err != nil {
+		return err
+	}
+	if container.docType() != docType {
+		return fmt.Errorf("%s is a %s, not a %s", containerID, container.docType(), docType)
+	}
+	err = checkRepackable(container, mspID)
+	if err != nil {
+		return err
+	}
+	if parentDocType, parentID := container.parent(); isPackagingLevel(parentDocType) {
+		return fmt.Errorf("%s %s is packed in %s %s, take it out with MoveChild first", docType, containerID, parentDocType, parentID)
+	}
+
+	childIDs := container.childIDs()
+	if len(childIDs) == 0 {
+		return fmt.Errorf("%s %s is already empty", docType, containerID)
+	}
+
+	for _, childID := range childIDs {
+		child, err := s.load(childID)
+		if err != nil {
+			return err
+		}
+		ct, _ := findContainment(docType, child.docType())
+		child.set(ct.pointer, "")
+		err = setChildStatus(child, false)
+		if err != nil {
+			return err
+		}
+		s.record(child, PackingChange{Action: PackingActionUnpacked, ItemID: childID, FromParentID: containerID})
+	}
+
+	for _, field := range childListFields(docType) {
+		container.set(field, []string{})
+	}
+	container.set("summary", mergeSummaries(nil))
+	s.record(container, PackingChange{Action: PackingActionUnpacked, ItemID: containerID})
+
+	// The empty container no longer holds recalled stock; the children keep their own flags
+	err = s.refreshRecalls(containerID)
+	if err != nil {
+		return err
+	}
+
+	err = s.commit()
+	if err != nil {
+		return err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventContainerUnpacked,
+		DocType:   docType,
+		ItemIDs:   []string{containerID},
+		OldStatus: container.str("status"),
+		NewStatus: container.str("status"),
+		ChildIDs:  childIDs,
+	})
+	if err != nil {
+		return err
+	}
+	return remarshal(container.doc, v)
+}
+
+// UnpackBox takes every strip out of a box that is not packed in a carton, leaving the box empty
+func (c *PharmaContract) UnpackBox(ctx contractapi.TransactionContextInterface, boxID string) (*Box, error) {
+	if err := c.checkAccess(ctx, "UnpackBox"); err != nil {
+		return nil, err
+	}
+
+	var box Box
+	err := c.unpack(ctx, DocTypeBox, boxID, &box)
+	if err != nil {
+		return nil, err
+	}
+	return &box, nil
+}
+
+// UnpackCarton takes every box out of a carton that is not packed in a shipment, leaving the carton empty
+func (c *PharmaContract) UnpackCarton(ctx contractapi.TransactionContextInterface, cartonID string) (*Carton, error) {
+	if err := c.checkAccess(ctx, "UnpackCarton"); err != nil {
+		return nil, err
+	}
+
+	var carton Carton
+	err := c.unpack(ctx, DocTypeCarton, cartonID, &carton)
+	if err != nil {
+		return nil, err
+	}
+	return &carton, nil
+}
+
+// UnpackPallet takes every carton or shipment off a pallet that is not loaded in a shipment, leaving it empty
+func (c *PharmaContract) UnpackPallet(ctx contractapi.TransactionContextInterface, palletID string) (*Pallet, error) {
+	if err := c.checkAccess(ctx, "UnpackPallet"); err != nil {
+		return nil, err
+	}
+
+	var pallet Pallet
+	err := c.unpack(ctx, DocTypePallet, palletID, &pallet)
+	if err != nil {
+		return nil, err
+	}
+	return &pallet, nil
+}
+
+// UnpackShipment takes every carton and pallet out of a shipment that is not on a pallet, leaving it empty
+func (c *PharmaContract) UnpackShipment(ctx contractapi.TransactionContextInterface, shipmentID string) (*Shipment, error) {
+	if err := c.checkAccess(ctx, "UnpackShipment"); err != nil {
+		return nil, err
+	}
+
+	var shipment Shipment
+	err := c.unpack(ctx, DocTypeShipment, shipmentID, &shipment)
+	if err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// MoveChild moves a strip, box, carton, pallet or shipment into another container that can hold it,
+// updating both parents' contents and summaries. An empty newParentID takes the item out of its parent.
+// The receiving container's packing rules are enforced.
+func (c *PharmaContract) MoveChild(ctx contractapi.TransactionContextInterface, childID string, newParentID string) (*PackingChange, error) {
+	if err := c.checkAccess(ctx, "MoveChild"); err != nil {
+		return nil, err
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	s := c.newRepackSession(ctx)
+	child, err := s.load(childID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRepackable(child, mspID)
+	if err != nil {
+		return nil, err
+	}
+
+	oldParentDocType, oldParentID := child.parent()
+	if oldParentID != "" && !isPackagingLevel(oldParentDocType) {
+		return nil, fmt.Errorf("%s %s is in %s %s, which is changed with order transactions", child.docType(), childID, oldParentDocType, oldParentID)
+	}
+	if oldParentID == newParentID {
+		return nil, fmt.Errorf("%s %s is already in %q", child.docType(), childID, newParentID)
+	}
+	change := PackingChange{Action: PackingActionMoved, ItemID: childID, FromParentID: oldParentID, ToParentID: newParentID}
+	oldStatus := child.str("status")
+
+	if oldParentID != "" {
+		oldParent, err := s.load(oldParentID)
+		if err != nil {
+			return nil, err
+		}
+		err = checkRepackable(oldParent, mspID)
+		if err != nil {
+			return nil, err
+		}
+		ct, _ := findContainment(oldParentDocType, child.docType())
+		remaining := []string{}
+		for _, id := range docStrings(oldParent.doc, ct.field) {
+			if id != childID {
+				remaining = append(remaining, id)
+			}
+		}
+		oldParent.set(ct.field, remaining)
+		child.set(ct.pointer, "")
+		s.record(oldParent, change)
+	}
+
+	var newParent *packedItem
+	if newParentID != "" {
+		newParent, err = s.load(newParentID)
+		if err != nil {
+			return nil, err
+		}
+		ct, ok := findContainment(newParent.docType(), child.docType())
+		if !ok || !isPackagingLevel(newParent.docType()) {
+			return nil, fmt.Errorf("a %s cannot be packed in %s %s", child.docType(), newParent.docType(), newParentID)
+		}
+		// Recalled stock may be taken out of its container but not packed anywhere else
+		err = checkNotRecalled(child.doc)
+		if err != nil {
+			return nil, err
+		}
+		if reverse, ok := findContainment(child.docType(), newParent.docType()); ok && len(docStrings(child.doc, reverse.field)) > 0 {
+			return nil, fmt.Errorf("%s %s holds %ss and cannot be packed in a %s", child.docType(), childID, newParent.docType(), newParent.docType())
+		}
+		err = checkRepackable(newParent, mspID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The new parent must not be inside the item being moved
+		for id := newParentID; id != ""; {
+			if id == childID {
+				return nil, fmt.Errorf("%s %s cannot be packed inside itself", child.docType(), childID)
+			}
+			ancestor, err := s.load(id)
+			if err != nil {
+				return nil, err
+			}
+			_, id = ancestor.parent()
+		}
+
+		err = c.checkContentsShelfLife(ctx, childID, s.now)
+		if err != nil {
+			return nil, err
+		}
+		children := append([]string{}, docStrings(newParent.doc, ct.field)...)
+		newParent.set(ct.field, append(children, childID))
+		child.set(ct.pointer, newParentID)
+		err = checkContentKinds(newParent.docType(), newParentID, docLists(newParent.doc))
+		if err != nil {
+			return nil, err
+		}
+		s.record(newParent, change)
+	}
+
+	err = setChildStatus(child, newParentID != "")
+	if err != nil {
+		return nil, err
+	}
+	s.record(child, change)
+
+	err = s.refreshSummaries(oldParentID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.refreshRecalls(oldParentID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.refreshSummaries(newParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if newParent != nil {
+		summary, err := s.summaryOf(newParent)
+		if err != nil {
+			return nil, err
+		}
+		err = c.checkPackingRules(ctx, newParent.docType(), newParentID, len(newParent.childIDs()), summary)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	change.TxID = s.txID
+	err = c.emitEvent(ctx, ItemEvent{
+		Name:      EventItemMoved,
+		DocType:   child.docType(),
+		ItemIDs:   []string{childID},
+		OldStatus: oldStatus,
+		NewStatus: child.str("status"),
+		Details:   change,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMoveChild(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+
+	move := func(id *mockIdentity, childID string, newParentID string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.MoveChild(ctx, childID, newParentID)
+			return err
+		})
+	}
+
+	refused := []struct {
+		name        string
+		id          *mockIdentity
+		childID     string
+		newParentID string
+		err         string
+	}{
+		{"not the custodian", org2Dist, "P1-S000", "P1-B01", "strip P1-S000 is in the custody of Org1MSP, not Org2MSP"},
+		{"wrong level", org1Mfr, "P1-S000", "P1-C1", "a strip cannot be packed in carton P1-C1"},
+		{"same parent", org1Mfr, "P1-S000", "P1-B00", `strip P1-S000 is already in "P1-B00"`},
+		{"missing parent", org1Mfr, "P1-S000", "P1-B99", "P1-B99"},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			expectError(t, move(tt.id, tt.childID, tt.newParentID), tt.err)
+		})
+	}
+
+	// A move updates both parents and the child's pointer
+	if err := move(org1Mfr, "P1-S000", "P1-B01"); err != nil {
+		t.Fatal(err)
+	}
+	if strips := getDoc(t, l, "P1-B00")["strips"]; !reflect.DeepEqual(strips, []interface{}{"P1-S001"}) {
+		t.Errorf("P1-B00 holds %v", strips)
+	}
+	if strips := getDoc(t, l, "P1-B01")["strips"]; !reflect.DeepEqual(strips, []interface{}{"P1-S010", "P1-S011", "P1-S000"}) {
+		t.Errorf("P1-B01 holds %v", strips)
+	}
+	strip := getDoc(t, l, "P1-S000")
+	if strip["boxId"] != "P1-B01" || strip["status"] != StatusSealed {
+		t.Errorf("strip %v", strip)
+	}
+	name, event := l.lastEvent(t)
+	if name != EventItemMoved || event.ActorMSP != "Org1MSP" {
+		t.Errorf("event %s %+v", name, event)
+	}
+
+	// Each side of the move shows it in its history
+	var history []map[string]interface{}
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		history, err = contract.GetTransactionHistory(ctx, "P1-B00")
+		return err
+	})
+	change := history[0]["value"].(map[string]interface{})["packingChange"].(map[string]interface{})
+	if change["action"] != PackingActionMoved || change["itemId"] != "P1-S000" || change["fromParentId"] != "P1-B00" || change["toParentId"] != "P1-B01" {
+		t.Errorf("packing change %v", change)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	buildShipment(t, l, "P2", "B1")
+
+	unpackBox := func(boxID string) error {
+		return submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.UnpackBox(ctx, boxID)
+			return err
+		})
+	}
+
+	// A packed container is taken out of its parent before it is unpacked
+	expectError(t, unpackBox("P1-B00"), "box P1-B00 is packed in carton P1-C0, take it out with MoveChild first")
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.MoveChild(ctx, "P1-B00", "")
+		return err
+	})
+	if box := getDoc(t, l, "P1-B00"); box["status"] != StatusCreated || box["cartonId"] != "" {
+		t.Errorf("box %v", box)
+	}
+	if err := unpackBox("P1-B00"); err != nil {
+		t.Fatal(err)
+	}
+	strip := getDoc(t, l, "P1-S001")
+	if change, _ := strip["packingChange"].(map[string]interface{}); strip["status"] != StatusCreated || strip["boxId"] != "" || change["action"] != PackingActionUnpacked {
+		t.Errorf("strip %v", strip)
+	}
+	expectError(t, unpackBox("P1-B00"), "box P1-B00 is already empty")
+
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.UnpackShipment(ctx, "P1-SH")
+		return err
+	})
+	if carton := getDoc(t, l, "P1-C0"); carton["status"] != StatusCreated || carton["shipmentId"] != "" {
+		t.Errorf("carton %v", carton)
+	}
+
+	// Nothing can be repacked once it has left with its order
+	createOrder(t, l, "O1", "P2-SH")
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	})
+	expectError(t, submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.MoveChild(ctx, "P2-S000", "P1-B01")
+		return err
+	}), "strip P2-S000 is DISPATCHED with its order and can no longer be repacked")
+	expectError(t, submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.UnpackShipment(ctx, "P2-SH")
+		return err
+	}), "DISPATCHED")
+}
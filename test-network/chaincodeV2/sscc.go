@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ssccLength is the number of digits in a Serial Shipping Container Code, including the check digit
+const ssccLength = 18
+
+// SSCCConfig holds an organization's GS1 company prefix and the ledger-maintained serial counter used
+// to generate SSCCs for its cartons and shipments. Each prefix keeps its own counter, so switching back
+// to a prefix used before carries on where it stopped.
+type SSCCConfig struct {
+	DocType          string           `json:"docType"`
+	ID               string           `json:"id"`
+	MSPID            string           `json:"mspId"`
+	CompanyPrefix    string           `json:"companyPrefix"`              // 7 to 10 digit GS1 company prefix
+	ExtensionDigit   int              `json:"extensionDigit"`             // First digit of every SSCC, chosen by the company
+	RequireSSCC      bool             `json:"requireSsccIds"`             // Reject carton/shipment IDs that are not valid SSCCs
+	NextSerial       int64            `json:"nextSerial"`                 // Next serial reference to allocate under CompanyPrefix
+	PreviousPrefixes map[string]int64 `json:"previousPrefixes,omitempty"` // Prefixes used before, with their next serial
+	UpdatedAt        time.Time        `json:"updatedAt"`
+}
+
+// ownsPrefix reports whether the SSCC body after the extension digit starts with one of the organization's
+// current or previous company prefixes
+func (config *SSCCConfig) ownsPrefix(sscc string) bool {
+	for _, prefix := range config.prefixes() {
+		if strings.HasPrefix(sscc[1:], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixes returns the organization's current and previous company prefixes
+func (config *SSCCConfig) prefixes() []string {
+	prefixes := []string{config.CompanyPrefix}
+	for prefix := range config.PreviousPrefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// ssccConfigID returns the ledger key of an organization's SSCC configuration
+func ssccConfigID(mspID string) string {
+	return "SSCC_CONFIG_" + mspID
+}
+
+// isSSCC reports whether id has the shape of an SSCC (18 digits)
+func isSSCC(id string) bool {
+	if len(id) != ssccLength {
+		return false
+	}
+	for _, ch := range id {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// buildSSCC assembles extension digit, company prefix and serial reference and appends the check digit
+func buildSSCC(extensionDigit int, companyPrefix string, serial int64) (string, error) {
+	serialLength := ssccLength - 2 - len(companyPrefix)
+	serialRef := strconv.FormatInt(serial, 10)
+	if len(serialRef) > serialLength {
+		return "", fmt.Errorf("serial reference %d does not fit in %d digits for company prefix %s", serial, serialLength, companyPrefix)
+	}
+	serialRef = strings.Repeat("0", serialLength-len(serialRef)) + serialRef
+
+	body := strconv.Itoa(extensionDigit) + companyPrefix + serialRef
+	check, err := gs1CheckDigit(body)
+	if err != nil {
+		return "", err
+	}
+	return body + strconv.Itoa(check), nil
+}
+
+// getSSCCConfig reads an organization's SSCC configuration, returning nil if it has none
+func (c *PharmaContract) getSSCCConfig(ctx contractapi.TransactionContextInterface, mspID string) (*SSCCConfig, error) {
+	configJSON, err := ctx.GetStub().GetState(ssccConfigID(mspID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSCC configuration: %v", err)
+	}
+	if configJSON == nil {
+		return nil, nil
+	}
+
+	var config SSCCConfig
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// resolveLogisticUnitID validates or generates the ID of a carton, pallet or shipment.
+// An empty ID is replaced by the next unused SSCC for the caller's organization; an 18-digit ID must carry
+// a valid SSCC check digit and one of the organization's company prefixes; any other ID is accepted
+// unless the organization requires SSCCs.
+func (c *PharmaContract) resolveLogisticUnitID(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	config, err := c.getSSCCConfig(ctx, mspID)
+	if err != nil {
+		return "", err
+	}
+
+	if id == "" {
+		if config == nil {
+			return "", fmt.Errorf("no ID given and no GS1 company prefix registered for %s", mspID)
+		}
+
+		// Skip serials already taken by SSCCs the organization supplied itself
+		var sscc string
+		for {
+			sscc, err = buildSSCC(config.ExtensionDigit, config.CompanyPrefix, config.NextSerial)
+			if err != nil {
+				return "", err
+			}
+			config.NextSerial++
+			exists, err := c.assetExists(ctx, sscc)
+			if err != nil {
+				return "", err
+			}
+			if !exists {
+				break
+			}
+		}
+		config.UpdatedAt = c.getTxTimestamp(ctx)
+		err = c.writeAsset(ctx, config.ID, config)
+		if err != nil {
+			return "", err
+		}
+		return sscc, nil
+	}
+
+	if isSSCC(id) {
+		err = validateGS1Number(id, ssccLength)
+		if err != nil {
+			return "", fmt.Errorf("invalid SSCC: %v", err)
+		}
+		if config == nil {
+			return "", fmt.Errorf("no GS1 company prefix registered for %s to issue SSCC %s", mspID, id)
+		}
+		if !config.ownsPrefix(id) {
+			return "", fmt.Errorf("SSCC %s does not carry a company prefix of %s", id, mspID)
+		}
+		return id, nil
+	}
+
+	if config != nil && config.RequireSSCC {
+		return "", fmt.Errorf("%s requires SSCC IDs for logistic units, %q is not an SSCC", mspID, id)
+	}
+	return id, nil
+}
+
+// RegisterCompanyPrefix sets the GS1 company prefix used to generate SSCCs for the caller's organization.
+// Changing the prefix keeps the old one reserved to the organization along with its serial counter.
+func (c *PharmaContract) RegisterCompanyPrefix(ctx contractapi.TransactionContextInterface, companyPrefix string, extensionDigit int, requireSSCC bool) (*SSCCConfig, error) {
+	if err := c.checkAccess(ctx, "RegisterCompanyPrefix"); err != nil {
+		return nil, err
+	}
+
+	if len(companyPrefix) < 7 || len(companyPrefix) > 10 {
+		return nil, fmt.Errorf("company prefix must be 7 to 10 digits")
+	}
+	if _, err := gs1CheckDigit(companyPrefix); err != nil {
+		return nil, fmt.Errorf("invalid company prefix: %v", err)
+	}
+	if extensionDigit < 0 || extensionDigit > 9 {
+		return nil, fmt.Errorf("extension digit must be between 0 and 9")
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	// A company prefix belongs to exactly one organization, and must not overlap another organization's
+	// current or previous prefixes: SSCCs under "0614141" and "06141412" cannot be told apart
+	configDocs, err := c.getIndexedDocs(ctx, IndexCompanyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, configDoc := range configDocs {
+		var other SSCCConfig
+		err = json.Unmarshal(configDoc, &other)
+		if err != nil {
+			return nil, err
+		}
+		if other.MSPID == mspID {
+			continue
+		}
+		for _, prefix := range other.prefixes() {
+			if prefix == companyPrefix {
+				return nil, fmt.Errorf("company prefix %s is already registered to %s", companyPrefix, other.MSPID)
+			}
+			if strings.HasPrefix(prefix, companyPrefix) || strings.HasPrefix(companyPrefix, prefix) {
+				return nil, fmt.Errorf("company prefix %s overlaps prefix %s registered to %s", companyPrefix, prefix, other.MSPID)
+			}
+		}
+	}
+
+	config, err := c.getSSCCConfig(ctx, mspID)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &SSCCConfig{
+			DocType: DocTypeSSCCConfig,
+			ID:      ssccConfigID(mspID),
+			MSPID:   mspID,
+		}
+	}
+	if config.CompanyPrefix != companyPrefix {
+		if config.CompanyPrefix != "" {
+			if config.PreviousPrefixes == nil {
+				config.PreviousPrefixes = map[string]int64{}
+			}
+			config.PreviousPrefixes[config.CompanyPrefix] = config.NextSerial
+		}
+		config.NextSerial = config.PreviousPrefixes[companyPrefix]
+		delete(config.PreviousPrefixes, companyPrefix)
+	}
+	config.CompanyPrefix = companyPrefix
+	config.ExtensionDigit = extensionDigit
+	config.RequireSSCC = requireSSCC
+	config.UpdatedAt = c.getTxTimestamp(ctx)
+
+	err = c.writeAsset(ctx, config.ID, config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventCompanyPrefixRegistered, DocType: DocTypeSSCCConfig, ItemIDs: []string{config.ID}, Details: config})
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// GetSSCCConfig returns the SSCC configuration of an organization
+func (c *PharmaContract) GetSSCCConfig(ctx contractapi.TransactionContextInterface, mspID string) (*SSCCConfig, error) {
+	config, err := c.getSSCCConfig(ctx, mspID)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, fmt.Errorf("no GS1 company prefix registered for %s", mspID)
+	}
+	return config, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import "testing"
+
+func TestBuildSSCC(t *testing.T) {
+	tests := []struct {
+		extensionDigit int
+		companyPrefix  string
+		serial         int64
+		want           string
+	}{
+		{1, "0614141", 123456789, "106141411234567897"},
+		{0, "0614141", 0, "006141410000000005"},
+		{9, "0614141000", 999999, "906141410009999990"},
+		{1, "0614141000", 1000000, ""},
+		{1, "0614141", 1000000000, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := buildSSCC(tt.extensionDigit, tt.companyPrefix, tt.serial)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("buildSSCC(%d, %s, %d) = %s, expected an error", tt.extensionDigit, tt.companyPrefix, tt.serial, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("buildSSCC(%d, %s, %d) = %s, %v, want %s", tt.extensionDigit, tt.companyPrefix, tt.serial, got, err, tt.want)
+			continue
+		}
+		if err = validateGS1Number(got, ssccLength); err != nil {
+			t.Errorf("buildSSCC(%d, %s, %d) is not a valid SSCC: %v", tt.extensionDigit, tt.companyPrefix, tt.serial, err)
+		}
+	}
+}
+
+func TestRegisterCompanyPrefix(t *testing.T) {
+	l := newLedger()
+
+	tests := []struct {
+		companyPrefix  string
+		extensionDigit int
+		err            string
+	}{
+		{"089000", 1, "7 to 10 digits"},
+		{"08900000000", 1, "7 to 10 digits"},
+		{"08900AB", 1, "invalid company prefix"},
+		{"0890000", 10, "extension digit"},
+		{"0890000", 1, ""},
+	}
+
+	for _, tt := range tests {
+		err := submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.RegisterCompanyPrefix(ctx, tt.companyPrefix, tt.extensionDigit, false)
+			return err
+		})
+		if tt.err == "" && err != nil {
+			t.Errorf("%s/%d: unexpected error: %v", tt.companyPrefix, tt.extensionDigit, err)
+		}
+		if tt.err != "" {
+			expectError(t, err, tt.err)
+		}
+	}
+
+	register := func(id *mockIdentity, companyPrefix string) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.RegisterCompanyPrefix(ctx, companyPrefix, 1, false)
+			return err
+		})
+	}
+	if err := register(org1Mfr, "06141412"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another organization's prefix must neither equal nor overlap Org1MSP's current or previous ones
+	claims := []struct {
+		companyPrefix string
+		err           string
+	}{
+		{"0890000", "company prefix 0890000 is already registered to Org1MSP"},
+		{"08900001", "company prefix 08900001 overlaps prefix 0890000 registered to Org1MSP"},
+		{"06141412", "already registered to Org1MSP"},
+		{"0614141", "company prefix 0614141 overlaps prefix 06141412 registered to Org1MSP"},
+		{"061414123", "overlaps prefix 06141412"},
+		{"0614142", ""},
+	}
+	for _, claim := range claims {
+		err := register(org2Dist, claim.companyPrefix)
+		if claim.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", claim.companyPrefix, err)
+		}
+		if claim.err != "" {
+			expectError(t, err, claim.err)
+		}
+	}
+}
+
+func TestSSCCGeneration(t *testing.T) {
+	l := newLedger()
+	for _, cartonID := range []string{"Q1", "Q2", "Q3", "Q4", "Q5"} {
+		buildCarton(t, l, cartonID, "B1")
+	}
+
+	seal := func(shipmentID string, cartonID string) (string, error) {
+		var id string
+		err := submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			shipment, err := contract.SealShipment(ctx, shipmentID, idList(cartonID))
+			if shipment != nil {
+				id = shipment.ID
+			}
+			return err
+		})
+		return id, err
+	}
+	register := func(companyPrefix string, requireSSCC bool) {
+		t.Helper()
+		mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.RegisterCompanyPrefix(ctx, companyPrefix, 1, requireSSCC)
+			return err
+		})
+	}
+	sscc := func(companyPrefix string, serial int64) string {
+		t.Helper()
+		id, err := buildSSCC(1, companyPrefix, serial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// Without a registered prefix there is nothing to generate from and no SSCC can be claimed
+	_, err := seal("", "Q1")
+	expectError(t, err, "no ID given and no GS1 company prefix registered")
+	_, err = seal(sscc("0890000", 0), "Q1")
+	expectError(t, err, "no GS1 company prefix registered for Org1MSP")
+
+	register("0890000", true)
+	_, err = seal(sscc("0990000", 5), "Q1")
+	expectError(t, err, "does not carry a company prefix of Org1MSP")
+	_, err = seal("108900000000000001", "Q1")
+	expectError(t, err, "invalid SSCC")
+	_, err = seal("FREEFORM", "Q1")
+	expectError(t, err, "requires SSCC IDs")
+
+	// A supplied SSCC takes serial 0, so generation skips it
+	id, err := seal(sscc("0890000", 0), "Q1")
+	if err != nil || id != sscc("0890000", 0) {
+		t.Fatal(id, err)
+	}
+	id, err = seal("", "Q2")
+	if err != nil || id != sscc("0890000", 1) {
+		t.Fatal(id, err)
+	}
+
+	// Switching prefix starts a new counter and switching back resumes the old one
+	register("0990000", false)
+	id, err = seal("", "Q3")
+	if err != nil || id != sscc("0990000", 0) {
+		t.Fatal(id, err)
+	}
+	register("0890000", false)
+	id, err = seal("", "Q4")
+	if err != nil || id != sscc("0890000", 2) {
+		t.Fatal(id, err)
+	}
+
+	// The previous prefix stays reserved to the organization
+	config := getDoc(t, l, ssccConfigID("Org1MSP"))
+	if previous, _ := config["previousPrefixes"].(map[string]interface{}); previous["0990000"] != float64(1) {
+		t.Fatal(config)
+	}
+	id, err = seal(sscc("0990000", 7), "Q5")
+	if err != nil || id != sscc("0990000", 7) {
+		t.Fatal(id, err)
+	}
+	err = submit(t, l, org2Dist, func(ctx *mockCtx) error {
+		_, err := contract.RegisterCompanyPrefix(ctx, "0990000", 1, false)
+		return err
+	})
+	expectError(t, err, "already registered to Org1MSP")
+}
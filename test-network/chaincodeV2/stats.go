@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// statObjectType is the composite key object type of statistics deltas
+const statObjectType = "stat"
+
+// Statistics dimensions: every counted document adds one to its doc type's total and to the buckets of
+// its status, product and owning organization
+const (
+	statTotal   = "total"
+	statStatus  = "status"
+	statProduct = "product"
+	statOrg     = "org"
+)
+
+// statRebuildID and statPruneID stand in for the item ID on the deltas written by RebuildStatistics and
+// PruneStatistics
+const (
+	statRebuildID = "~rebuild"
+	statPruneID   = "~prune"
+)
+
+// ItemStatistics counts the items of one doc type
+type ItemStatistics struct {
+	Total     int            `json:"total"`
+	ByStatus  map[string]int `json:"byStatus"`
+	ByProduct map[string]int `json:"byProduct"` // Keyed by GTIN-14
+	ByOrg     map[string]int `json:"byOrg"`     // Keyed by the MSP ID of the owning organization
+}
+
+// statBucket identifies one counter: a doc type, a dimension and the value counted in it
+type statBucket struct {
+	docType   string
+	dimension string
+	value     string
+}
+
+// newItemStatistics returns empty statistics
+func newItemStatistics() *ItemStatistics {
+	return &ItemStatistics{ByStatus: map[string]int{}, ByProduct: map[string]int{}, ByOrg: map[string]int{}}
+}
+
+// add counts delta in the counter of bucket
+func (stats *ItemStatistics) add(bucket statBucket, delta int) {
+	switch bucket.dimension {
+	case statTotal:
+		stats.Total += delta
+	case statStatus:
+		stats.ByStatus[bucket.value] += delta
+	case statProduct:
+		stats.ByProduct[bucket.value] += delta
+	case statOrg:
+		stats.ByOrg[bucket.value] += delta
+	}
+}
+
+// dropEmptyBuckets leaves out the buckets that went back to zero
+func (stats *ItemStatistics) dropEmptyBuckets() {
+	for _, counts := range []map[string]int{stats.ByStatus, stats.ByProduct, stats.ByOrg} {
+		for value, count := range counts {
+			if count == 0 {
+				delete(counts, value)
+			}
+		}
+	}
+}
+
+// statBuckets returns the counters a stored document adds one to; documents that are not counted,
+// such as configuration, have none
+func statBuckets(docJSON []byte) []statBucket {
+	if docJSON == nil {
+		return nil
+	}
+	var doc struct {
+		DocType         string `json:"docType"`
+		Status          string `json:"status"`
+		QCStatus        string `json:"qcStatus"`
+		GTIN            string `json:"gtin"`
+		CurrentOwner    string `json:"currentOwner"`
+		ManufacturerOrg string `json:"manufacturerOrg"`
+		SenderOrg       string `json:"senderOrg"`
+	}
+	if json.Unmarshal(docJSON, &doc) != nil || !containsString(queryableDocTypes(), doc.DocType) {
+		return nil
+	}
+
+	buckets := []statBucket{{doc.DocType, statTotal, ""}}
+	status := doc.Status
+	if status == "" {
+		status = doc.QCStatus
+	}
+	if status != "" {
+		buckets = append(buckets, statBucket{doc.DocType, statStatus, status})
+	}
+	if doc.GTIN != "" {
+		buckets = append(buckets, statBucket{doc.DocType, statProduct, doc.GTIN})
+	}
+	for _, org := range []string{doc.CurrentOwner, doc.ManufacturerOrg, doc.SenderOrg} {
+		if org != "" {
+			buckets = append(buckets, statBucket{doc.DocType, statOrg, org})
+			break
+		}
+	}
+	return buckets
+}
+
+// statDeltaKey is the key of the delta a transaction records for one counter and item
+func statDeltaKey(ctx contractapi.TransactionContextInterface, bucket statBucket, itemID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(statObjectType, []string{bucket.docType, bucket.dimension, bucket.value, ctx.GetStub().GetTxID(), itemID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create statistics key: %v", err)
+	}
+	return key, nil
+}
+
+// putStatDelta records a change of one counter under its own key, so concurrent transactions never
+// write the same key and cannot conflict
+func (c *PharmaContract) putStatDelta(ctx contractapi.TransactionContextInterface, bucket statBucket, itemID string, delta int) error {
+	key, err := statDeltaKey(ctx, bucket, itemID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(key, []byte(strconv.Itoa(delta)))
+	if err != nil {
+		return fmt.Errorf("failed to update statistics: %v", err)
+	}
+	return nil
+}
+
+// recordStatistics updates the counters for an item whose committed document oldJSON (nil when created)
+// is replaced by newJSON. The deltas carry the change over the whole transaction: when the transaction
+// already wrote the item as writtenJSON, they are rewritten and those of counters left unchanged removed.
+func (c *PharmaContract) recordStatistics(ctx contractapi.TransactionContextInterface, id string, oldJSON []byte, writtenJSON []byte, newJSON []byte) error {
+	deltas := map[statBucket]int{}
+	var order []statBucket
+	count := func(docJSON []byte, delta int) {
+		for _, bucket := range statBuckets(docJSON) {
+			if _, ok := deltas[bucket]; !ok {
+				order = append(order, bucket)
+			}
+			deltas[bucket] += delta
+		}
+	}
+	count(oldJSON, -1)
+	count(newJSON, 1)
+	count(writtenJSON, 0)
+
+	for _, bucket := range order {
+		if deltas[bucket] != 0 {
+			err := c.putStatDelta(ctx, bucket, id, deltas[bucket])
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if writtenJSON == nil {
+			continue
+		}
+		key, err := statDeltaKey(ctx, bucket, id)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return fmt.Errorf("failed to update statistics: %v", err)
+		}
+	}
+	return nil
+}
+
+// sumStatDeltas adds up the deltas stored under a partial statistics key, passing each with its key
+func (c *PharmaContract) sumStatDeltas(ctx contractapi.TransactionContextInterface, attributes []string, add func(key string, bucket statBucket, delta int)) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(statObjectType, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to read statistics: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read statistics: %v", err)
+		}
+		_, parts, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+		if err != nil || len(parts) < 3 {
+			return fmt.Errorf("malformed statistics key %q", kv.Key)
+		}
+		delta, err := strconv.Atoi(string(kv.Value))
+		if err != nil {
+			return fmt.Errorf("malformed statistics delta under %q", kv.Key)
+		}
+		add(kv.Key, statBucket{parts[0], parts[1], parts[2]}, delta)
+	}
+	return nil
+}
+
+// getItemStatistics adds up the counters of one doc type
+func (c *PharmaContract) getItemStatistics(ctx contractapi.TransactionContextInterface, docType string) (*ItemStatistics, error) {
+	stats := newItemStatistics()
+	err := c.sumStatDeltas(ctx, []string{docType}, func(_ string, bucket statBucket, delta int) {
+		stats.add(bucket, delta)
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.dropEmptyBuckets()
+	return stats, nil
+}
+
+// GetStatistics returns counts for all item types. It adds up every delta written since the doc type was
+// last pruned, so PruneStatistics should be run regularly to keep it fast.
+func (c *PharmaContract) GetStatistics(ctx contractapi.TransactionContextInterface) (map[string]int, error) {
+	docTypes := []string{DocTypeStrip, DocTypeBox, DocTypeCarton, DocTypePallet, DocTypeShipment, DocTypeOrder}
+	keys := []string{"strips", "boxes", "cartons", "pallets", "shipments", "orders"}
+
+	stats := map[string]int{}
+	for i, docType := range docTypes {
+		total := 0
+		err := c.sumStatDeltas(ctx, []string{docType, statTotal}, func(_ string, _ statBucket, delta int) {
+			total += delta
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats[keys[i]] = total
+	}
+
+	return stats, nil
+}
+
+// GetItemStatistics returns the counts of one doc type, broken down by status, product and owning organization
+func (c *PharmaContract) GetItemStatistics(ctx contractapi.TransactionContextInterface, docType string) (*ItemStatistics, error) {
+	if !containsString(queryableDocTypes(), docType) {
+		return nil, fmt.Errorf("no statistics are kept for doc type %s", docType)
+	}
+	return c.getItemStatistics(ctx, docType)
+}
+
+// GetStatisticsBreakdown returns the counts of every counted doc type, keyed by doc type
+func (c *PharmaContract) GetStatisticsBreakdown(ctx contractapi.TransactionContextInterface) (map[string]*ItemStatistics, error) {
+	breakdown := map[string]*ItemStatistics{}
+	for _, docType := range queryableDocTypes() {
+		stats, err := c.getItemStatistics(ctx, docType)
+		if err != nil {
+			return nil, err
+		}
+		breakdown[docType] = stats
+	}
+	return breakdown, nil
+}
+
+// RebuildStatistics recounts every counted document from the world state and replaces all deltas with
+// one per counter. It is needed once for ledgers holding items from before counters were kept, and
+// compacts the deltas afterwards. Transactions committed while it runs make it fail with an MVCC
+// conflict, in which case it can simply be retried.
+func (c *PharmaContract) RebuildStatistics(ctx contractapi.TransactionContextInterface) (map[string]*ItemStatistics, error) {
+	if err := c.checkAccess(ctx, "RebuildStatistics"); err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(statObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read statistics: %v", err)
+	}
+	var oldKeys []string
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			resultsIterator.Close()
+			return nil, fmt.Errorf("failed to read statistics: %v", err)
+		}
+		oldKeys = append(oldKeys, kv.Key)
+	}
+	resultsIterator.Close()
+	for _, key := range oldKeys {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to clear statistics: %v", err)
+		}
+	}
+
+	// Count every document in one pass over the world state
+	counts := map[statBucket]int{}
+	var order []statBucket
+	err = c.scanAllDocs(ctx, func(_ string, docJSON []byte) error {
+		for _, bucket := range statBuckets(docJSON) {
+			if _, ok := counts[bucket]; !ok {
+				order = append(order, bucket)
+			}
+			counts[bucket]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := map[string]*ItemStatistics{}
+	for _, docType := range queryableDocTypes() {
+		breakdown[docType] = newItemStatistics()
+	}
+	for _, bucket := range order {
+		err = c.putStatDelta(ctx, bucket, statRebuildID, counts[bucket])
+		if err != nil {
+			return nil, err
+		}
+		breakdown[bucket.docType].add(bucket, counts[bucket])
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventStatisticsRebuilt, Details: breakdown})
+	if err != nil {
+		return nil, err
+	}
+
+	return breakdown, nil
+}
+
+// PruneStatistics compacts the deltas of one doc type, like the prune function of the high-throughput
+// sample: every delta row is deleted and each counter is written back as a single row holding its value.
+// Counts are unchanged, but GetStatistics then reads one row per counter instead of one per write.
+// Deltas committed while it runs make it fail with an MVCC conflict, in which case it can be retried.
+func (c *PharmaContract) PruneStatistics(ctx contractapi.TransactionContextInterface, docType string) (*ItemStatistics, error) {
+	if err := c.checkAccess(ctx, "PruneStatistics"); err != nil {
+		return nil, err
+	}
+	if !containsString(queryableDocTypes(), docType) {
+		return nil, fmt.Errorf("no statistics are kept for doc type %s", docType)
+	}
+
+	sums := map[statBucket]int{}
+	var order []statBucket
+	var keys []string
+	err := c.sumStatDeltas(ctx, []string{docType}, func(key string, bucket statBucket, delta int) {
+		if _, ok := sums[bucket]; !ok {
+			order = append(order, bucket)
+		}
+		sums[bucket] += delta
+		keys = append(keys, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		err = ctx.GetStub().DelState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prune statistics: %v", err)
+		}
+	}
+	stats := newItemStatistics()
+	for _, bucket := range order {
+		if sums[bucket] == 0 {
+			continue
+		}
+		err = c.putStatDelta(ctx, bucket, statPruneID, sums[bucket])
+		if err != nil {
+			return nil, err
+		}
+		stats.add(bucket, sums[bucket])
+	}
+
+	err = c.emitEvent(ctx, ItemEvent{Name: EventStatisticsPruned, DocType: docType, Details: map[string]interface{}{"rowsPruned": len(keys), "statistics": stats}})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkStatistics fails the test unless the counters kept from deltas equal a recount of the world state
+func checkStatistics(t *testing.T, l *mockLedger, stage string) {
+	t.Helper()
+	var kept, rebuilt, after map[string]*ItemStatistics
+	var totals map[string]int
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		kept, err = contract.GetStatisticsBreakdown(ctx)
+		if err != nil {
+			return err
+		}
+		totals, err = contract.GetStatistics(ctx)
+		return err
+	})
+	mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+		var err error
+		rebuilt, err = contract.RebuildStatistics(ctx)
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		after, err = contract.GetStatisticsBreakdown(ctx)
+		return err
+	})
+
+	if !reflect.DeepEqual(kept, rebuilt) || !reflect.DeepEqual(after, rebuilt) {
+		for _, docType := range queryableDocTypes() {
+			t.Errorf("%s: %s kept %+v, rebuilt %+v, after rebuild %+v", stage, docType, kept[docType], rebuilt[docType], after[docType])
+		}
+	}
+	keys := map[string]string{"strips": DocTypeStrip, "boxes": DocTypeBox, "cartons": DocTypeCarton, "pallets": DocTypePallet, "shipments": DocTypeShipment, "orders": DocTypeOrder}
+	for key, docType := range keys {
+		if totals[key] != rebuilt[docType].Total {
+			t.Errorf("%s: GetStatistics counts %d %s, rebuilt %d", stage, totals[key], key, rebuilt[docType].Total)
+		}
+	}
+}
+
+// statRows counts the statistics rows kept for a doc type
+func statRows(l *mockLedger, docType string) int {
+	rows := 0
+	for key := range l.state {
+		if strings.HasPrefix(key, "\x00"+statObjectType+"\x00"+docType+"\x00") {
+			rows++
+		}
+	}
+	return rows
+}
+
+func TestStatisticsMatchRebuild(t *testing.T) {
+	l := newLedger()
+	buildCarton(t, l, "C1", "B1")
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateStrip(ctx, "LOOSE", "B1", "", "2025-01-01", "2027-01-01")
+		return err
+	})
+	checkStatistics(t, l, "create")
+
+	buildShipment(t, l, "P1", "B2")
+	buildShipment(t, l, "P2", "B2")
+	checkStatistics(t, l, "seal")
+
+	createOrder(t, l, "O1", "P1-SH")
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	})
+	checkStatistics(t, l, "order")
+
+	mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+		_, err := contract.RecallBatch(ctx, "B2", "contamination")
+		return err
+	})
+	checkStatistics(t, l, "recall")
+
+	var stats *ItemStatistics
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		stats, err = contract.GetItemStatistics(ctx, DocTypeStrip)
+		return err
+	})
+	want := map[string]int{StatusSealed: 1, StatusCreated: 1, StatusRecalled: 16}
+	if stats.Total != 18 || !reflect.DeepEqual(stats.ByStatus, want) || stats.ByOrg["Org1MSP"] != 18 {
+		t.Errorf("strip statistics %+v", stats)
+	}
+}
+
+func TestPruneStatistics(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	createOrder(t, l, "O1", "P1-SH")
+
+	var before, pruned, after *ItemStatistics
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		before, err = contract.GetItemStatistics(ctx, DocTypeStrip)
+		return err
+	})
+	rows := statRows(l, DocTypeStrip)
+
+	expectError(t, submit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.PruneStatistics(ctx, DocTypeStrip)
+		return err
+	}), "access denied")
+	expectError(t, submit(t, l, regulator, func(ctx *mockCtx) error {
+		_, err := contract.PruneStatistics(ctx, "config")
+		return err
+	}), "no statistics are kept for doc type config")
+
+	mustSubmit(t, l, regulator, func(ctx *mockCtx) error {
+		var err error
+		pruned, err = contract.PruneStatistics(ctx, DocTypeStrip)
+		return err
+	})
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		var err error
+		after, err = contract.GetItemStatistics(ctx, DocTypeStrip)
+		return err
+	})
+	if !reflect.DeepEqual(before, pruned) || !reflect.DeepEqual(before, after) {
+		t.Fatalf("statistics %+v became %+v, pruning returned %+v", before, after, pruned)
+	}
+
+	// One row is left per counter: the total, one status, one product and one organization
+	if got := statRows(l, DocTypeStrip); got != 4 || rows <= got {
+		t.Errorf("%d strip statistics rows pruned to %d", rows, got)
+	}
+	name, event := l.lastEvent(t)
+	if name != EventStatisticsPruned || event.DocType != DocTypeStrip {
+		t.Errorf("event %s %+v", name, event)
+	}
+
+	// Deltas written after pruning add to the compacted values
+	mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+		_, err := contract.DispatchOrder(ctx, "O1")
+		return err
+	})
+	checkStatistics(t, l, "dispatch after pruning")
+}
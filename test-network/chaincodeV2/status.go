@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// statusTransitions lists, per doc type, the statuses an item may move to from its current status.
+// Adding a new status means adding it here; every mutating function goes through checkTransition.
+var statusTransitions = map[string]map[string][]string{
+	DocTypeStrip: {
+		StatusCreated:    {StatusSealed, StatusRecalled},
+		StatusSealed:     {StatusCreated, StatusDispatched, StatusRecalled},
+		StatusDispatched: {StatusDelivered, StatusDamaged, StatusRejected, StatusRecalled},
+		StatusDelivered:  {StatusRecalled},
+		StatusDamaged:    {StatusRecalled},
+		StatusRejected:   {StatusRecalled},
+	},
+	DocTypeBox: {
+		StatusCreated:    {StatusSealed},
+		StatusSealed:     {StatusCreated, StatusDispatched},
+		StatusDispatched: {StatusDelivered, StatusPartiallyDelivered, StatusDamaged, StatusRejected},
+	},
+	DocTypeCarton: {
+		StatusCreated:    {StatusSealed},
+		StatusSealed:     {StatusCreated, StatusDispatched},
+		StatusDispatched: {StatusDelivered, StatusPartiallyDelivered, StatusDamaged, StatusRejected},
+	},
+	DocTypeShipment: {
+		StatusCreated:    {StatusInOrder, StatusShipped, StatusSealed},
+		StatusInOrder:    {StatusCreated, StatusDispatched},
+		StatusShipped:    {StatusDispatched}, // Shipments distributed inside an order by earlier chaincode versions
+		StatusSealed:     {StatusCreated, StatusDispatched},
+		StatusDispatched: {StatusDelivered, StatusPartiallyDelivered, StatusDamaged, StatusRejected},
+	},
+	DocTypePallet: {
+		StatusCreated:    {StatusSealed, StatusInOrder},
+		StatusSealed:     {StatusCreated, StatusDispatched},
+		StatusInOrder:    {StatusCreated, StatusDispatched},
+		StatusDispatched: {StatusDelivered, StatusPartiallyDelivered, StatusDamaged, StatusRejected},
+	},
+	DocTypeBatch: {
+		QCStatusPending:  {QCStatusReleased, QCStatusRejected},
+		QCStatusReleased: {StatusRecalled},
+	},
+	DocTypeTransfer: {
+		TransferStatusProposed: {TransferStatusAccepted, TransferStatusRejected},
+	},
+	DocTypeOrder: {
+		StatusCreated:    {StatusDispatched, StatusCancelled},
+		StatusDispatched: {StatusDelivered, StatusPartiallyDelivered, StatusRejected},
+	},
+}
+
+// checkTransition returns an error if an item of docType may not move from status "from" to status "to"
+func checkTransition(docType string, id string, from string, to string) error {
+	transitions, ok := statusTransitions[docType]
+	if !ok {
+		return fmt.Errorf("no status transitions defined for %s", docType)
+	}
+	if !containsString(transitions[from], to) {
+		return fmt.Errorf("invalid status transition for %s %s: %s -> %s", docType, id, from, to)
+	}
+	return nil
+}
+
+// cascadeOrderStatus propagates an order's new status down through everything packed in it. When holder
+// is not empty the contents also pass to the custody and ownership of that organization.
+func (c *PharmaContract) cascadeOrderStatus(ctx contractapi.TransactionContextInterface, order *Order, status string, holder string, now time.Time) error {
+	for _, itemID := range order.ItemIDs {
+		err := c.setStatusDown(ctx, itemID, status, holder, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setStatusDown sets the status of an item and of every item packed in it, handing them to holder if set
+func (c *PharmaContract) setStatusDown(ctx contractapi.TransactionContextInterface, id string, status string, holder string, now time.Time) error {
+	doc, err := c.readDoc(ctx, id)
+	if err != nil {
+		return err
+	}
+	docType := docString(doc, "docType")
+
+	// A recalled strip keeps its RECALLED status wherever its container goes
+	if docType == DocTypeStrip && docString(doc, "status") == StatusRecalled {
+		return nil
+	}
+
+	err = checkTransition(docType, id, docString(doc, "status"), status)
+	if err != nil {
+		return err
+	}
+	doc["status"] = status
+	if holder != "" {
+		handOver(doc, holder)
+	}
+	doc["updatedAt"] = now
+
+	err = c.writeAsset(ctx, id, doc)
+	if err != nil {
+		return err
+	}
+
+	for _, childID := range docChildIDs(doc) {
+		err = c.setStatusDown(ctx, childID, status, holder, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
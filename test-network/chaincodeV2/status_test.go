@@ -0,0 +1,102 @@
+package main
+
+import "testing"
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		docType string
+		from    string
+		to      string
+		valid   bool
+	}{
+		{DocTypeStrip, StatusCreated, StatusSealed, true},
+		{DocTypeStrip, StatusSealed, StatusCreated, true},
+		{DocTypeStrip, StatusDispatched, StatusDamaged, true},
+		{DocTypeStrip, StatusDelivered, StatusRecalled, true},
+		{DocTypeStrip, StatusCreated, StatusDispatched, false},
+		{DocTypeStrip, StatusRecalled, StatusCreated, false},
+		{DocTypeStrip, StatusDispatched, StatusPartiallyDelivered, false},
+		{DocTypeBox, StatusDispatched, StatusPartiallyDelivered, true},
+		{DocTypeBox, StatusCreated, StatusRecalled, false},
+		{DocTypeCarton, StatusSealed, StatusDispatched, true},
+		{DocTypeCarton, StatusDelivered, StatusDispatched, false},
+		{DocTypeShipment, StatusCreated, StatusInOrder, true},
+		{DocTypeShipment, StatusInOrder, StatusCreated, true},
+		{DocTypeShipment, StatusShipped, StatusDispatched, true},
+		{DocTypeShipment, StatusInOrder, StatusShipped, false},
+		{DocTypeShipment, StatusDelivered, StatusCreated, false},
+		{DocTypePallet, StatusInOrder, StatusDispatched, true},
+		{DocTypePallet, StatusCreated, StatusDispatched, false},
+		{DocTypeBatch, QCStatusPending, QCStatusReleased, true},
+		{DocTypeBatch, QCStatusRejected, QCStatusReleased, false},
+		{DocTypeBatch, QCStatusPending, StatusRecalled, false},
+		{DocTypeTransfer, TransferStatusProposed, TransferStatusAccepted, true},
+		{DocTypeTransfer, TransferStatusAccepted, TransferStatusRejected, false},
+		{DocTypeOrder, StatusCreated, StatusCancelled, true},
+		{DocTypeOrder, StatusDispatched, StatusPartiallyDelivered, true},
+		{DocTypeOrder, StatusDispatched, StatusCancelled, false},
+		{DocTypeOrder, StatusDelivered, StatusDispatched, false},
+		{"unknown", StatusCreated, StatusSealed, false},
+	}
+
+	for _, tt := range tests {
+		err := checkTransition(tt.docType, "X1", tt.from, tt.to)
+		if tt.valid && err != nil {
+			t.Errorf("%s %s -> %s: unexpected error: %v", tt.docType, tt.from, tt.to, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s %s -> %s: expected an error", tt.docType, tt.from, tt.to)
+		}
+	}
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+
+	dispatch := func(id *mockIdentity) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.DispatchOrder(ctx, "O1")
+			return err
+		})
+	}
+	deliver := func(id *mockIdentity) error {
+		return submit(t, l, id, func(ctx *mockCtx) error {
+			_, err := contract.DeliverOrder(ctx, "O1")
+			return err
+		})
+	}
+
+	if deliver(org2Pharm) == nil {
+		t.Fatal("delivered an order that does not exist")
+	}
+	createOrder(t, l, "O1", "P1-SH")
+	if deliver(org2Pharm) == nil {
+		t.Fatal("delivered an order that was not dispatched")
+	}
+	expectError(t, dispatch(org2Dist), "held by Org1MSP")
+	if err := dispatch(org1Dist); err != nil {
+		t.Fatal(err)
+	}
+	if dispatch(org1Dist) == nil {
+		t.Fatal("dispatched twice")
+	}
+	for _, id := range []string{"O1", "P1-SH", "P1-C0", "P1-B01", "P1-S011"} {
+		if status := getDoc(t, l, id)["status"]; status != StatusDispatched {
+			t.Errorf("%s is %v after dispatch", id, status)
+		}
+	}
+
+	expectError(t, deliver(org1Dist), "can only be received by Org2MSP")
+	if err := deliver(org2Pharm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delivery cascades down the hierarchy and hands everything to the receiver
+	for _, id := range []string{"O1", "P1-SH", "P1-C1", "P1-B10", "P1-S101"} {
+		doc := getDoc(t, l, id)
+		if doc["status"] != StatusDelivered || doc["currentOwner"] != "Org2MSP" || doc["currentCustodian"] != "Org2MSP" {
+			t.Errorf("%s is %v, held by %v/%v", id, doc["status"], doc["currentOwner"], doc["currentCustodian"])
+		}
+	}
+}
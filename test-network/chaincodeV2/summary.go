@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProductSummary counts the strips of one product in a container
+type ProductSummary struct {
+	GTIN      string `json:"gtin"`
+	Name      string `json:"name"`
+	UnitCount int    `json:"unitCount"`
+}
+
+// ContentSummary describes what a container holds, so it can be answered without walking down to every strip.
+// It is computed when the container is sealed and recomputed whenever its contents change.
+type ContentSummary struct {
+	Products       []ProductSummary `json:"products"`
+	Batches        []string         `json:"batches"`
+	UnitCount      int              `json:"unitCount"`      // Number of strips
+	EarliestExpiry string           `json:"earliestExpiry"` // Earliest strip expiry date (YYYY-MM-DD)
+}
+
+// summarizeStrips builds the content summary of a set of strips
+func summarizeStrips(strips []*Strip) *ContentSummary {
+	summary := &ContentSummary{Products: []ProductSummary{}, Batches: []string{}}
+	for _, strip := range strips {
+		summary.add(&ContentSummary{
+			Products:       []ProductSummary{{GTIN: strip.GTIN, Name: strip.MedicineType, UnitCount: 1}},
+			Batches:        []string{strip.BatchNumber},
+			UnitCount:      1,
+			EarliestExpiry: strip.ExpDate,
+		})
+	}
+	return summary
+}
+
+// mergeSummaries combines the summaries of a container's children into the container's summary
+func mergeSummaries(summaries []*ContentSummary) *ContentSummary {
+	merged := &ContentSummary{Products: []ProductSummary{}, Batches: []string{}}
+	for _, summary := range summaries {
+		merged.add(summary)
+	}
+	return merged
+}
+
+// add folds other into s, keeping products ordered by GTIN and batches sorted
+func (s *ContentSummary) add(other *ContentSummary) {
+	for _, product := range other.Products {
+		found := false
+		for i := range s.Products {
+			if s.Products[i].GTIN == product.GTIN {
+				s.Products[i].UnitCount += product.UnitCount
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.Products = append(s.Products, product)
+		}
+	}
+	sort.Slice(s.Products, func(i, j int) bool {
+		return s.Products[i].GTIN < s.Products[j].GTIN
+	})
+
+	for _, batch := range other.Batches {
+		s.Batches = appendUnique(s.Batches, batch)
+	}
+	sort.Strings(s.Batches)
+
+	s.UnitCount += other.UnitCount
+	if other.EarliestExpiry != "" && (s.EarliestExpiry == "" || other.EarliestExpiry < s.EarliestExpiry) {
+		s.EarliestExpiry = other.EarliestExpiry
+	}
+}
+
+// gtins returns the GTINs of the products in the summary
+func (s *ContentSummary) gtins() []string {
+	var gtins []string
+	for _, product := range s.Products {
+		gtins = appendUnique(gtins, product.GTIN)
+	}
+	return gtins
+}
+
+// getContentSummary returns the stored summary of a strip or container. Containers sealed before
+// summaries existed have theirs computed from their strips.
+func (c *PharmaContract) getContentSummary(ctx contractapi.TransactionContextInterface, id string) (*ContentSummary, error) {
+	var item struct {
+		DocType string          `json:"docType"`
+		Summary *ContentSummary `json:"summary"`
+	}
+	err := c.readAsset(ctx, id, &item)
+	if err != nil {
+		return nil, err
+	}
+	if item.Summary != nil {
+		return item.Summary, nil
+	}
+
+	strips, err := c.readContainedStrips(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeStrips(strips), nil
+}
+
+// summarizeChildren merges the content summaries of a container's children
+func (c *PharmaContract) summarizeChildren(ctx contractapi.TransactionContextInterface, childIDs []string) (*ContentSummary, error) {
+	var summaries []*ContentSummary
+	for _, childID := range childIDs {
+		summary, err := c.getContentSummary(ctx, childID)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return mergeSummaries(summaries), nil
+}
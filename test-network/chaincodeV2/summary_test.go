@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainerSummary(t *testing.T) {
+	l := newLedger()
+	buildShipment(t, l, "P1", "B1")
+	setupBatch(t, l, "B2")
+	mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+		_, err := contract.CreateStrip(ctx, "X", "B2", "", "2025-01-01", "2026-06-01")
+		return err
+	})
+
+	scan := func(id string) *ContentSummary {
+		t.Helper()
+		var trace *TraceResult
+		mustSubmit(t, l, org1Dist, func(ctx *mockCtx) error {
+			var err error
+			trace, err = contract.ScanBarcode(ctx, id)
+			return err
+		})
+		if trace.Summary == nil {
+			t.Fatalf("no summary for %s", id)
+		}
+		return trace.Summary
+	}
+
+	// Sealing stores the summary on every level
+	want := &ContentSummary{
+		Products:       []ProductSummary{{GTIN: testGTIN, Name: "Paracetamol", UnitCount: 8}},
+		Batches:        []string{"B1"},
+		UnitCount:      8,
+		EarliestExpiry: "2027-01-01",
+	}
+	if summary := scan("P1-SH"); !reflect.DeepEqual(summary, want) {
+		t.Fatalf("shipment summary %+v", summary)
+	}
+	if summary := scan("P1-B00"); summary.UnitCount != 2 {
+		t.Errorf("box summary %+v", summary)
+	}
+
+	// Changing contents refreshes the summary of the container and everything above it
+	move := func(parentID string) {
+		mustSubmit(t, l, org1Mfr, func(ctx *mockCtx) error {
+			_, err := contract.MoveChild(ctx, "X", parentID)
+			return err
+		})
+	}
+	move("P1-B00")
+	counts := []struct {
+		id     string
+		units  int
+		expiry string
+	}{
+		{"P1-B00", 3, "2026-06-01"},
+		{"P1-C0", 5, "2026-06-01"},
+		{"P1-C1", 4, "2027-01-01"},
+		{"P1-SH", 9, "2026-06-01"},
+	}
+	for _, count := range counts {
+		if summary := scan(count.id); summary.UnitCount != count.units || summary.EarliestExpiry != count.expiry {
+			t.Errorf("%s summary %+v", count.id, summary)
+		}
+	}
+	if summary := scan("P1-SH"); !reflect.DeepEqual(summary.Batches, []string{"B1", "B2"}) {
+		t.Errorf("shipment batches %v", summary.Batches)
+	}
+
+	move("")
+	if summary := scan("P1-SH"); !reflect.DeepEqual(summary, want) {
+		t.Errorf("shipment summary after taking X out %+v", summary)
+	}
+
+	// Containers sealed before summaries were stored get theirs computed on the fly
+	carton := getDoc(t, l, "P1-C1")
+	delete(carton, "summary")
+	putDoc(t, l, "P1-C1", carton)
+	if summary := scan("P1-C1"); summary.UnitCount != 4 || summary.EarliestExpiry != "2027-01-01" {
+		t.Errorf("legacy carton summary %+v", summary)
+	}
+}